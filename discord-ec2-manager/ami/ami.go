@@ -0,0 +1,156 @@
+package ami
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// The alias !create falls back to when no AMI is given
+const DefaultAlias = "ubuntu-22.04"
+
+// SSM parameter paths must be under this prefix (AWS' public parameters), so !create can't read the bot's other
+// parameters
+const PublicParameterPrefix = "/aws/service/"
+
+// What an AMI ID looks like. Anything else resolved from a parameter is never used or shown.
+var amiIdPattern = regexp.MustCompile(`^ami-[0-9a-f]+$`)
+
+// Well known images, mapped to AWS' public SSM parameters so they resolve to the right AMI ID in every region
+var Aliases = map[string]string{
+	"ubuntu-22.04": "/aws/service/canonical/ubuntu/server/22.04/stable/current/amd64/hvm/ebs-gp2/ami-id",
+	"ubuntu-20.04": "/aws/service/canonical/ubuntu/server/20.04/stable/current/amd64/hvm/ebs-gp2/ami-id",
+	"al2023":       "/aws/service/ami-amazon-linux-latest/al2023-ami-kernel-default-x86_64",
+	"al2":          "/aws/service/ami-amazon-linux-latest/amzn2-ami-hvm-x86_64-gp2",
+	"debian-12":    "/aws/service/debian/release/bookworm/latest/amd64",
+}
+
+// EC2ImageAPI defines the interface for the DescribeImages function.
+type EC2ImageAPI interface {
+	DescribeImages(ctx context.Context,
+		params *ec2.DescribeImagesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error)
+}
+
+// SSMParameterAPI defines the interface for the GetParameter function.
+type SSMParameterAPI interface {
+	GetParameter(ctx context.Context,
+		params *ssm.GetParameterInput,
+		optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error)
+}
+
+// Looks up an AMI ID stored in an SSM parameter, i.e. one of AWS' public /aws/service/... parameters
+func GetParameter(c context.Context, api SSMParameterAPI, input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	return api.GetParameter(c, input)
+}
+
+// Looks up images matching the given owners and filters
+func GetImages(c context.Context, api EC2ImageAPI, input *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	return api.DescribeImages(c, input)
+}
+
+// Resolves one of AWS' public SSM parameters (under PublicParameterPrefix) to the AMI ID it holds
+func resolvePublicParameter(path string, ssmClient SSMParameterAPI) (string, error) {
+	if !strings.HasPrefix(path, PublicParameterPrefix) {
+		return "", fmt.Errorf("only AWS' public SSM parameters (under %s) can be used", PublicParameterPrefix)
+	}
+
+	return resolveParameter(path, ssmClient)
+}
+
+// Resolves an SSM parameter path to the AMI ID it holds
+func resolveParameter(path string, ssmClient SSMParameterAPI) (string, error) {
+	result, err := GetParameter(context.TODO(), ssmClient, &ssm.GetParameterInput{
+		Name: aws.String(path),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return aws.ToString(result.Parameter.Value), nil
+}
+
+// Resolves an owner and name glob (i.e. 099720109477:ubuntu/images/*jammy*) to the newest available matching AMI ID
+func resolveNamePattern(owner string, pattern string, ec2Client EC2ImageAPI) (string, error) {
+	result, err := GetImages(context.TODO(), ec2Client, &ec2.DescribeImagesInput{
+		Owners: []string{owner},
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("name"),
+				Values: []string{pattern},
+			},
+			{
+				Name:   aws.String("state"),
+				Values: []string{"available"},
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if len(result.Images) < 1 {
+		return "", fmt.Errorf("no available images owned by %s match %s", owner, pattern)
+	}
+
+	// CreationDate is ISO 8601, so the newest image sorts last
+	sort.Slice(result.Images, func(i, j int) bool {
+		return aws.ToString(result.Images[i].CreationDate) < aws.ToString(result.Images[j].CreationDate)
+	})
+
+	return aws.ToString(result.Images[len(result.Images)-1].ImageId), nil
+}
+
+// Resolves an AMI ID, alias (ubuntu-22.04), owner:name-glob pair, or SSM parameter path to an AMI ID in the clients' region
+func ResolveAmi(spec string, ec2Client EC2ImageAPI, ssmClient SSMParameterAPI) (amiId string, err error) {
+	if spec == "" {
+		spec = DefaultAlias
+	}
+
+	switch {
+	case strings.HasPrefix(spec, "ami-"):
+		amiId = spec
+	case Aliases[spec] != "":
+		amiId, err = resolveParameter(Aliases[spec], ssmClient)
+	case strings.HasPrefix(spec, "resolve:ssm:"):
+		amiId, err = resolvePublicParameter(strings.TrimPrefix(spec, "resolve:ssm:"), ssmClient)
+	case strings.HasPrefix(spec, "/"):
+		amiId, err = resolvePublicParameter(spec, ssmClient)
+	case strings.Contains(spec, ":"):
+		ownerAndPattern := strings.SplitN(spec, ":", 2)
+		amiId, err = resolveNamePattern(ownerAndPattern[0], ownerAndPattern[1], ec2Client)
+	default:
+		err = errors.New("expected an AMI ID, an alias (" + strings.Join(AliasNames(), ", ") + "), an owner:name-pattern pair, or an SSM parameter path")
+	}
+
+	if err == nil && !amiIdPattern.MatchString(amiId) {
+		amiId, err = "", fmt.Errorf("%s didn't resolve to an AMI ID", spec)
+	}
+	if err != nil {
+		log.Printf("Error resolving AMI %s: %v", spec, err)
+		return "", err
+	}
+
+	log.Printf("Resolved AMI %s to %s", spec, amiId)
+	return amiId, nil
+}
+
+// Returns the known aliases in alphabetical order
+func AliasNames() []string {
+	var names []string
+	for name := range Aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
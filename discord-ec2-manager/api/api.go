@@ -0,0 +1,297 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/botconfig"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/inventory"
+)
+
+// How long a termination can be confirmed for after it's asked for
+const confirmLifetime = 5 * time.Minute
+
+// Profile names and aliases the API will pass on to !create
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// The API key a request was made with
+type Caller struct {
+	Name  string
+	Admin bool
+}
+
+// The bot's commands, as the API calls them. Implemented by the bot so requests follow the same rules as Discord.
+type Operations interface {
+	// Finds an instance by alias or ID
+	Find(name string) (inventory.Instance, bool)
+
+	// Every instance in the bot's inventory
+	All() []inventory.Instance
+
+	Status(targets []inventory.Instance, caller Caller) string
+	Start(targets []inventory.Instance, overrideBudget bool, caller Caller) (message string, ok bool)
+	Stop(targets []inventory.Instance, hibernate bool, caller Caller) (message string, ok bool)
+
+	// Runs the pre-flight checks for a !create command, then creates the instance if they pass and it isn't a dry run
+	Create(command string, caller Caller) (message string, instanceId string, ok bool)
+
+	TerminationSummary(targets []inventory.Instance, finalSnapshot bool, caller Caller) (message string, ok bool)
+	Terminate(targets []inventory.Instance, finalSnapshot bool, caller Caller, reply func(message string))
+
+	// Posts a message about instances to the Discord channels that manage them
+	Post(message string, about ...inventory.Instance)
+}
+
+// The body of every response
+type response struct {
+	Message string `json:"message"`
+	OK      bool   `json:"ok"`
+
+	InstanceId string               `json:"instance_id,omitempty"`
+	Instances  []inventory.Instance `json:"instances,omitempty"`
+
+	// Sent back by DELETE /api/instances/{name}, to confirm the termination with
+	ConfirmToken string    `json:"confirm_token,omitempty"`
+	ConfirmBy    time.Time `json:"confirm_by,omitempty"`
+}
+
+// The body of POST /api/instances
+type createRequest struct {
+	Profile string `json:"profile"`
+	Alias   string `json:"alias,omitempty"`
+	DryRun  bool   `json:"dry_run,omitempty"`
+}
+
+// A termination waiting to be confirmed
+type pendingTermination struct {
+	caller        string
+	instanceId    string
+	finalSnapshot bool
+	expires       time.Time
+}
+
+// Server serves the REST API under /api/, authenticating requests with the keys in the bot's config file
+type Server struct {
+	keys map[string]botconfig.APIKey
+	ops  Operations
+
+	mu      sync.Mutex
+	pending map[string]pendingTermination
+}
+
+// Creates a Server for the given keys
+func NewServer(keys map[string]botconfig.APIKey, ops Operations) *Server {
+	return &Server{keys: keys, ops: ops, pending: map[string]pendingTermination{}}
+}
+
+// Finds the key a request was made with, from its Authorization: Bearer header
+func (a *Server) authenticate(r *http.Request) (Caller, bool) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return Caller{}, false
+	}
+	given := []byte(strings.TrimPrefix(header, "Bearer "))
+
+	for name, key := range a.keys {
+		if subtle.ConstantTimeCompare(given, []byte(key.Key)) == 1 {
+			return Caller{Name: name, Admin: key.Admin}, true
+		}
+	}
+
+	return Caller{}, false
+}
+
+// Writes a JSON response
+func reply(w http.ResponseWriter, code int, body response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		log.Println("Error writing API response:", err)
+	}
+}
+
+// Returns the status code for an operation's result
+func resultCode(ok bool) int {
+	if ok {
+		return http.StatusOK
+	}
+
+	return http.StatusConflict
+}
+
+// Reports whether a query parameter is set to 1 or true
+func queryFlag(r *http.Request, name string) bool {
+	value := r.URL.Query().Get(name)
+	return value == "1" || value == "true"
+}
+
+// Routes a request:
+//
+//	GET    /api/instances                 lists the bot's instances
+//	POST   /api/instances                 creates an instance from a profile
+//	GET    /api/status                    the status of every instance
+//	GET    /api/instances/{name}/status   the status of one instance
+//	POST   /api/instances/{name}/start    starts an instance (?override_budget=1)
+//	POST   /api/instances/{name}/stop     stops an instance (?hibernate=1)
+//	DELETE /api/instances/{name}          asks to terminate an instance (?final_snapshot=1), or confirms it (?confirm=token)
+func (a *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	caller, ok := a.authenticate(r)
+	if !ok {
+		reply(w, http.StatusUnauthorized, response{Message: "A valid API key is needed, as Authorization: Bearer <key>."})
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/"), "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "status" && r.Method == http.MethodGet:
+		reply(w, http.StatusOK, response{Message: a.ops.Status(a.ops.All(), caller), OK: true})
+	case len(parts) == 1 && parts[0] == "instances" && r.Method == http.MethodGet:
+		reply(w, http.StatusOK, response{Message: fmt.Sprintf("%d instance(s)", len(a.ops.All())), OK: true, Instances: a.ops.All()})
+	case len(parts) == 1 && parts[0] == "instances" && r.Method == http.MethodPost:
+		a.create(w, r, caller)
+	case len(parts) >= 2 && parts[0] == "instances":
+		instance, found := a.ops.Find(parts[1])
+		if !found {
+			reply(w, http.StatusNotFound, response{Message: fmt.Sprintf("There's no instance called `%s` in the bot's inventory.", parts[1])})
+			return
+		}
+		a.instance(w, r, caller, instance, parts[2:])
+	default:
+		reply(w, http.StatusNotFound, response{Message: "Unknown endpoint."})
+	}
+}
+
+// Handles the endpoints for a single instance
+func (a *Server) instance(w http.ResponseWriter, r *http.Request, caller Caller, instance inventory.Instance, action []string) {
+	targets := []inventory.Instance{instance}
+
+	switch {
+	case len(action) == 1 && action[0] == "status" && r.Method == http.MethodGet:
+		reply(w, http.StatusOK, response{Message: a.ops.Status(targets, caller), OK: true})
+	case len(action) == 1 && action[0] == "start" && r.Method == http.MethodPost:
+		message, ok := a.ops.Start(targets, queryFlag(r, "override_budget"), caller)
+		if ok {
+			a.ops.Post(fmt.Sprintf(":robot: `%s` started `%s` through the API.\n%s", caller.Name, instance.Name(), message), instance)
+		}
+		reply(w, resultCode(ok), response{Message: message, OK: ok, InstanceId: instance.InstanceId})
+	case len(action) == 1 && action[0] == "stop" && r.Method == http.MethodPost:
+		message, ok := a.ops.Stop(targets, queryFlag(r, "hibernate"), caller)
+		if ok {
+			a.ops.Post(fmt.Sprintf(":robot: `%s` stopped `%s` through the API.\n%s", caller.Name, instance.Name(), message), instance)
+		}
+		reply(w, resultCode(ok), response{Message: message, OK: ok, InstanceId: instance.InstanceId})
+	case len(action) == 0 && r.Method == http.MethodDelete:
+		if token := r.URL.Query().Get("confirm"); token != "" {
+			a.confirmTermination(w, caller, instance, token)
+			return
+		}
+		a.requestTermination(w, caller, instance, queryFlag(r, "final_snapshot"))
+	default:
+		reply(w, http.StatusNotFound, response{Message: "Unknown endpoint."})
+	}
+}
+
+// Creates an instance from one of the profiles in the bot's config file
+func (a *Server) create(w http.ResponseWriter, r *http.Request, caller Caller) {
+	var request createRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || !aliasPattern.MatchString(request.Profile) {
+		reply(w, http.StatusBadRequest, response{Message: `The body must be JSON naming a profile from the bot's config file, i.e. {"profile": "mc"}.`})
+		return
+	}
+	if request.Alias != "" && !aliasPattern.MatchString(request.Alias) {
+		reply(w, http.StatusBadRequest, response{Message: "Aliases can only use letters, numbers, dots, dashes and underscores."})
+		return
+	}
+
+	command := "!create --profile " + request.Profile
+	if request.Alias != "" {
+		command += " -alias " + request.Alias
+	}
+	if request.DryRun {
+		command += " --dry-run"
+	}
+
+	message, instanceId, ok := a.ops.Create(command, caller)
+	if ok && instanceId != "" {
+		a.ops.Post(fmt.Sprintf(":robot: `%s` created `%s` from the `%s` profile through the API.", caller.Name, instanceId, request.Profile), inventory.Instance{InstanceId: instanceId})
+	}
+	reply(w, resultCode(ok), response{Message: message, OK: ok, InstanceId: instanceId})
+}
+
+// Replies with what terminating the instance would do, along with a token to confirm it with
+func (a *Server) requestTermination(w http.ResponseWriter, caller Caller, instance inventory.Instance, finalSnapshot bool) {
+	message, ok := a.ops.TerminationSummary([]inventory.Instance{instance}, finalSnapshot, caller)
+	if !ok {
+		reply(w, http.StatusConflict, response{Message: message})
+		return
+	}
+
+	buffer := make([]byte, 16)
+	_, err := rand.Read(buffer)
+	if err != nil {
+		log.Println("Error generating confirmation token:", err)
+		reply(w, http.StatusInternalServerError, response{Message: "There was an error generating a confirmation token. Please see your bot's error logs for more information."})
+		return
+	}
+	token := hex.EncodeToString(buffer)
+	expires := time.Now().Add(confirmLifetime)
+
+	a.mu.Lock()
+	// Drops terminations nobody confirmed in time
+	for pendingToken, pending := range a.pending {
+		if time.Now().After(pending.expires) {
+			delete(a.pending, pendingToken)
+		}
+	}
+	a.pending[token] = pendingTermination{caller: caller.Name, instanceId: instance.InstanceId, finalSnapshot: finalSnapshot, expires: expires}
+	a.mu.Unlock()
+
+	reply(w, http.StatusOK, response{Message: message + "\nSend the DELETE again with ?confirm=<confirm_token> to terminate.", OK: true, InstanceId: instance.InstanceId, ConfirmToken: token, ConfirmBy: expires})
+}
+
+// Terminates the instance, if the token matches a termination the same key asked for
+func (a *Server) confirmTermination(w http.ResponseWriter, caller Caller, instance inventory.Instance, token string) {
+	a.mu.Lock()
+	pending, found := a.pending[token]
+	if found && pending.caller == caller.Name && pending.instanceId == instance.InstanceId {
+		// Tokens only work once
+		delete(a.pending, token)
+	}
+	a.mu.Unlock()
+
+	if !found || pending.caller != caller.Name || pending.instanceId != instance.InstanceId || time.Now().After(pending.expires) {
+		reply(w, http.StatusForbidden, response{Message: "That confirmation token isn't valid for this instance, or has expired. Send the DELETE without ?confirm to get a new one."})
+		return
+	}
+
+	a.ops.Post(fmt.Sprintf(":robot: `%s` is terminating `%s` through the API.", caller.Name, instance.Name()), instance)
+	targets := []inventory.Instance{instance}
+
+	// Final backups can take a long time, so they're reported in Discord rather than holding the request open
+	if pending.finalSnapshot {
+		go a.ops.Terminate(targets, true, caller, func(message string) {
+			a.ops.Post(message, instance)
+		})
+		reply(w, http.StatusAccepted, response{Message: "Taking final backups before terminating, progress is posted in Discord.", OK: true, InstanceId: instance.InstanceId})
+		return
+	}
+
+	var messages []string
+	a.ops.Terminate(targets, false, caller, func(message string) {
+		messages = append(messages, message)
+		a.ops.Post(message, instance)
+	})
+	reply(w, http.StatusOK, response{Message: strings.Join(messages, "\n"), OK: true, InstanceId: instance.InstanceId})
+}
@@ -0,0 +1,192 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// The tag naming whoever last acted on an instance through the bot
+const LastActionByTag = "LastActionBy"
+
+// How many entries !audit shows at most, so they fit in one Discord message
+const maxShown = 10
+
+// One action taken through the bot
+type Entry struct {
+	Time time.Time `json:"time"`
+
+	// "discord" or "api"
+	Source string `json:"source"`
+
+	// The Discord user (or API key) who asked, and where
+	User      string `json:"user"`
+	UserId    string `json:"user_id,omitempty"`
+	GuildId   string `json:"guild_id,omitempty"`
+	ChannelId string `json:"channel_id,omitempty"`
+
+	// The command's name, i.e. stop, or otp for a one time password (whose arguments are the command it confirmed)
+	Command   string   `json:"command"`
+	Arguments []string `json:"arguments,omitempty"`
+
+	// Aliases (or IDs) of the instances acted on
+	Instances []string `json:"instances,omitempty"`
+
+	// "ok" or "error"
+	Result string `json:"result"`
+
+	CorrelationId string   `json:"correlation_id"`
+	RequestIds    []string `json:"aws_request_ids,omitempty"`
+}
+
+// Log is an append-only JSON Lines file of entries
+type Log struct {
+	path string
+	mu   sync.Mutex
+}
+
+// Returns the audit log at path. The file is created on the first entry.
+func Open(path string) *Log {
+	return &Log{path: path}
+}
+
+// Adds an entry to the end of the log
+func (l *Log) Append(entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// Returns the entries since the given time, oldest first, optionally only those for one instance
+func (l *Log) Query(instance string, since time.Time) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %w", l.path, err)
+		}
+		if entry.Time.Before(since) || (instance != "" && !entry.involves(instance)) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+// Reports whether the entry acted on the instance
+func (e Entry) involves(instance string) bool {
+	for _, name := range e.Instances {
+		if name == instance {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the entries that acted on at least one instance include returns true for
+func Filter(entries []Entry, include func(instance string) bool) []Entry {
+	var filtered []Entry
+	for _, entry := range entries {
+		for _, instance := range entry.Instances {
+			if include(instance) {
+				filtered = append(filtered, entry)
+				break
+			}
+		}
+	}
+
+	return filtered
+}
+
+// Formats entries for !audit, newest last. Only the latest are shown if there are too many for one message.
+func Format(entries []Entry) string {
+	if len(entries) < 1 {
+		return "Nothing has been done through the bot in that time."
+	}
+
+	var lines []string
+	if len(entries) > maxShown {
+		lines = append(lines, fmt.Sprintf("Showing the latest %d of %d actions:", maxShown, len(entries)))
+		entries = entries[len(entries)-maxShown:]
+	}
+
+	for _, entry := range entries {
+		line := fmt.Sprintf("`%s` **%s** `!%s`", entry.Time.UTC().Format("2006-01-02 15:04"), entry.User, strings.Join(append([]string{entry.Command}, entry.Arguments...), " "))
+		if entry.Command == "otp" {
+			// A one time password's arguments are the command it confirmed
+			line = fmt.Sprintf("`%s` **%s** confirmed `%s`", entry.Time.UTC().Format("2006-01-02 15:04"), entry.User, strings.Join(entry.Arguments, " "))
+		}
+		if len(entry.Instances) > 0 {
+			line += fmt.Sprintf(" on `%s`", strings.Join(entry.Instances, "`, `"))
+		}
+		if entry.Source == "api" {
+			line += " via the API"
+		}
+		if entry.Result != "ok" {
+			line += fmt.Sprintf(" :x: (ref `%s`)", entry.CorrelationId)
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// EC2TagAPI defines the interface for the CreateTags function.
+type EC2TagAPI interface {
+	CreateTags(ctx context.Context,
+		params *ec2.CreateTagsInput,
+		optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error)
+}
+
+// Tags the instances with who last acted on them, i.e. LastActionBy=jacob#1234 (!stop)
+func TagLastAction(api EC2TagAPI, instanceIds []string, user string, command string) error {
+	value := fmt.Sprintf("%s (!%s)", user, command)
+	if len(value) > 256 {
+		value = value[:256]
+	}
+
+	_, err := api.CreateTags(context.TODO(), &ec2.CreateTagsInput{
+		Resources: instanceIds,
+		Tags:      []types.Tag{{Key: aws.String(LastActionByTag), Value: aws.String(value)}},
+	})
+	return err
+}
@@ -0,0 +1,281 @@
+package awsclient
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go/middleware"
+
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/botconfig"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/logging"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/metrics"
+)
+
+// Where a client points: an account from the bot's config (empty for the bot's own credentials) and a region
+type Location struct {
+	Account string
+	Region  string
+}
+
+// Returns the location as account/region, or just the region for the bot's own credentials
+func (l Location) String() string {
+	if l.Account == "" {
+		return l.Region
+	}
+
+	return l.Account + "/" + l.Region
+}
+
+// Pool hands out one client per account and region, created on first use and reused afterwards
+type Pool struct {
+	cfg            aws.Config
+	defaultRegion  string
+	defaultAccount string
+	accounts       map[string]botconfig.Account
+
+	mu             sync.Mutex
+	accountConfigs map[string]aws.Config
+	ec2Clients     map[Location]*ec2.Client
+	ssmClients     map[Location]*ssm.Client
+	route53Clients map[string]*route53.Client
+	sqsClients     map[Location]*sqs.Client
+
+	// Set on pools made by ForCommand, which borrow their parent's credentials
+	parent        *Pool
+	correlationId string
+
+	// The request IDs of the calls made by a ForCommand pool's clients, for the audit log
+	requestIdsMu sync.Mutex
+	requestIds   []string
+}
+
+// Creates a Pool from the bot's AWS config and the accounts in its config file. An empty defaultRegion falls back to the AWS config's region.
+func NewPool(cfg aws.Config, defaultRegion string, botCfg *botconfig.Config) *Pool {
+	if defaultRegion == "" {
+		defaultRegion = cfg.Region
+	}
+
+	// Every client (and assumed role) is made from cfg, so they're all counted in the bot's metrics and logged
+	cfg.APIOptions = append(cfg.APIOptions, metrics.CountAWSCalls, logging.LogAWSCalls)
+
+	return &Pool{
+		cfg:            cfg,
+		defaultRegion:  defaultRegion,
+		defaultAccount: botCfg.DefaultAccount,
+		accounts:       botCfg.Accounts,
+		accountConfigs: map[string]aws.Config{},
+		ec2Clients:     map[Location]*ec2.Client{},
+		ssmClients:     map[Location]*ssm.Client{},
+		route53Clients: map[string]*route53.Client{},
+		sqsClients:     map[Location]*sqs.Client{},
+	}
+}
+
+// The account used when a command or instance doesn't name one
+func (p *Pool) DefaultAccount() string {
+	return p.defaultAccount
+}
+
+// The region used when a command or instance doesn't name one, which may depend on the account
+func (p *Pool) DefaultRegion(account string) string {
+	if p.accounts[account].Region != "" {
+		return p.accounts[account].Region
+	}
+
+	return p.defaultRegion
+}
+
+// Returns the names of the configured accounts in alphabetical order
+func (p *Pool) AccountNames() []string {
+	var names []string
+	for name := range p.accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Checks that an account is either empty (the bot's own credentials) or configured
+func (p *Pool) ValidAccount(account string) error {
+	if _, ok := p.accounts[account]; account != "" && !ok {
+		return fmt.Errorf("unknown account %q", account)
+	}
+
+	return nil
+}
+
+// Fills in a location's default account and region
+func (p *Pool) Resolve(location Location) Location {
+	if location.Account == "" {
+		location.Account = p.defaultAccount
+	}
+	if location.Region == "" {
+		location.Region = p.DefaultRegion(location.Account)
+	}
+
+	return location
+}
+
+// Returns a pool whose clients tag their calls with a command's correlation ID, so the calls show up in the log alongside
+// the command. It shares the pool's credentials, but makes its own clients.
+func (p *Pool) ForCommand(correlationId string) *Pool {
+	return &Pool{
+		cfg:            p.cfg,
+		defaultRegion:  p.defaultRegion,
+		defaultAccount: p.defaultAccount,
+		accounts:       p.accounts,
+		ec2Clients:     map[Location]*ec2.Client{},
+		ssmClients:     map[Location]*ssm.Client{},
+		route53Clients: map[string]*route53.Client{},
+		sqsClients:     map[Location]*sqs.Client{},
+		parent:         p,
+		correlationId:  correlationId,
+	}
+}
+
+// Remembers the request ID of every call a client makes. Added to a ForCommand pool's clients.
+func (p *Pool) recordRequestIds(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("RecordRequestIds", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		out, metadata, err := next.HandleInitialize(ctx, in)
+
+		if requestId, ok := awsmiddleware.GetRequestIDMetadata(metadata); ok {
+			p.requestIdsMu.Lock()
+			p.requestIds = append(p.requestIds, requestId)
+			p.requestIdsMu.Unlock()
+		}
+
+		return out, metadata, err
+	}), middleware.After)
+}
+
+// Returns the request IDs of the calls made so far by a ForCommand pool's clients
+func (p *Pool) RequestIds() []string {
+	p.requestIdsMu.Lock()
+	defer p.requestIdsMu.Unlock()
+
+	return append([]string{}, p.requestIds...)
+}
+
+// Returns the AWS config for an account, assuming its role with credentials that are cached and refreshed before they expire.
+// Callers must hold p.mu.
+func (p *Pool) accountConfig(account string) aws.Config {
+	if p.parent != nil {
+		p.parent.mu.Lock()
+		cfg := p.parent.accountConfig(account).Copy()
+		p.parent.mu.Unlock()
+
+		cfg.APIOptions = append(append([]func(*middleware.Stack) error{}, cfg.APIOptions...), logging.Correlate(p.correlationId), p.recordRequestIds)
+		return cfg
+	}
+
+	if account == "" {
+		return p.cfg
+	}
+
+	cfg, ok := p.accountConfigs[account]
+	if ok {
+		return cfg
+	}
+
+	settings := p.accounts[account]
+	sessionName := settings.SessionName
+	if sessionName == "" {
+		sessionName = "discord-ec2-manager"
+	}
+
+	log.Printf("Assuming %s for account %s", settings.RoleArn, account)
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(p.cfg), settings.RoleArn, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = sessionName
+		if settings.ExternalId != "" {
+			o.ExternalID = aws.String(settings.ExternalId)
+		}
+	})
+
+	cfg = p.cfg.Copy()
+	cfg.Credentials = aws.NewCredentialsCache(provider)
+	p.accountConfigs[account] = cfg
+
+	return cfg
+}
+
+// Returns the EC2 client for an account and region
+func (p *Pool) EC2(location Location) *ec2.Client {
+	location = p.Resolve(location)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	client, ok := p.ec2Clients[location]
+	if !ok {
+		client = ec2.NewFromConfig(p.accountConfig(location.Account), func(o *ec2.Options) {
+			o.Region = location.Region
+		})
+		p.ec2Clients[location] = client
+	}
+
+	return client
+}
+
+// Returns the SSM client for an account and region
+func (p *Pool) SSM(location Location) *ssm.Client {
+	location = p.Resolve(location)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	client, ok := p.ssmClients[location]
+	if !ok {
+		client = ssm.NewFromConfig(p.accountConfig(location.Account), func(o *ssm.Options) {
+			o.Region = location.Region
+		})
+		p.ssmClients[location] = client
+	}
+
+	return client
+}
+
+// Returns the Route 53 client for an account. Route 53 is global, so it isn't tied to a region.
+func (p *Pool) Route53(account string) *route53.Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	client, ok := p.route53Clients[account]
+	if !ok {
+		client = route53.NewFromConfig(p.accountConfig(account), func(o *route53.Options) {
+			o.Region = "us-east-1"
+		})
+		p.route53Clients[account] = client
+	}
+
+	return client
+}
+
+// Returns the SQS client for an account and region
+func (p *Pool) SQS(location Location) *sqs.Client {
+	location = p.Resolve(location)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	client, ok := p.sqsClients[location]
+	if !ok {
+		client = sqs.NewFromConfig(p.accountConfig(location.Account), func(o *sqs.Options) {
+			o.Region = location.Region
+		})
+		p.sqsClients[location] = client
+	}
+
+	return client
+}
@@ -0,0 +1,301 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/inventory"
+)
+
+// Tags linking each snapshot back to the instance (and backup) it was taken for
+const (
+	AliasTag      = "discord-ec2-manager:alias"
+	InstanceTag   = "discord-ec2-manager:instance-id"
+	BackupTag     = "discord-ec2-manager:backup"
+	DeviceTag     = "discord-ec2-manager:device"
+	ScheduledTag  = "discord-ec2-manager:scheduled"
+	backupIdStyle = "20060102-150405"
+)
+
+// How often a backup's snapshots are checked while waiting for them to complete
+var pollInterval = 15 * time.Second
+
+// EC2BackupAPI defines the interface for the EC2 functions used to take, list and prune backups.
+type EC2BackupAPI interface {
+	DescribeInstances(ctx context.Context,
+		params *ec2.DescribeInstancesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error)
+
+	DescribeVolumes(ctx context.Context,
+		params *ec2.DescribeVolumesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeVolumesOutput, error)
+
+	DescribeSnapshots(ctx context.Context,
+		params *ec2.DescribeSnapshotsInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeSnapshotsOutput, error)
+
+	CreateSnapshot(ctx context.Context,
+		params *ec2.CreateSnapshotInput,
+		optFns ...func(*ec2.Options)) (*ec2.CreateSnapshotOutput, error)
+
+	DeleteSnapshot(ctx context.Context,
+		params *ec2.DeleteSnapshotInput,
+		optFns ...func(*ec2.Options)) (*ec2.DeleteSnapshotOutput, error)
+}
+
+// One backup of an instance: a snapshot of each of its volumes, taken together
+type Backup struct {
+	Id         string
+	Alias      string
+	InstanceId string
+	Scheduled  bool
+	Started    time.Time
+	Snapshots  []types.Snapshot
+}
+
+// Returns the snapshot of the volume that was attached at device (i.e. /dev/xvda), if the backup has one
+func (b Backup) Snapshot(device string) (types.Snapshot, bool) {
+	for _, snapshot := range b.Snapshots {
+		if tagValue(snapshot.Tags, DeviceTag) == device {
+			return snapshot, true
+		}
+	}
+
+	return types.Snapshot{}, false
+}
+
+// Whether every snapshot in the backup has completed
+func (b Backup) Completed() bool {
+	for _, snapshot := range b.Snapshots {
+		if snapshot.State != types.SnapshotStateCompleted {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns the value of a tag, or an empty string if it isn't set
+func tagValue(tags []types.Tag, key string) string {
+	for _, tag := range tags {
+		if aws.ToString(tag.Key) == key {
+			return aws.ToString(tag.Value)
+		}
+	}
+
+	return ""
+}
+
+// Gets the volumes attached to an instance
+func InstanceVolumes(api EC2BackupAPI, instanceId string) ([]types.Volume, error) {
+	result, err := api.DescribeVolumes(context.TODO(), &ec2.DescribeVolumesInput{
+		Filters: []types.Filter{
+			{Name: aws.String("attachment.instance-id"), Values: []string{instanceId}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Volumes, nil
+}
+
+// Returns the device name a volume is attached to the instance at
+func attachedDevice(volume types.Volume, instanceId string) string {
+	for _, attachment := range volume.Attachments {
+		if aws.ToString(attachment.InstanceId) == instanceId {
+			return aws.ToString(attachment.Device)
+		}
+	}
+
+	return ""
+}
+
+// Starts a backup of every volume attached to the instance, tagging each snapshot with the instance's alias
+func Create(api EC2BackupAPI, instance inventory.Instance, scheduled bool) (Backup, error) {
+	started := time.Now().UTC()
+	backup := Backup{
+		Id:         instance.Name() + "-" + started.Format(backupIdStyle),
+		Alias:      instance.Name(),
+		InstanceId: instance.InstanceId,
+		Scheduled:  scheduled,
+		Started:    started,
+	}
+
+	// Checks the instance still exists, so a terminated one gets a clear error rather than an empty backup
+	_, err := api.DescribeInstances(context.TODO(), &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instance.InstanceId},
+	})
+	if err != nil {
+		return backup, err
+	}
+
+	volumes, err := InstanceVolumes(api, instance.InstanceId)
+	if err != nil {
+		return backup, err
+	}
+	if len(volumes) < 1 {
+		return backup, fmt.Errorf("instance %s has no EBS volumes attached", instance.InstanceId)
+	}
+
+	for _, volume := range volumes {
+		device := attachedDevice(volume, instance.InstanceId)
+		result, err := api.CreateSnapshot(context.TODO(), &ec2.CreateSnapshotInput{
+			VolumeId:    volume.VolumeId,
+			Description: aws.String(fmt.Sprintf("discord-ec2-manager backup of %s (%s)", instance.Name(), device)),
+			TagSpecifications: []types.TagSpecification{
+				{
+					ResourceType: types.ResourceTypeSnapshot,
+					Tags: []types.Tag{
+						{Key: aws.String("Name"), Value: aws.String(backup.Id)},
+						{Key: aws.String(AliasTag), Value: aws.String(backup.Alias)},
+						{Key: aws.String(InstanceTag), Value: aws.String(instance.InstanceId)},
+						{Key: aws.String(BackupTag), Value: aws.String(backup.Id)},
+						{Key: aws.String(DeviceTag), Value: aws.String(device)},
+						{Key: aws.String(ScheduledTag), Value: aws.String(fmt.Sprint(scheduled))},
+					},
+				},
+			},
+		})
+		if err != nil {
+			return backup, err
+		}
+
+		log.Printf("Started snapshot %s of %s (%s) for %s", aws.ToString(result.SnapshotId), aws.ToString(volume.VolumeId), device, instance.Name())
+		backup.Snapshots = append(backup.Snapshots, types.Snapshot{
+			SnapshotId: result.SnapshotId,
+			VolumeId:   result.VolumeId,
+			VolumeSize: result.VolumeSize,
+			State:      result.State,
+			Progress:   aws.String("0%"),
+			Tags:       []types.Tag{{Key: aws.String(DeviceTag), Value: aws.String(device)}},
+		})
+	}
+
+	return backup, nil
+}
+
+// Describes how far along each of a backup's snapshots are
+func Progress(backup Backup) string {
+	var lines []string
+	for _, snapshot := range backup.Snapshots {
+		emoji := ":hourglass_flowing_sand:"
+		switch snapshot.State {
+		case types.SnapshotStateCompleted:
+			emoji = ":white_check_mark:"
+		case types.SnapshotStateError:
+			emoji = ":x:"
+		}
+
+		lines = append(lines, fmt.Sprintf("%s `%s` of `%s` (%d GiB): %s", emoji, aws.ToString(snapshot.SnapshotId), tagValue(snapshot.Tags, DeviceTag), aws.ToInt32(snapshot.VolumeSize), aws.ToString(snapshot.Progress)))
+	}
+
+	return fmt.Sprintf("**Backup `%s` of `%s`**\n", backup.Id, backup.Alias) + strings.Join(lines, "\n")
+}
+
+// Polls a backup's snapshots until they've all completed (or one fails), calling report with the progress after each poll
+func Wait(api EC2BackupAPI, backup Backup, report func(backup Backup)) (Backup, error) {
+	var snapshotIds []string
+	for _, snapshot := range backup.Snapshots {
+		snapshotIds = append(snapshotIds, aws.ToString(snapshot.SnapshotId))
+	}
+
+	for {
+		time.Sleep(pollInterval)
+
+		result, err := api.DescribeSnapshots(context.TODO(), &ec2.DescribeSnapshotsInput{
+			SnapshotIds: snapshotIds,
+		})
+		if err != nil {
+			return backup, err
+		}
+		backup.Snapshots = result.Snapshots
+		report(backup)
+
+		for _, snapshot := range backup.Snapshots {
+			if snapshot.State == types.SnapshotStateError {
+				return backup, fmt.Errorf("snapshot %s failed: %s", aws.ToString(snapshot.SnapshotId), aws.ToString(snapshot.StateMessage))
+			}
+		}
+		if backup.Completed() {
+			return backup, nil
+		}
+	}
+}
+
+// Lists the bot's backups in the client's account and region, newest first. An empty alias lists every instance's backups.
+func List(api EC2BackupAPI, alias string) ([]Backup, error) {
+	filters := []types.Filter{
+		{Name: aws.String("tag-key"), Values: []string{BackupTag}},
+	}
+	if alias != "" {
+		filters = append(filters, types.Filter{Name: aws.String("tag:" + AliasTag), Values: []string{alias}})
+	}
+
+	input := &ec2.DescribeSnapshotsInput{
+		OwnerIds: []string{"self"},
+		Filters:  filters,
+	}
+
+	backups := map[string]*Backup{}
+	paginator := ec2.NewDescribeSnapshotsPaginator(api, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, snapshot := range page.Snapshots {
+			id := tagValue(snapshot.Tags, BackupTag)
+			backup, ok := backups[id]
+			if !ok {
+				backup = &Backup{
+					Id:         id,
+					Alias:      tagValue(snapshot.Tags, AliasTag),
+					InstanceId: tagValue(snapshot.Tags, InstanceTag),
+					Scheduled:  tagValue(snapshot.Tags, ScheduledTag) == "true",
+					Started:    aws.ToTime(snapshot.StartTime),
+				}
+				backups[id] = backup
+			}
+
+			if snapshot.StartTime != nil && snapshot.StartTime.Before(backup.Started) {
+				backup.Started = *snapshot.StartTime
+			}
+			backup.Snapshots = append(backup.Snapshots, snapshot)
+		}
+	}
+
+	var sorted []Backup
+	for _, backup := range backups {
+		sorted = append(sorted, *backup)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Started.After(sorted[j].Started)
+	})
+
+	return sorted, nil
+}
+
+// Deletes every snapshot in a backup
+func Delete(api EC2BackupAPI, backup Backup) error {
+	for _, snapshot := range backup.Snapshots {
+		_, err := api.DeleteSnapshot(context.TODO(), &ec2.DeleteSnapshotInput{
+			SnapshotId: snapshot.SnapshotId,
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf("Deleted snapshot %s from backup %s", aws.ToString(snapshot.SnapshotId), backup.Id)
+	}
+
+	return nil
+}
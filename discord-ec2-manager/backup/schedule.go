@@ -0,0 +1,119 @@
+package backup
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/awsclient"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/botconfig"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/inventory"
+)
+
+// How often the schedule is checked for instances that are due a backup
+const scheduleCheckInterval = 15 * time.Minute
+
+// Whether the schedule covers an instance
+func scheduled(settings botconfig.Backups, instance inventory.Instance) bool {
+	if len(settings.Instances) < 1 {
+		return true
+	}
+
+	for _, name := range settings.Instances {
+		if name == instance.Alias || name == instance.InstanceId {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Backs up each scheduled instance every interval_hours and prunes scheduled backups past their retention. The time of
+// each instance's last backup comes from its snapshots, so restarting the bot doesn't reset the schedule. Runs until the bot exits.
+func Schedule(settings botconfig.Backups, inv *inventory.Inventory, clients *awsclient.Pool, post func(message string, about ...inventory.Instance)) {
+	if settings.IntervalHours <= 0 {
+		return
+	}
+
+	interval := time.Duration(settings.IntervalHours) * time.Hour
+	log.Println("Backing up instances every", interval)
+	for {
+		for _, instance := range inv.All() {
+			if scheduled(settings, instance) {
+				backUpIfDue(instance, interval, clients.EC2(instance.Location()), post)
+			}
+		}
+		prune(settings, inv, clients)
+
+		time.Sleep(scheduleCheckInterval)
+	}
+}
+
+// Starts a scheduled backup of the instance if its last one is older than interval, posting when it's done
+func backUpIfDue(instance inventory.Instance, interval time.Duration, api EC2BackupAPI, post func(message string, about ...inventory.Instance)) {
+	backups, err := List(api, instance.Name())
+	if err != nil {
+		log.Printf("Error listing backups of %s: %v", instance.Name(), err)
+		return
+	}
+
+	for _, backup := range backups {
+		if backup.Scheduled && time.Since(backup.Started) < interval {
+			return
+		}
+	}
+
+	backup, err := Create(api, instance, true)
+	if err != nil {
+		log.Printf("Error starting scheduled backup of %s: %v", instance.Name(), err)
+		post(fmt.Sprintf(":x: There was an error starting the scheduled backup of `%s`. Please see the bot's error logs for more information.", instance.Name()), instance)
+		return
+	}
+
+	go func() {
+		backup, err := Wait(api, backup, func(Backup) {})
+		if err != nil {
+			log.Printf("Error waiting for backup %s: %v", backup.Id, err)
+			post(fmt.Sprintf(":x: The scheduled backup `%s` of `%s` failed. Please see the bot's error logs for more information.", backup.Id, instance.Name()), instance)
+			return
+		}
+		post(fmt.Sprintf(":floppy_disk: Scheduled backup `%s` of `%s` completed.", backup.Id, instance.Name()), instance)
+	}()
+}
+
+// Deletes scheduled backups older than retention_days, always keeping each instance's newest few
+func prune(settings botconfig.Backups, inv *inventory.Inventory, clients *awsclient.Pool) {
+	if settings.RetentionDays <= 0 {
+		return
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -settings.RetentionDays)
+	locations := inventory.ByLocation(append(inv.All(), inv.AllRetired()...))
+	for _, location := range inventory.SortedLocations(locations) {
+		api := clients.EC2(location)
+		backups, err := List(api, "")
+		if err != nil {
+			log.Printf("Error listing backups in %s: %v", location, err)
+			continue
+		}
+
+		// Backups are newest first, so each alias's first few are the ones to keep
+		kept := map[string]int{}
+		for _, backup := range backups {
+			if !backup.Scheduled {
+				continue
+			}
+
+			kept[backup.Alias]++
+			if kept[backup.Alias] <= settings.Keep || backup.Started.After(cutoff) || !backup.Completed() {
+				continue
+			}
+
+			log.Printf("Pruning backup %s of %s, taken %s", backup.Id, backup.Alias, backup.Started)
+			err = Delete(api, backup)
+			if err != nil {
+				log.Printf("Error pruning backup %s: %v", backup.Id, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,389 @@
+package botconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"text/template"
+)
+
+// An AWS account the bot manages instances in, by assuming a role in it
+type Account struct {
+	RoleArn     string `json:"role_arn"`
+	ExternalId  string `json:"external_id,omitempty"`
+	SessionName string `json:"session_name,omitempty"`
+
+	// Optional, the region used for this account when a command doesn't name one
+	Region string `json:"region,omitempty"`
+}
+
+// Settings read from the bot's JSON config file (-cfg)
+type Config struct {
+	// The account used when a command doesn't name one. Empty means the bot's own credentials.
+	DefaultAccount string `json:"default_account,omitempty"`
+
+	Accounts map[string]Account `json:"accounts,omitempty"`
+
+	// Hourly on-demand prices in USD by region then instance type, on top of the bundled table. A "*" region applies everywhere.
+	Prices map[string]map[string]float64 `json:"prices,omitempty"`
+
+	// Monthly spending limits
+	Budget Budget `json:"budget,omitempty"`
+
+	// Scheduled EBS snapshots and how long they're kept
+	Backups Backups `json:"backups,omitempty"`
+
+	// Publishes a stable hostname for each instance when it starts
+	DNS DNS `json:"dns,omitempty"`
+
+	// Settings for !allow and !deny
+	Allowlist Allowlist `json:"allowlist,omitempty"`
+
+	// The bot's HTTP server, used for !allow's me-links, the REST API and Prometheus metrics
+	HTTP HTTP `json:"http,omitempty"`
+
+	// Posts instance state changes and scheduled maintenance the bot didn't cause
+	Watch Watch `json:"watch,omitempty"`
+
+	// Pre-approved commands !run can send to instances
+	Run Run `json:"run,omitempty"`
+
+	// Named sets of !create flags, used with !create --profile or the HTTP API, i.e. "mc": "-it t3.large -alias mc"
+	CreateProfiles map[string]string `json:"create_profiles,omitempty"`
+
+	// The REST API served alongside the bot's HTTP server
+	API API `json:"api,omitempty"`
+
+	// How the bot's logs are written
+	Logging Logging `json:"logging,omitempty"`
+
+	// Where every action taken through the bot is recorded
+	Audit Audit `json:"audit,omitempty"`
+
+	// Discord user IDs allowed to override the bot's guardrails (i.e. --override-budget)
+	Admins []string `json:"admins,omitempty"`
+
+	// Discord channels the bot takes commands in, by channel ID. Messages anywhere else are ignored. The -c channel
+	// always takes commands for every instance, unless it's listed here too.
+	Channels map[string]Channel `json:"channels,omitempty"`
+}
+
+// A Discord channel the bot takes commands in, and what can be done from it. Channel IDs are unique across Discord
+// servers, so one bot can serve channels in several.
+type Channel struct {
+	// Shown in the bot's logs, i.e. "mc-server"
+	Name string `json:"name,omitempty"`
+
+	// Aliases or instance IDs that can be managed from the channel. Empty means every instance.
+	Instances []string `json:"instances,omitempty"`
+
+	// Names from create_profiles that !create must use here. Empty means !create isn't limited to profiles.
+	Profiles []string `json:"profiles,omitempty"`
+
+	// Discord user IDs who are admins in this channel, on top of admins
+	Admins []string `json:"admins,omitempty"`
+
+	// Commands that can be used here, without the !, i.e. ["status", "start", "stop"]. Empty means every command.
+	Commands []string `json:"commands,omitempty"`
+}
+
+// The bot's log format and level. The bot token, API keys and one time passwords are redacted from every line.
+type Logging struct {
+	// "text" (the default) or "json"
+	Format string `json:"format,omitempty"`
+
+	// The lowest level logged: "debug", "info" (the default), "warn" or "error". AWS calls are logged at debug.
+	Level string `json:"level,omitempty"`
+
+	// The file one time passwords are written to (readable only by the bot's user), defaults to otp.log. They're never
+	// written to the log.
+	OtpFile string `json:"otp_file,omitempty"`
+}
+
+// The audit log !audit reads, a JSON Lines file that's only ever appended to
+type Audit struct {
+	// Defaults to audit.jsonl
+	Path string `json:"path,omitempty"`
+}
+
+// Monthly spending limits in USD, checked against !cost's estimates
+type Budget struct {
+	// Limit for every instance together, 0 for no limit
+	Monthly float64 `json:"monthly,omitempty"`
+
+	// Limits for single instances, keyed by alias or instance ID
+	Instances map[string]float64 `json:"instances,omitempty"`
+
+	// Stops running instances once their budget is used up
+	AutoStop bool `json:"auto_stop,omitempty"`
+
+	// How often budgets are checked in the background, defaults to 15
+	CheckIntervalMinutes int `json:"check_interval_minutes,omitempty"`
+}
+
+// Scheduled backups of the instances' volumes. Backups taken with !backup are never pruned.
+type Backups struct {
+	// Hours between scheduled backups of each instance, 0 turns them off
+	IntervalHours int `json:"interval_hours,omitempty"`
+
+	// Aliases (or instance IDs) to back up, empty for every instance
+	Instances []string `json:"instances,omitempty"`
+
+	// Scheduled backups older than this many days are deleted, 0 keeps them forever
+	RetentionDays int `json:"retention_days,omitempty"`
+
+	// How many of each instance's newest scheduled backups are kept, however old they are
+	Keep int `json:"keep,omitempty"`
+}
+
+// Dynamic DNS, pointing <alias>.<zone> at an instance's public IP whenever it starts. Instances without an alias are skipped.
+type DNS struct {
+	// "route53" or "rfc2136", empty turns dynamic DNS off
+	Provider string `json:"provider,omitempty"`
+
+	// The zone hostnames are created in, i.e. games.example.com
+	Zone string `json:"zone,omitempty"`
+
+	// The records' TTL in seconds, defaults to 60
+	TTL int64 `json:"ttl,omitempty"`
+
+	// Route 53 only: the hosted zone's ID, and the account from accounts it lives in (empty for the bot's own credentials)
+	HostedZoneId string `json:"hosted_zone_id,omitempty"`
+	Account      string `json:"account,omitempty"`
+
+	// RFC 2136 only: the DNS server (host:port) to send updates to, and an optional TSIG key to sign them with
+	Server        string `json:"server,omitempty"`
+	TsigKeyName   string `json:"tsig_key_name,omitempty"`
+	TsigSecret    string `json:"tsig_secret,omitempty"`
+	TsigAlgorithm string `json:"tsig_algorithm,omitempty"`
+}
+
+// Ingress rules players can add for themselves with !allow
+type Allowlist struct {
+	// The port rules open, defaults to the -sp service port
+	Port int32 `json:"port,omitempty"`
+
+	// "tcp" (the default), "udp" or "-1" for all protocols
+	Protocol string `json:"protocol,omitempty"`
+
+	// How long rules last when !allow doesn't say, 0 for rules that never expire
+	DefaultExpiryHours int `json:"default_expiry_hours,omitempty"`
+}
+
+// The bot's HTTP server, off unless listen is set
+type HTTP struct {
+	// The address to listen on, i.e. :8080
+	Listen string `json:"listen,omitempty"`
+
+	// The URL players reach the server at, i.e. https://bot.example.com
+	PublicUrl string `json:"public_url,omitempty"`
+
+	// Reads visitors' IPs from X-Forwarded-For, for when the bot sits behind a load balancer or reverse proxy
+	TrustProxy bool `json:"trust_proxy,omitempty"`
+
+	// Serves Prometheus metrics at /metrics
+	Metrics bool `json:"metrics,omitempty"`
+}
+
+// The bot's REST API, off unless it has at least one key. It's served by the HTTP server, so http.listen must be set too.
+type API struct {
+	// API keys by name. The name is shown in Discord when the key is used.
+	Keys map[string]APIKey `json:"keys,omitempty"`
+}
+
+// A key HTTP API requests authenticate with, as "Authorization: Bearer <key>"
+type APIKey struct {
+	Key string `json:"key"`
+
+	// Lets the key override the bot's guardrails, like the bot's admins
+	Admin bool `json:"admin,omitempty"`
+}
+
+// How the bot finds out about state changes and scheduled events. It polls EC2 unless queue_url is set.
+type Watch struct {
+	// Turns the watcher off
+	Disabled bool `json:"disabled,omitempty"`
+
+	// How often EC2 is polled, defaults to 60
+	IntervalSeconds int `json:"interval_seconds,omitempty"`
+
+	// An SQS queue EventBridge sends EC2 state-change (and AWS Health) events to, read instead of polling
+	QueueUrl string `json:"queue_url,omitempty"`
+
+	// The account from accounts the queue lives in, empty for the bot's own credentials
+	QueueAccount string `json:"queue_account,omitempty"`
+}
+
+// Named commands !run sends to instances with SSM Run Command
+type Run struct {
+	// Discord role IDs allowed to use actions that don't list their own roles. Admins can always use every action.
+	Roles []string `json:"roles,omitempty"`
+
+	Actions map[string]Action `json:"actions,omitempty"`
+}
+
+// A shell command template !run can send, i.e. "systemctl restart {{.service}}"
+type Action struct {
+	// Shown by !run when listing actions
+	Description string `json:"description,omitempty"`
+
+	// A Go text/template. {{.alias}} and {{.instance_id}} are always set, along with each of params.
+	Command string `json:"command"`
+
+	// Names of the arguments given after the action's name, in order
+	Params []string `json:"params,omitempty"`
+
+	// Discord role IDs allowed to use this action, instead of run.roles
+	Roles []string `json:"roles,omitempty"`
+
+	// The SSM document the command is sent with, defaults to AWS-RunShellScript
+	Document string `json:"document,omitempty"`
+
+	// How long the command may run for, defaults to 60
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+}
+
+// Reports whether a Discord user is one of the bot's admins
+func (c *Config) IsAdmin(userId string) bool {
+	for _, admin := range c.Admins {
+		if admin == userId {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reports whether the channel manages an instance, given its alias and ID
+func (c Channel) Manages(names ...string) bool {
+	if len(c.Instances) < 1 {
+		return true
+	}
+
+	for _, name := range names {
+		if name != "" && contains(c.Instances, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reports whether !create can use a profile in the channel. No profile is allowed only if the channel lists none.
+func (c Channel) AllowsProfile(name string) bool {
+	if len(c.Profiles) < 1 {
+		return true
+	}
+
+	return name != "" && contains(c.Profiles, name)
+}
+
+// Reports whether a command (without the !) can be used in the channel
+func (c Channel) AllowsCommand(name string) bool {
+	return len(c.Commands) < 1 || contains(c.Commands, name)
+}
+
+// Reports whether a Discord user is one of the channel's own admins
+func (c Channel) IsAdmin(userId string) bool {
+	return contains(c.Admins, userId)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Loads the config file at path. An empty path gives an empty config, so every setting in it is optional.
+func Load(path string) (*Config, error) {
+	cfg := &Config{}
+	if path == "" {
+		return cfg, nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(content, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+
+	for name, account := range cfg.Accounts {
+		if account.RoleArn == "" {
+			return nil, fmt.Errorf("account %q in %s is missing a role_arn", name, path)
+		}
+	}
+
+	if _, ok := cfg.Accounts[cfg.DefaultAccount]; cfg.DefaultAccount != "" && !ok {
+		return nil, fmt.Errorf("default_account %q in %s is not one of its accounts", cfg.DefaultAccount, path)
+	}
+
+	switch cfg.DNS.Provider {
+	case "":
+	case "route53":
+		if cfg.DNS.Zone == "" || cfg.DNS.HostedZoneId == "" {
+			return nil, fmt.Errorf("dns in %s needs a zone and hosted_zone_id for route53", path)
+		}
+		if _, ok := cfg.Accounts[cfg.DNS.Account]; cfg.DNS.Account != "" && !ok {
+			return nil, fmt.Errorf("dns account %q in %s is not one of its accounts", cfg.DNS.Account, path)
+		}
+	case "rfc2136":
+		if cfg.DNS.Zone == "" || cfg.DNS.Server == "" {
+			return nil, fmt.Errorf("dns in %s needs a zone and server for rfc2136", path)
+		}
+	default:
+		return nil, fmt.Errorf("dns provider %q in %s must be route53 or rfc2136", cfg.DNS.Provider, path)
+	}
+
+	if _, ok := cfg.Accounts[cfg.Watch.QueueAccount]; cfg.Watch.QueueAccount != "" && !ok {
+		return nil, fmt.Errorf("watch queue_account %q in %s is not one of its accounts", cfg.Watch.QueueAccount, path)
+	}
+
+	switch cfg.Logging.Format {
+	case "", "text", "json":
+	default:
+		return nil, fmt.Errorf("logging format %q in %s must be text or json", cfg.Logging.Format, path)
+	}
+	switch cfg.Logging.Level {
+	case "", "debug", "info", "warn", "error":
+	default:
+		return nil, fmt.Errorf("logging level %q in %s must be debug, info, warn or error", cfg.Logging.Level, path)
+	}
+
+	for name, key := range cfg.API.Keys {
+		if len(key.Key) < 16 {
+			return nil, fmt.Errorf("api key %q in %s must be at least 16 characters", name, path)
+		}
+	}
+	if len(cfg.API.Keys) > 0 && cfg.HTTP.Listen == "" {
+		return nil, fmt.Errorf("api keys in %s need http.listen to be set", path)
+	}
+
+	for channelId, channel := range cfg.Channels {
+		for _, profile := range channel.Profiles {
+			if _, ok := cfg.CreateProfiles[profile]; !ok {
+				return nil, fmt.Errorf("channel %q in %s uses profile %q, which is not one of its create_profiles", channelId, path, profile)
+			}
+		}
+	}
+
+	for name, action := range cfg.Run.Actions {
+		if action.Command == "" {
+			return nil, fmt.Errorf("run action %q in %s is missing a command", name, path)
+		}
+		if _, err := template.New(name).Parse(action.Command); err != nil {
+			return nil, fmt.Errorf("run action %q in %s has an invalid command: %w", name, path, err)
+		}
+	}
+
+	log.Printf("Loaded config from %s with %d account(s)", path, len(cfg.Accounts))
+	return cfg, nil
+}
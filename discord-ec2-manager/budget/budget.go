@@ -0,0 +1,216 @@
+package budget
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/awsclient"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/botconfig"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/cost"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/inventory"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/stop"
+)
+
+// The share of a budget (in percent) at which the channel is warned
+var warningThresholds = []int{50, 80, 100}
+
+// Used in place of an alias for the budget covering every instance
+const globalScope = ""
+
+// How much of one budget has been spent this month
+type Usage struct {
+	// The alias (or instance ID) the budget is for, empty for the monthly budget covering every instance
+	Scope string
+	Limit float64
+	Spent float64
+}
+
+// The share of the budget spent, in percent
+func (u Usage) Percent() float64 {
+	return u.Spent / u.Limit * 100
+}
+
+// Whether the budget has been used up
+func (u Usage) Exceeded() bool {
+	return u.Spent >= u.Limit
+}
+
+// Describes the budget, i.e. "The monthly budget" or "`mc`'s monthly budget"
+func (u Usage) Name() string {
+	if u.Scope == globalScope {
+		return "The monthly budget"
+	}
+
+	return fmt.Sprintf("`%s`'s monthly budget", u.Scope)
+}
+
+// Whether an instance is covered by this budget
+func (u Usage) Covers(instance inventory.Instance) bool {
+	return u.Scope == globalScope || u.Scope == instance.Alias || u.Scope == instance.InstanceId
+}
+
+// Works out how much of each configured budget has been spent this month. Instance budgets include terminated
+// instances that had the same alias, so re-creating an instance doesn't reset its budget.
+func Check(settings botconfig.Budget, inv *inventory.Inventory, prices cost.PriceTable, now time.Time) (usages []Usage) {
+	instances := append(inv.All(), inv.AllRetired()...)
+
+	if settings.Monthly > 0 {
+		usages = append(usages, Usage{
+			Scope: globalScope,
+			Limit: settings.Monthly,
+			Spent: cost.TotalSpend(inv, prices, now).ThisMonth,
+		})
+	}
+
+	for scope, limit := range settings.Instances {
+		if limit <= 0 {
+			continue
+		}
+
+		usage := Usage{Scope: scope, Limit: limit}
+		for _, instance := range instances {
+			if usage.Covers(instance) {
+				spend, _ := cost.InstanceSpend(instance, prices, now)
+				usage.Spent += spend.ThisMonth
+			}
+		}
+		usages = append(usages, usage)
+	}
+
+	return usages
+}
+
+// Returns a reason to refuse starting (or creating) the given instances, or an empty string if every budget covering them
+// still has room. Pass an instance with just an alias to check a !create.
+func Refusal(settings botconfig.Budget, inv *inventory.Inventory, prices cost.PriceTable, targets []inventory.Instance) string {
+	var reasons []string
+	for _, usage := range Check(settings, inv, prices, time.Now()) {
+		if !usage.Exceeded() {
+			continue
+		}
+
+		for _, target := range targets {
+			if usage.Covers(target) {
+				reasons = append(reasons, fmt.Sprintf(":no_entry: %s of $%.2f has been used up ($%.2f spent so far this month).", usage.Name(), usage.Limit, usage.Spent))
+				break
+			}
+		}
+	}
+
+	if len(reasons) < 1 {
+		return ""
+	}
+
+	return strings.Join(reasons, "\n") + "\nAn admin can add `--override-budget` to the command to go ahead anyway."
+}
+
+// Remembers which warnings have already been posted this month, so each threshold is only announced once
+type warningState struct {
+	month  time.Month
+	warned map[string]int
+}
+
+// The instances in the inventory a budget covers, none for the monthly budget covering every instance (so its warnings
+// aren't about any one of them)
+func (u Usage) covered(inv *inventory.Inventory) []inventory.Instance {
+	if u.Scope == globalScope {
+		return nil
+	}
+
+	var covered []inventory.Instance
+	for _, instance := range inv.All() {
+		if u.Covers(instance) {
+			covered = append(covered, instance)
+		}
+	}
+
+	return covered
+}
+
+// Periodically checks the budgets, posting a warning as each threshold is crossed and (with auto_stop) stopping instances
+// whose budget has been used up. Runs until the bot exits.
+func Watch(settings botconfig.Budget, inv *inventory.Inventory, clients *awsclient.Pool, prices cost.PriceTable, post func(message string, about ...inventory.Instance)) {
+	if settings.Monthly <= 0 && len(settings.Instances) < 1 {
+		return
+	}
+
+	interval := time.Duration(settings.CheckIntervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = 15 * time.Minute
+	}
+
+	log.Println("Checking budgets every", interval)
+	state := &warningState{warned: map[string]int{}}
+	for {
+		checkBudgets(settings, inv, clients, prices, state, post)
+		time.Sleep(interval)
+	}
+}
+
+// A single pass of Watch
+func checkBudgets(settings botconfig.Budget, inv *inventory.Inventory, clients *awsclient.Pool, prices cost.PriceTable, state *warningState, post func(message string, about ...inventory.Instance)) {
+	now := time.Now()
+	cost.ReconcileAll(inv, clients, now)
+
+	if now.UTC().Month() != state.month {
+		state.month = now.UTC().Month()
+		state.warned = map[string]int{}
+	}
+
+	for _, usage := range Check(settings, inv, prices, now) {
+		crossed := 0
+		for _, threshold := range warningThresholds {
+			if usage.Percent() >= float64(threshold) {
+				crossed = threshold
+			}
+		}
+
+		if crossed > state.warned[usage.Scope] {
+			state.warned[usage.Scope] = crossed
+			post(fmt.Sprintf(":warning: %s is %d%% used: $%.2f of $%.2f spent this month.", usage.Name(), crossed, usage.Spent, usage.Limit), usage.covered(inv)...)
+		}
+
+		if usage.Exceeded() && settings.AutoStop {
+			autoStop(usage, inv, clients, post)
+		}
+	}
+}
+
+// Stops every running instance covered by a used up budget
+func autoStop(usage Usage, inv *inventory.Inventory, clients *awsclient.Pool, post func(message string, about ...inventory.Instance)) {
+	var running []inventory.Instance
+	for _, instance := range inv.All() {
+		last, ok := instance.LastTransition()
+		if ok && last.State == "running" && usage.Covers(instance) {
+			running = append(running, instance)
+		}
+	}
+
+	locations := inventory.ByLocation(running)
+	for _, location := range inventory.SortedLocations(locations) {
+		instanceIds := locations[location]
+		var stopping []inventory.Instance
+		for _, instance := range running {
+			if instance.Location() == location {
+				stopping = append(stopping, instance)
+			}
+		}
+		log.Printf("%s is used up, stopping %v in %s", usage.Name(), instanceIds, location)
+
+		_, err := stop.StopEc2Instance(instanceIds, clients.EC2(location))
+		if err != nil {
+			post(fmt.Sprintf(":x: %s is used up, but there was an error stopping `%s`. Please see the bot's error logs for more information.", usage.Name(), strings.Join(instanceIds, "`, `")), stopping...)
+			continue
+		}
+
+		for _, instanceId := range instanceIds {
+			err = inv.RecordTransition(instanceId, "stopped", time.Now())
+			if err != nil {
+				log.Println("Error saving inventory:", err)
+			}
+		}
+		post(fmt.Sprintf(":octagonal_sign: %s is used up, stopping `%s`.", usage.Name(), strings.Join(instanceIds, "`, `")), stopping...)
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/api"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/audit"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/awsclient"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/botconfig"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/inventory"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/logging"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/metrics"
+)
+
+// The commands counted in the bot's metrics. Other messages are left out, so chat can't add new labels.
+var commandNames = map[string]bool{
+	"!help": true, "!status": true, "!start": true, "!stop": true, "!create": true, "!terminate": true, "!cost": true,
+	"!backup": true, "!restore": true, "!image": true, "!images": true, "!eip": true, "!allow": true, "!deny": true,
+	"!reboot": true, "!resize": true, "!console": true, "!screenshot": true, "!run": true, "!audit": true,
+}
+
+// Commands that only look at things, so they're left out of the audit log
+var readOnlyCommands = map[string]bool{
+	"help": true, "status": true, "cost": true, "images": true, "console": true, "screenshot": true, "audit": true,
+}
+
+// Who asked for an operation: a Discord user or an HTTP API key. Admins can override the bot's guardrails (i.e.
+// --override-budget).
+type caller api.Caller
+
+// Returns the caller behind a Discord message. The channel's own admins are admins there.
+func discordCaller(m *discordgo.MessageCreate) caller {
+	channel, _ := channelConfig(m.ChannelID)
+	return caller{Name: m.Author.String(), Admin: botCfg.IsAdmin(m.Author.ID) || channel.IsAdmin(m.Author.ID)}
+}
+
+// Returns what can be done from a Discord channel. ok is false if the bot doesn't take commands there.
+func channelConfig(channelId string) (channel botconfig.Channel, ok bool) {
+	if channel, ok := botCfg.Channels[channelId]; ok {
+		return channel, true
+	}
+
+	return botconfig.Channel{}, channelId == ChannelId
+}
+
+// Returns the channels to post about instances in: every configured channel whose instances name one of them, or the
+// -c channel if none do (or there are no instances, i.e. for the monthly budget)
+func notifyChannels(instances ...inventory.Instance) []string {
+	var channelIds []string
+	for channelId, channel := range botCfg.Channels {
+		if len(channel.Instances) < 1 {
+			continue
+		}
+
+		for _, instance := range instances {
+			// Picks up the alias of an instance only known by its ID (i.e. one just created)
+			if current, found := inv.Find(instance.InstanceId); instance.InstanceId != "" && found {
+				instance = current
+			}
+
+			if channel.Manages(instance.Alias, instance.InstanceId) {
+				channelIds = append(channelIds, channelId)
+				break
+			}
+		}
+	}
+
+	if len(channelIds) < 1 {
+		return []string{ChannelId}
+	}
+
+	sort.Strings(channelIds)
+	return channelIds
+}
+
+// Posts a message about instances (i.e. a state change or scheduled backup) to the channels that manage them
+func postAbout(s *discordgo.Session, message string, instances ...inventory.Instance) {
+	for _, channelId := range notifyChannels(instances...) {
+		_, err := s.ChannelMessageSend(channelId, message)
+		if err != nil {
+			log.Println("Error sending message:", err)
+		}
+	}
+}
+
+// A Discord message or HTTP API request the bot is handling. Replies go through it so the command's result can be
+// counted, and its log lines and AWS calls carry its correlation ID.
+type command struct {
+	session *discordgo.Session
+	caller  caller
+
+	// Where the command came from, for the audit log. The Discord IDs are empty for API requests.
+	source    string
+	userId    string
+	guildId   string
+	channelId string
+
+	// What can be done from the channel the command came from. API requests can do everything.
+	channel botconfig.Channel
+
+	// The command's name without the !, i.e. start, or otp for a one time password. Empty for anything else.
+	name string
+
+	// What followed the command's name. For a one time password, the command it confirms.
+	arguments []string
+
+	// Ties together the command's log lines, AWS calls and error replies
+	id      string
+	log     *log.Logger
+	clients *awsclient.Pool
+	started time.Time
+
+	mu     sync.Mutex
+	failed bool
+
+	// The instances the command acted on, for the audit log
+	targets []inventory.Instance
+}
+
+// Starts handling a command from Discord
+func newCommand(s *discordgo.Session, m *discordgo.MessageCreate) *command {
+	c := startCommand(s, api.Caller(discordCaller(m)))
+	c.source, c.userId, c.guildId, c.channelId = "discord", m.Author.ID, m.GuildID, m.ChannelID
+	c.channel, _ = channelConfig(m.ChannelID)
+
+	fields := strings.Fields(m.Content)
+	switch {
+	case len(fields) > 0 && commandNames[fields[0]]:
+		c.name = strings.TrimPrefix(fields[0], "!")
+		c.arguments = fields[1:]
+	default:
+		if pendingCommand, found := otpCommand(m.ChannelID, m.Content); found {
+			c.name = "otp"
+			c.arguments = strings.Fields(pendingCommand)
+		}
+	}
+
+	// Instances named in the arguments (i.e. !backup mc or -i mc) are targets. Commands that act on every instance add
+	// theirs as they go.
+	for _, argument := range c.arguments {
+		if instance, found := inv.Find(argument); found && c.manages(instance) {
+			c.target(instance)
+		}
+	}
+
+	if c.name != "" {
+		logging.Info("Command received", "correlation_id", c.id, "command", c.name, "user", c.caller.Name, "channel_id", m.ChannelID, "channel", c.channel.Name)
+	}
+
+	return c
+}
+
+// Starts handling a command for the caller, i.e. an HTTP API request
+func startCommand(s *discordgo.Session, requestedBy api.Caller) *command {
+	id := logging.NewCorrelationId()
+
+	return &command{
+		session: s,
+		caller:  caller(requestedBy),
+		source:  "api",
+		id:      id,
+		log:     logging.ForCommand(id),
+		clients: clients.ForCommand(id),
+		started: time.Now(),
+	}
+}
+
+// Replies in the channel the command came from, ending with the command's correlation ID so its logs can be found.
+// Replies with **ERROR** in them mark the command as failed.
+func (c *command) send(message string) (*discordgo.Message, error) {
+	c.check(message)
+	message += fmt.Sprintf(" (ref `%s`)", c.id)
+
+	return c.session.ChannelMessageSend(c.replyChannel(), message)
+}
+
+// Like send, with a file attached (i.e. an instance's console output)
+func (c *command) sendFile(message string, name string, file io.Reader) (*discordgo.Message, error) {
+	c.check(message)
+	message += fmt.Sprintf(" (ref `%s`)", c.id)
+
+	return c.session.ChannelFileSendWithMessage(c.replyChannel(), message, name, file)
+}
+
+// The channel replies go to: the one the command came from, or the bot's channel for API requests
+func (c *command) replyChannel() string {
+	if c.channelId == "" {
+		return notifyChannels(c.targets...)[0]
+	}
+
+	return c.channelId
+}
+
+// Returns the first command a message mentions that can't be used in the command's channel, empty if there isn't one.
+// Commands are found the way messageCreated finds them, without mistaking !images for !image.
+func (c *command) refusedCommand(content string) string {
+	for name := range commandNames {
+		mentioned := content
+		for other := range commandNames {
+			if other != name && strings.HasPrefix(other, name) {
+				mentioned = strings.ReplaceAll(mentioned, other, "")
+			}
+		}
+
+		if strings.Contains(mentioned, name) && !c.channel.AllowsCommand(strings.TrimPrefix(name, "!")) {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// Reports whether the instance can be managed from the command's channel
+func (c *command) manages(instance inventory.Instance) bool {
+	return c.channel.Manages(instance.Alias, instance.InstanceId)
+}
+
+// Returns the instances that can be managed from the command's channel
+func (c *command) instances() []inventory.Instance {
+	var instances []inventory.Instance
+	for _, instance := range inv.All() {
+		if c.manages(instance) {
+			instances = append(instances, instance)
+		}
+	}
+
+	return instances
+}
+
+// Selects the instances a command names with -i (see inventory.Select), or every instance, leaving out any that can't
+// be managed from the command's channel
+func (c *command) selectInstances(messageContentSlice []string) []inventory.Instance {
+	var selected []inventory.Instance
+	for _, instance := range inv.Select(messageContentSlice, defaultLocation()) {
+		if c.manages(instance) {
+			selected = append(selected, instance)
+		}
+	}
+
+	return selected
+}
+
+// Marks the command as failed if a reply has **ERROR** in it, returning whether it did
+func (c *command) check(message string) bool {
+	if !strings.Contains(message, "**ERROR**") {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.failed = true
+	return true
+}
+
+// Records instances the command acts on, once each
+func (c *command) target(instances ...inventory.Instance) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, instance := range instances {
+		seen := false
+		for _, target := range c.targets {
+			seen = seen || target.InstanceId == instance.InstanceId
+		}
+		if !seen {
+			c.targets = append(c.targets, instance)
+		}
+	}
+}
+
+// Finds the instance named by a command's first argument (i.e. !backup mc), or the only instance if the command's
+// channel manages just one, recording it as a target
+func (c *command) namedInstance(messageContentSlice []string) (inventory.Instance, error) {
+	if len(messageContentSlice) < 2 || strings.HasPrefix(messageContentSlice[1], "-") {
+		instances := c.instances()
+		if len(instances) == 1 {
+			c.target(instances[0])
+			return instances[0], nil
+		}
+
+		var names []string
+		for _, instance := range instances {
+			names = append(names, instance.Name())
+		}
+		return inventory.Instance{}, fmt.Errorf("Please name an instance, i.e. `%s mc`. Instances in the bot's inventory: `%s`", messageContentSlice[0], strings.Join(names, "`, `"))
+	}
+
+	instance, found := inv.Find(messageContentSlice[1])
+	if !found {
+		return inventory.Instance{}, fmt.Errorf("There's no instance called `%s` in the bot's inventory.", messageContentSlice[1])
+	}
+	if !c.manages(instance) {
+		return inventory.Instance{}, fmt.Errorf("`%s` can't be managed from this channel.", messageContentSlice[1])
+	}
+
+	c.target(instance)
+	return instance, nil
+}
+
+// Logs the command's result, counts it in the bot's metrics and records it in the audit log, once it's been handled.
+// Replies sent later by background work (i.e. !resize) aren't included.
+func (c *command) finish() {
+	if c.name == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := "ok"
+	if c.failed {
+		result = "error"
+	}
+	metrics.Commands.Inc(c.name, result)
+	logging.Info("Command finished", "correlation_id", c.id, "command", c.name, "result", result, "duration_ms", fmt.Sprint(time.Since(c.started).Milliseconds()))
+
+	if !readOnlyCommands[c.name] {
+		c.audit(result)
+	}
+}
+
+// Appends the command to the audit log and tags its targets with who acted on them. Callers must hold c.mu.
+func (c *command) audit(result string) {
+	entry := audit.Entry{
+		Time:          c.started,
+		Source:        c.source,
+		User:          c.caller.Name,
+		UserId:        c.userId,
+		GuildId:       c.guildId,
+		ChannelId:     c.channelId,
+		Command:       c.name,
+		Arguments:     c.arguments,
+		Result:        result,
+		CorrelationId: c.id,
+	}
+	for _, target := range c.targets {
+		entry.Instances = append(entry.Instances, target.Name())
+	}
+
+	// A one time password is tagged as the command it confirmed
+	action := c.name
+	if c.name == "otp" && len(c.arguments) > 0 {
+		action = strings.TrimPrefix(c.arguments[0], "!")
+	}
+
+	for location, instanceIds := range inventory.ByLocation(c.targets) {
+		err := audit.TagLastAction(c.clients.EC2(location), instanceIds, c.caller.Name, action)
+		if err != nil {
+			c.log.Printf("Error tagging %v with %s: %v", instanceIds, audit.LastActionByTag, err)
+		}
+	}
+	entry.RequestIds = c.clients.RequestIds()
+
+	err := auditLog.Append(entry)
+	if err != nil {
+		c.log.Println("Error writing to the audit log:", err)
+	}
+}
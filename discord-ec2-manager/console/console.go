@@ -0,0 +1,105 @@
+package console
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/smithy-go"
+
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/inventory"
+)
+
+// How many lines from the end of the console output !console posts
+const TailLines = 200
+
+// EC2ConsoleAPI defines the interface for the GetConsoleOutput and GetConsoleScreenshot functions.
+type EC2ConsoleAPI interface {
+	GetConsoleOutput(ctx context.Context,
+		params *ec2.GetConsoleOutputInput,
+		optFns ...func(*ec2.Options)) (*ec2.GetConsoleOutputOutput, error)
+
+	GetConsoleScreenshot(ctx context.Context,
+		params *ec2.GetConsoleScreenshotInput,
+		optFns ...func(*ec2.Options)) (*ec2.GetConsoleScreenshotOutput, error)
+}
+
+// An instance's serial console output
+type Output struct {
+	Text string
+
+	// When the output was last updated, zero if EC2 didn't say
+	Timestamp time.Time
+
+	// Whether Text is the latest output, rather than the snapshot EC2 takes shortly after each boot
+	Latest bool
+}
+
+// Reports whether an error is EC2 saying the instance doesn't support something
+func unsupported(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "UnsupportedOperation"
+}
+
+// Fetches the instance's console output, keeping the last lines. The latest output is only available on Nitro
+// instances, so other instances get the output EC2 captured after their last boot instead.
+func Get(api EC2ConsoleAPI, instance inventory.Instance, lines int) (Output, error) {
+	latest := true
+	result, err := api.GetConsoleOutput(context.TODO(), &ec2.GetConsoleOutputInput{
+		InstanceId: aws.String(instance.InstanceId),
+		Latest:     aws.Bool(true),
+	})
+	if unsupported(err) {
+		latest = false
+		result, err = api.GetConsoleOutput(context.TODO(), &ec2.GetConsoleOutputInput{
+			InstanceId: aws.String(instance.InstanceId),
+		})
+	}
+	if err != nil {
+		return Output{}, err
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(aws.ToString(result.Output))
+	if err != nil {
+		return Output{}, fmt.Errorf("error decoding console output: %w", err)
+	}
+
+	output := Output{Text: tail(string(decoded), lines), Latest: latest}
+	if result.Timestamp != nil {
+		output.Timestamp = *result.Timestamp
+	}
+
+	return output, nil
+}
+
+// Keeps the last n lines of text, dropping the carriage returns serial consoles tend to add
+func tail(text string, n int) string {
+	text = strings.TrimRight(strings.ReplaceAll(text, "\r", ""), "\n")
+	lines := strings.Split(text, "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Takes a screenshot of the instance's console, returning it as a JPG
+func Screenshot(api EC2ConsoleAPI, instance inventory.Instance) ([]byte, error) {
+	result, err := api.GetConsoleScreenshot(context.TODO(), &ec2.GetConsoleScreenshotInput{
+		InstanceId: aws.String(instance.InstanceId),
+		WakeUp:     aws.Bool(true),
+	})
+	if unsupported(err) {
+		return nil, fmt.Errorf("`%s` doesn't support console screenshots (only Nitro and some Xen instances do)", instance.Name())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return base64.StdEncoding.DecodeString(aws.ToString(result.ImageData))
+}
@@ -0,0 +1,263 @@
+package cost
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/awsclient"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/inventory"
+)
+
+// EC2 puts the time of the last stop in StateTransitionReason, i.e. "User initiated (2023-01-11 21:15:00 GMT)"
+var transitionReasonTime = regexp.MustCompile(`\((\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}) GMT\)`)
+
+// EC2InstanceAPI defines the interface for the DescribeInstances function.
+type EC2InstanceAPI interface {
+	DescribeInstances(ctx context.Context,
+		params *ec2.DescribeInstancesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error)
+}
+
+// Gets the instances' current state, type and launch time
+func GetInstances(c context.Context, api EC2InstanceAPI, input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	return api.DescribeInstances(c, input)
+}
+
+// The start of the day, week (Monday) and month that now falls in, in UTC
+func periodStarts(now time.Time) (day time.Time, week time.Time, month time.Time) {
+	now = now.UTC()
+	day = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	week = day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	month = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return
+}
+
+// Returns how many hours an instance spent running between from and to, according to its recorded transitions
+func RunningHours(transitions []inventory.Transition, from time.Time, to time.Time) float64 {
+	var hours float64
+	for i, transition := range transitions {
+		if transition.State != "running" {
+			continue
+		}
+
+		start := transition.Time
+		end := to
+		if i+1 < len(transitions) {
+			end = transitions[i+1].Time
+		}
+
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.After(start) {
+			hours += end.Sub(start).Hours()
+		}
+	}
+
+	return hours
+}
+
+// Works out when the instance last stopped from its StateTransitionReason, falling back to now
+func stoppedAt(ec2Instance types.Instance, now time.Time) time.Time {
+	match := transitionReasonTime.FindStringSubmatch(aws.ToString(ec2Instance.StateTransitionReason))
+	if match == nil {
+		return now
+	}
+
+	at, err := time.Parse("2006-01-02 15:04:05", match[1])
+	if err != nil {
+		return now
+	}
+
+	return at
+}
+
+// Records any state change the bot missed (i.e. the instance was started from the AWS console, or stopped by its own
+// idle script), using LaunchTime and StateTransitionReason to work out when it happened
+func Reconcile(inv *inventory.Inventory, instance inventory.Instance, ec2Instance types.Instance, now time.Time) {
+	last, recorded := instance.LastTransition()
+
+	var state string
+	var at time.Time
+	switch ec2Instance.State.Name {
+	case types.InstanceStateNamePending, types.InstanceStateNameRunning:
+		state = "running"
+		at = aws.ToTime(ec2Instance.LaunchTime)
+	case types.InstanceStateNameStopping, types.InstanceStateNameStopped:
+		state = "stopped"
+		at = stoppedAt(ec2Instance, now)
+	case types.InstanceStateNameShuttingDown, types.InstanceStateNameTerminated:
+		state = "terminated"
+		at = stoppedAt(ec2Instance, now)
+	default:
+		return
+	}
+
+	// A stopped instance with no history has never cost the bot anything
+	if !recorded && state != "running" {
+		return
+	}
+
+	if recorded && at.Before(last.Time) {
+		at = last.Time
+	}
+
+	err := inv.Update(instance.InstanceId, func(i *inventory.Instance) {
+		if !recorded || last.State != state {
+			log.Printf("Recording missed transition of %s to %s at %s", instance.Name(), state, at)
+			i.AddTransition(state, at)
+		}
+		i.InstanceType = string(ec2Instance.InstanceType)
+	})
+	if err != nil {
+		log.Println("Error saving inventory:", err)
+	}
+}
+
+// Brings the inventory up to date with what EC2 says about each instance, one account and region at a time
+func ReconcileAll(inv *inventory.Inventory, clients *awsclient.Pool, now time.Time) (errorMessages []string) {
+	instances := inv.All()
+	locations := inventory.ByLocation(instances)
+
+	for _, location := range inventory.SortedLocations(locations) {
+		result, err := GetInstances(context.TODO(), clients.EC2(location), &ec2.DescribeInstancesInput{
+			InstanceIds: locations[location],
+		})
+		if err != nil {
+			log.Printf("Error describing instances in %s: %v", location, err)
+			errorMessages = append(errorMessages, fmt.Sprintf(":warning: Couldn't check the current state of instances in `%s`, their costs may be out of date", location))
+			continue
+		}
+
+		for _, r := range result.Reservations {
+			for _, ec2Instance := range r.Instances {
+				for _, instance := range instances {
+					if instance.InstanceId == aws.ToString(ec2Instance.InstanceId) {
+						Reconcile(inv, instance, ec2Instance, now)
+					}
+				}
+			}
+		}
+	}
+
+	return errorMessages
+}
+
+// Spend for one instance (or the total) over each reporting period
+type Spend struct {
+	Today     float64
+	ThisWeek  float64
+	ThisMonth float64
+
+	// What's being spent per hour right now, 0 unless the instance is running
+	CurrentHourly float64
+}
+
+// Works out an instance's spend from its recorded transitions, returning false if its price isn't known
+func InstanceSpend(instance inventory.Instance, prices PriceTable, now time.Time) (spend Spend, priced bool) {
+	hourly, priced := prices.HourlyPrice(instance.Region, instance.InstanceType)
+	if !priced {
+		return spend, false
+	}
+
+	day, week, month := periodStarts(now)
+	spend.Today = RunningHours(instance.Transitions, day, now) * hourly
+	spend.ThisWeek = RunningHours(instance.Transitions, week, now) * hourly
+	spend.ThisMonth = RunningHours(instance.Transitions, month, now) * hourly
+
+	if last, ok := instance.LastTransition(); ok && last.State == "running" {
+		spend.CurrentHourly = hourly
+	}
+
+	return spend, true
+}
+
+// Works out the month's projected spend: what's been spent so far, plus what's running now left running until the end of the month
+func Projected(total Spend, now time.Time) float64 {
+	_, _, month := periodStarts(now)
+	hoursLeft := month.AddDate(0, 1, 0).Sub(now).Hours()
+
+	return total.ThisMonth + total.CurrentHourly*hoursLeft
+}
+
+// Adds up the spend of every instance the bot manages (or has recently terminated)
+func TotalSpend(inv *inventory.Inventory, prices PriceTable, now time.Time) Spend {
+	return SumSpend(append(inv.All(), inv.AllRetired()...), prices, now)
+}
+
+// Adds up the spend of the instances
+func SumSpend(instances []inventory.Instance, prices PriceTable, now time.Time) (total Spend) {
+	for _, instance := range instances {
+		spend, priced := InstanceSpend(instance, prices, now)
+		if !priced {
+			continue
+		}
+
+		total.Today += spend.Today
+		total.ThisWeek += spend.ThisWeek
+		total.ThisMonth += spend.ThisMonth
+		total.CurrentHourly += spend.CurrentHourly
+	}
+
+	return total
+}
+
+// Builds the !cost report: each instance's estimated spend today, this week and this month, and a projection for the
+// month. Only instances include returns true for are reported, or every instance if include is nil.
+func GetCostReport(inv *inventory.Inventory, clients *awsclient.Pool, prices PriceTable, include func(inventory.Instance) bool) (statusMessage string) {
+	now := time.Now()
+	warnings := ReconcileAll(inv, clients, now)
+
+	var instances []inventory.Instance
+	for _, instance := range append(inv.All(), inv.AllRetired()...) {
+		if include == nil || include(instance) {
+			instances = append(instances, instance)
+		}
+	}
+	if len(instances) < 1 {
+		statusMessage = "There are no instances in the bot's inventory yet. Use `!create` to add one."
+		return
+	}
+
+	var report strings.Builder
+	report.WriteString("**Estimated EC2 costs** (on-demand compute only, USD, periods in UTC)\n")
+
+	for _, instance := range instances {
+		last, _ := instance.LastTransition()
+		state := last.State
+		if state == "" {
+			state = "unknown"
+		}
+
+		spend, priced := InstanceSpend(instance, prices, now)
+		if !priced {
+			fmt.Fprintf(&report, "`%s` (`%s` in `%s`, %s): no price known, add one to `prices` in the bot's config file\n", instance.Name(), instance.InstanceType, instance.Location(), state)
+			continue
+		}
+
+		hourly, _ := prices.HourlyPrice(instance.Region, instance.InstanceType)
+		fmt.Fprintf(&report, "`%s` (`%s` in `%s` at $%.4f/hour, %s): today $%.2f · this week $%.2f · this month $%.2f\n", instance.Name(), instance.InstanceType, instance.Location(), hourly, state, spend.Today, spend.ThisWeek, spend.ThisMonth)
+	}
+
+	total := SumSpend(instances, prices, now)
+	fmt.Fprintf(&report, "\n**Total**: today $%.2f · this week $%.2f · this month $%.2f\n", total.Today, total.ThisWeek, total.ThisMonth)
+	fmt.Fprintf(&report, "**Projected this month**: $%.2f (currently spending $%.4f/hour)\n", Projected(total, now), total.CurrentHourly)
+
+	for _, warning := range warnings {
+		report.WriteString(warning + "\n")
+	}
+
+	statusMessage = report.String()
+	return
+}
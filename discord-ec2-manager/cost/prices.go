@@ -0,0 +1,59 @@
+package cost
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+)
+
+// Hours in an average month, used for monthly estimates
+const HoursPerMonth = 730
+
+// Linux on-demand prices (USD per hour) for common instance types, bundled so !cost works without any config
+//
+//go:embed prices.json
+var defaultPrices []byte
+
+// Hourly prices in USD by region, then instance type. A "*" region applies to every region.
+type PriceTable map[string]map[string]float64
+
+// Loads the bundled price table and lays the config file's prices over it
+func NewPriceTable(overrides map[string]map[string]float64) (PriceTable, error) {
+	prices := PriceTable{}
+	err := json.Unmarshal(defaultPrices, &prices)
+	if err != nil {
+		return nil, err
+	}
+
+	for region, instanceTypes := range overrides {
+		if prices[region] == nil {
+			prices[region] = map[string]float64{}
+		}
+		for instanceType, price := range instanceTypes {
+			prices[region][instanceType] = price
+		}
+	}
+
+	return prices, nil
+}
+
+// Looks up the hourly price of an instance type in a region
+func (p PriceTable) HourlyPrice(region string, instanceType string) (float64, bool) {
+	price, ok := p[region][instanceType]
+	if ok {
+		return price, true
+	}
+
+	price, ok = p["*"][instanceType]
+	return price, ok
+}
+
+// Describes what an instance type will cost to run, for !create's confirmation
+func (p PriceTable) HourlyCostLine(region string, instanceType string) string {
+	price, ok := p.HourlyPrice(region, instanceType)
+	if !ok {
+		return fmt.Sprintf(":moneybag: No price is known for `%s` in `%s`, add one to `prices` in the bot's config file to see cost estimates", instanceType, region)
+	}
+
+	return fmt.Sprintf(":moneybag: Estimated cost: `$%.4f/hour` (about `$%.2f` a month if left running around the clock)", price, price*HoursPerMonth)
+}
@@ -4,12 +4,16 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/ami"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/userdata"
 )
 
 var (
@@ -17,13 +21,21 @@ var (
 	UserInstanceId      string
 	UserSecurityGroupId string
 	UserAmiId           string
+	ResolvedAmiId       string
 	UserSubnetId        string
-	UserPathToScript    string
+	UserDataTemplates   string
 	UserTagKey          string
 	UserTagValue        string
 	UserKeyName         string
 	UserInstanceType    string
 
+	// User Data Template Variables
+	UserDataDir  string
+	UserAlias    string
+	UserRegion   string
+	ChannelId    string
+	TemplateVars map[string]string
+
 	// IAM Role Variables
 	UserIamArn         string
 	UserIamProfileName string
@@ -42,10 +54,44 @@ var (
 	instanceIds []string
 
 	// Misc.
-	validUserSubnetId bool
 	runInstancesInput *ec2.RunInstancesInput
+	userData          []byte
 )
 
+// The package's variables as the bot's start up flags set them, which every !create starts from
+var defaults struct {
+	securityGroupId, amiId, subnetId, dataTemplates, tagKey, tagValue, keyName, instanceType string
+	iamArn, iamProfileName, serviceCheckPort, serviceName, servicePort, channelId            string
+}
+
+// Remembers the package's variables as they are now (set from the bot's start up flags) as the defaults every !create
+// starts from
+func SaveDefaults() {
+	defaults.securityGroupId, defaults.amiId, defaults.subnetId = UserSecurityGroupId, UserAmiId, UserSubnetId
+	defaults.dataTemplates, defaults.tagKey, defaults.tagValue = UserDataTemplates, UserTagKey, UserTagValue
+	defaults.keyName, defaults.instanceType = UserKeyName, UserInstanceType
+	defaults.iamArn, defaults.iamProfileName = UserIamArn, UserIamProfileName
+	defaults.serviceCheckPort, defaults.serviceName, defaults.servicePort = ServiceCheckPort, UserServiceName, UserServicePort
+	defaults.channelId = ChannelId
+}
+
+// Puts the package's variables back to their defaults, so nothing from one !create (i.e. its AMI, key pair or subnet)
+// carries over to the next. Callers must do this before setting a !create's own variables (i.e. Hibernate).
+func Reset() {
+	UserSecurityGroupId, UserAmiId, UserSubnetId = defaults.securityGroupId, defaults.amiId, defaults.subnetId
+	UserDataTemplates, UserTagKey, UserTagValue = defaults.dataTemplates, defaults.tagKey, defaults.tagValue
+	UserKeyName, UserInstanceType = defaults.keyName, defaults.instanceType
+	UserIamArn, UserIamProfileName = defaults.iamArn, defaults.iamProfileName
+	ServiceCheckPort, UserServiceName, UserServicePort = defaults.serviceCheckPort, defaults.serviceName, defaults.servicePort
+	ChannelId = defaults.channelId
+
+	ResolvedAmiId = ""
+	UserAlias = ""
+	UserRegion = ""
+	TemplateVars = map[string]string{}
+	Hibernate = false
+}
+
 // EC2InstanceAPI defines the interface for the RunInstances, CreateTags, and TerminateInstances functions.
 type EC2InstanceAPI interface {
 	RunInstances(ctx context.Context,
@@ -67,258 +113,286 @@ func CreateTag(c context.Context, api EC2InstanceAPI, input *ec2.CreateTagsInput
 	return api.CreateTags(c, input)
 }
 
-func CreateEc2Instance(messageContentSlice []string, flagArray []string, client *ec2.Client) (statusMessage string, UserInstanceId string, UserTagKey string, UserTagValue string, UserServiceName string, UserServicePort string, ServiceCheckPort string) {
-	log.Println("Checking for required flags...")
-	if len(messageContentSlice) > 1 {
-		for i := 1; i < len(messageContentSlice); i += 2 {
-			if messageContentSlice[i] != "-ami" && messageContentSlice[i] != "-sn" && messageContentSlice[i] != "-sg" {
-				log.Printf("%s is not a required flag, skipping...", messageContentSlice[i])
-				break
-			} else {
-				switch messageContentSlice[i] {
-				case "-ami":
-					for j := 0; j < len(flagArray); j++ {
-						if messageContentSlice[i+1] != flagArray[j] {
-							log.Println("Custom Amazon Machine Image ID found:", messageContentSlice[i+1])
-							UserAmiId = messageContentSlice[i+1]
-							break
-						}
-					}
-				case "-sn":
-					for j := 0; j < len(flagArray); j++ {
-						if messageContentSlice[i+1] != flagArray[j] {
-							log.Println("Subnet ID found:", messageContentSlice[i+1])
-							UserSubnetId = messageContentSlice[i+1]
-							validUserSubnetId = true
-							break
-						} else {
-							log.Println("To use !create you MUST specify a subnet via the -sn flag either when starting the bot, or via your !create Discord Message. Please either restart your bot OR resend your !create Discord Message with the -sn flag and a valid Subnet ID.")
-							validUserSubnetId = false
-							return
-						}
-					}
-				}
-
-				if UserAmiId == "" {
-					UserAmiId = "ami-09e67e426f25ce0d7" // Ubuntu 20.04
-				}
-			}
-		}
-	} else if UserSubnetId != "" && UserAmiId != "" {
-		log.Println("Subnet ID and AMI ID were either previously set, or set via the bot's environment variables.")
-		validUserSubnetId = true
-	} else {
-		log.Println("Missing parameters!")
-		return
+// Returns the value following the flag at index i, or false if there isn't one (or it's another flag)
+func flagValue(messageContentSlice []string, i int, flagArray []string) (string, bool) {
+	if i+1 >= len(messageContentSlice) {
+		return "", false
 	}
 
-	if validUserSubnetId {
-		for i := 1; i < len(messageContentSlice); i += 2 {
-			switch messageContentSlice[i] {
-			case "-sg": // Security Group Flag
-				for j := 0; j < len(flagArray); j++ {
-					if messageContentSlice[i+1] != flagArray[j] {
-						UserSecurityGroupId = messageContentSlice[i+1]
-					} else {
-						log.Println("Invalid Security Group ID:", messageContentSlice[i+1])
-						statusMessage = fmt.Sprintf("Invalid Security Group ID: %s", messageContentSlice[i+1])
-						return
-					}
-				}
-			case "-tk": // Tag Key Flag
-				for j := 0; j < len(flagArray); j++ {
-					if messageContentSlice[i+1] != flagArray[j] {
-						UserTagKey = messageContentSlice[i+1]
-					} else {
-						// TODO: Build in logic to determine invalid key based on AWS standards
-						log.Println("Invalid Tag Key:", messageContentSlice[i+1])
-
-						statusMessage = fmt.Sprintf("Invalid Tag Key: %s", messageContentSlice[i+1])
-						return
-					}
-				}
-			case "-tv": // Tag Value Flag
-				for j := 0; j < len(flagArray); j++ {
-					if messageContentSlice[i+1] != flagArray[j] {
-						UserTagValue = messageContentSlice[i+1]
-					} else {
-						// TODO: Build in logic to determine invalid key values based on AWS standards
-						log.Println("Invalid Tag Value", messageContentSlice[i+1])
-
-						statusMessage = fmt.Sprintf("Invalid Tag Value: %s", messageContentSlice[i+1])
-						return
-					}
-				}
-			case "-u": // User Data Path Flag
-				for j := 0; j < len(flagArray); j++ {
-					if messageContentSlice[i+1] != flagArray[j] {
-						UserPathToScript = messageContentSlice[i+1]
-					} else {
-						log.Println("Invalid Path to User Data Script:", messageContentSlice[i+1])
-
-						statusMessage = fmt.Sprintf("Invalid Path to User Data Script: %s", messageContentSlice[i+1])
-						return
-					}
-				}
-			case "-svc": // Service Name Flag
-				for j := 0; j < len(flagArray); j++ {
-					if messageContentSlice[i+1] != flagArray[j] {
-						UserServiceName = messageContentSlice[i+1]
-					} else {
-						log.Println("Invalid Service Name:", messageContentSlice[i+1])
-
-						statusMessage = fmt.Sprintf("Invalid Service Name: %s", messageContentSlice[i+1])
-						return
-					}
-				}
-			case "-sp": // Service Port Flag
-				for j := 0; j < len(flagArray); j++ {
-					if messageContentSlice[i+1] != flagArray[j] {
-						UserServicePort = messageContentSlice[i+1]
-						break
-					} else {
-						log.Println("Invalid Service Port:", messageContentSlice[i+1])
-
-						statusMessage = fmt.Sprintf("Invalid Service Port: %s", messageContentSlice[i+1])
-						return
-					}
-				}
-			case "-scp": // Service Check Port Flag (Healthcheck)
-				for j := 0; j < len(flagArray); j++ {
-					if messageContentSlice[i+1] != flagArray[j] {
-						ServiceCheckPort = messageContentSlice[i+1]
-					} else {
-						log.Println("Invalid Service Check Port:", messageContentSlice[i+1])
-
-						statusMessage = fmt.Sprintf("Invalid Service Check Port: %s", messageContentSlice[i+1])
-						return
-					}
-				}
-			case "-ia": // EC2 Instance Role ARN
-				for j := 0; j < len(flagArray); j++ {
-					if messageContentSlice[i+1] != flagArray[j] {
-						UserIamArn = messageContentSlice[i+1]
-					} else {
-						log.Println("Invalid IAM ARN:", messageContentSlice[i+1])
-
-						statusMessage = fmt.Sprintf("Invalid IAM ARN: %s", messageContentSlice[i+1])
-						return
-					}
-				}
-			case "-in": // EC2 Instance Role ARN
-				for j := 0; j < len(flagArray); j++ {
-					if messageContentSlice[i+1] != flagArray[j] {
-						UserIamProfileName = messageContentSlice[i+1]
-					} else {
-						log.Println("Invalid IAM Name:", messageContentSlice[i+1])
-
-						statusMessage = fmt.Sprintf("Invalid IAM Name: %s", messageContentSlice[i+1])
-						return
-					}
-				}
-			case "-k": // EC2 Instance Key Pair Name
-				for j := 0; j < len(flagArray); j++ {
-					if messageContentSlice[i+1] != flagArray[j] {
-						UserKeyName = messageContentSlice[i+1]
-					} else {
-						log.Println("Invalid Key Pair Name:", messageContentSlice[i+1])
-
-						statusMessage = fmt.Sprintf("Invalid Key Pair Name: %s", messageContentSlice[i+1])
-						return
-					}
-				}
-			case "-it": // EC2 Instance Key Pair Name
-				for j := 0; j < len(flagArray); j++ {
-					if messageContentSlice[i+1] != flagArray[j] {
-						UserInstanceType = messageContentSlice[i+1]
-					} else {
-						log.Println("Invalid Instance Type:", messageContentSlice[i+1])
-
-						statusMessage = fmt.Sprintf("Invalid Instance Type: %s", messageContentSlice[i+1])
-						return
-					}
-				}
-			}
+	for j := 0; j < len(flagArray); j++ {
+		if messageContentSlice[i+1] == flagArray[j] {
+			return "", false
 		}
+	}
 
-		if UserIamArn != "" && UserIamProfileName != "" {
-			log.Println("Error, cannot use -in and -ia flags together. Please run the !create command again with only one flag specified.")
-			statusMessage = "Error, cannot use -in and -ia flags together. Please run the `!create` command again with only one flag specified."
-			return
-		}
+	return messageContentSlice[i+1], true
+}
 
-		content, err := ioutil.ReadFile(UserPathToScript)
-		if err != nil {
-			log.Println("Error reading from file, instance will launch without userdata.sh:", err)
-		}
+// Reads the flags from a !create message into the package's variables, returning a non-empty statusMessage if one of them is invalid
+func parseCreateFlags(messageContentSlice []string, flagArray []string) (statusMessage string) {
+	log.Println("Checking for flags...")
+
+	// Aliases and template variables only apply to the instance being created
+	UserAlias = ""
+	TemplateVars = map[string]string{}
+
+	for i := 1; i < len(messageContentSlice); i += 2 {
+		value, ok := flagValue(messageContentSlice, i, flagArray)
 
-		encodedUserDataContent := base64.StdEncoding.EncodeToString([]byte(content))
-
-		if UserKeyName == "" {
-			runInstancesInput = &ec2.RunInstancesInput{
-				ImageId:          aws.String(UserAmiId),
-				InstanceType:     types.InstanceType(UserInstanceType),
-				MinCount:         aws.Int32(1),
-				MaxCount:         aws.Int32(1),
-				SecurityGroupIds: SecurityGroupIds,
-				SubnetId:         aws.String(UserSubnetId),
-				UserData:         aws.String(encodedUserDataContent),
-				IamInstanceProfile: &types.IamInstanceProfileSpecification{
-					Arn:  aws.String(UserIamArn),
-					Name: aws.String(UserIamProfileName),
-				},
+		switch messageContentSlice[i] {
+		case "-ami": // AMI ID, alias, owner:name-pattern, or SSM parameter path Flag
+			if !ok {
+				log.Println("Invalid AMI:", value)
+				statusMessage = "Invalid AMI, please include an AMI ID, alias, `owner:name-pattern` or SSM parameter path after the `-ami` flag."
+				return
+			}
+			log.Println("Custom Amazon Machine Image found:", value)
+			UserAmiId = value
+		case "-sn": // Subnet ID Flag
+			if !ok {
+				log.Println("To use !create you MUST specify a subnet via the -sn flag either when starting the bot, or via your !create Discord Message. Please either restart your bot OR resend your !create Discord Message with the -sn flag and a valid Subnet ID.")
+				statusMessage = "Invalid Subnet ID, please include a value after the `-sn` flag."
+				return
+			}
+			log.Println("Subnet ID found:", value)
+			UserSubnetId = value
+		case "-sg": // Security Group Flag
+			if !ok {
+				log.Println("Invalid Security Group ID:", value)
+				statusMessage = fmt.Sprintf("Invalid Security Group ID: %s", value)
+				return
 			}
-		} else {
-			runInstancesInput = &ec2.RunInstancesInput{
-				ImageId:          aws.String(UserAmiId),
-				InstanceType:     types.InstanceType(UserInstanceType),
-				MinCount:         aws.Int32(1),
-				MaxCount:         aws.Int32(1),
-				SecurityGroupIds: SecurityGroupIds,
-				SubnetId:         aws.String(UserSubnetId),
-				UserData:         aws.String(encodedUserDataContent),
-				IamInstanceProfile: &types.IamInstanceProfileSpecification{
-					Arn:  aws.String(UserIamArn),
-					Name: aws.String(UserIamProfileName),
-				},
-				KeyName: aws.String(UserKeyName),
+			UserSecurityGroupId = value
+		case "-tk": // Tag Key Flag
+			if !ok {
+				// TODO: Build in logic to determine invalid key based on AWS standards
+				log.Println("Invalid Tag Key:", value)
+				statusMessage = fmt.Sprintf("Invalid Tag Key: %s", value)
+				return
 			}
+			UserTagKey = value
+		case "-tv": // Tag Value Flag
+			if !ok {
+				// TODO: Build in logic to determine invalid key values based on AWS standards
+				log.Println("Invalid Tag Value", value)
+				statusMessage = fmt.Sprintf("Invalid Tag Value: %s", value)
+				return
+			}
+			UserTagValue = value
+		case "-u": // User Data Template Name(s) Flag
+			if !ok {
+				log.Println("Invalid User Data Template:", value)
+				statusMessage = fmt.Sprintf("Invalid User Data Template: %s", value)
+				return
+			}
+			UserDataTemplates = value
+		case "--var": // User Data Template Variable Flag
+			keyAndValue := strings.SplitN(value, "=", 2)
+			if !ok || len(keyAndValue) != 2 || keyAndValue[0] == "" {
+				log.Println("Invalid User Data Template Variable:", value)
+				statusMessage = fmt.Sprintf("Invalid User Data Template Variable: %s, expected `--var key=value`", value)
+				return
+			}
+			TemplateVars[keyAndValue[0]] = keyAndValue[1]
+		case "-alias": // Instance Alias Flag
+			if !ok {
+				log.Println("Invalid Alias:", value)
+				statusMessage = fmt.Sprintf("Invalid Alias: %s", value)
+				return
+			}
+			UserAlias = value
+		case "-svc": // Service Name Flag
+			if !ok {
+				log.Println("Invalid Service Name:", value)
+				statusMessage = fmt.Sprintf("Invalid Service Name: %s", value)
+				return
+			}
+			UserServiceName = value
+		case "-sp": // Service Port Flag
+			if !ok {
+				log.Println("Invalid Service Port:", value)
+				statusMessage = fmt.Sprintf("Invalid Service Port: %s", value)
+				return
+			}
+			UserServicePort = value
+		case "-scp": // Service Check Port Flag (Healthcheck)
+			if !ok {
+				log.Println("Invalid Service Check Port:", value)
+				statusMessage = fmt.Sprintf("Invalid Service Check Port: %s", value)
+				return
+			}
+			ServiceCheckPort = value
+		case "-ia": // EC2 Instance Role ARN
+			if !ok {
+				log.Println("Invalid IAM ARN:", value)
+				statusMessage = fmt.Sprintf("Invalid IAM ARN: %s", value)
+				return
+			}
+			UserIamArn = value
+		case "-in": // EC2 Instance Role Name
+			if !ok {
+				log.Println("Invalid IAM Name:", value)
+				statusMessage = fmt.Sprintf("Invalid IAM Name: %s", value)
+				return
+			}
+			UserIamProfileName = value
+		case "-k": // EC2 Instance Key Pair Name
+			if !ok {
+				log.Println("Invalid Key Pair Name:", value)
+				statusMessage = fmt.Sprintf("Invalid Key Pair Name: %s", value)
+				return
+			}
+			UserKeyName = value
+		case "-it": // EC2 Instance Type
+			if !ok {
+				log.Println("Invalid Instance Type:", value)
+				statusMessage = fmt.Sprintf("Invalid Instance Type: %s", value)
+				return
+			}
+			UserInstanceType = value
+		default:
+			log.Printf("%s is not a recognized flag, skipping...", messageContentSlice[i])
 		}
+	}
 
-		result, err := MakeInstance(context.TODO(), client, runInstancesInput)
-		if err != nil {
-			fmt.Println("Error creating EC2 instance:", err)
-			statusMessage = fmt.Sprint("There was an error creating your EC2 instance:", err)
-			return
-		}
+	if UserSubnetId == "" {
+		log.Println("Missing parameters! To use !create you MUST specify a subnet via the -sn flag.")
+		statusMessage = "To use `!create` you **must** specify a subnet, either when starting the bot or with the `-sn` flag in your `!create` message."
+		return
+	}
 
-		UserInstanceId = *result.Instances[0].InstanceId
+	if UserIamArn != "" && UserIamProfileName != "" {
+		log.Println("Error, cannot use -in and -ia flags together. Please run the !create command again with only one flag specified.")
+		statusMessage = "Error, cannot use -in and -ia flags together. Please run the `!create` command again with only one flag specified."
+		return
+	}
 
-		if result.Instances[0].InstanceId != nil {
-			log.Println("OTP entered correctly, instance created:", UserInstanceId)
-			statusMessage = fmt.Sprintf("One time password entered correctly, your EC2 instance has been created!\nInstance ID: `%s`", UserInstanceId)
-			instanceIds = append(instanceIds, UserInstanceId)
-		}
+	return
+}
 
-		tagInput := &ec2.CreateTagsInput{
-			Resources: []string{UserInstanceId},
-			Tags: []types.Tag{
-				{
-					Key:   aws.String(UserTagKey),
-					Value: aws.String(UserTagValue),
-				},
-			},
-		}
+// Resolves UserAmiId (which may be an alias, name pattern or SSM parameter) to an AMI ID in the client's region
+func resolveImage(client ami.EC2ImageAPI, ssmClient ami.SSMParameterAPI) (err error) {
+	ResolvedAmiId, err = ami.ResolveAmi(UserAmiId, client, ssmClient)
+	return err
+}
+
+// Renders the user data templates named by -u, if any
+func renderUserData() (err error) {
+	userData = nil
+	if UserDataTemplates == "" || UserDataTemplates == "none" {
+		return nil
+	}
+
+	alias := UserAlias
+	if alias == "" {
+		alias = UserTagValue
+	}
+
+	userData, err = userdata.Render(UserDataDir, strings.Split(UserDataTemplates, ","), userdata.Variables{
+		Alias:            alias,
+		InstanceType:     UserInstanceType,
+		ServiceName:      UserServiceName,
+		ServicePort:      UserServicePort,
+		ServiceCheckPort: ServiceCheckPort,
+		ChannelId:        ChannelId,
+		Region:           UserRegion,
+		Vars:             TemplateVars,
+	})
+	return err
+}
+
+// Builds the RunInstancesInput from the package's variables
+func buildRunInstancesInput() *ec2.RunInstancesInput {
+	SecurityGroupIds = nil
+	if UserSecurityGroupId != "" {
+		SecurityGroupIds = append(SecurityGroupIds, UserSecurityGroupId)
+	}
+
+	input := &ec2.RunInstancesInput{
+		ImageId:          aws.String(ResolvedAmiId),
+		InstanceType:     types.InstanceType(UserInstanceType),
+		MinCount:         aws.Int32(1),
+		MaxCount:         aws.Int32(1),
+		SecurityGroupIds: SecurityGroupIds,
+		SubnetId:         aws.String(UserSubnetId),
+	}
+
+	if len(userData) > 0 {
+		input.UserData = aws.String(base64.StdEncoding.EncodeToString(userData))
+	}
+
+	if UserIamArn != "" {
+		input.IamInstanceProfile = &types.IamInstanceProfileSpecification{Arn: aws.String(UserIamArn)}
+	} else if UserIamProfileName != "" {
+		input.IamInstanceProfile = &types.IamInstanceProfileSpecification{Name: aws.String(UserIamProfileName)}
+	}
+
+	if UserKeyName != "" {
+		input.KeyName = aws.String(UserKeyName)
+	}
+
+	applyHibernation(input)
 
-		_, err = CreateTag(context.TODO(), client, tagInput)
+	return input
+}
+
+func CreateEc2Instance(messageContentSlice []string, flagArray []string, client *ec2.Client, ssmClient *ssm.Client) (statusMessage string, instanceId string, tagKey string, tagValue string, serviceName string, servicePort string, serviceCheckPort string) {
+	statusMessage = parseCreateFlags(messageContentSlice, flagArray)
+	if statusMessage != "" {
+		statusMessage = "**ERROR**: " + statusMessage
+		return
+	}
+
+	var result *ec2.RunInstancesOutput
+	err := resolveImage(client, ssmClient)
+	if err != nil {
+		statusMessage = fmt.Sprintf("**ERROR**: There was an error finding the AMI `%s`: %s", UserAmiId, describeError(err))
+		return
+	}
+
+	err = renderUserData()
+	if err != nil {
+		statusMessage = fmt.Sprintf("**ERROR**: There was an error rendering your user data: %s", err)
+		return
+	}
+
+	if Hibernate {
+		_, err = planHibernation(client)
 		if err != nil {
-			log.Println("Error tagging resources:", err)
+			statusMessage = fmt.Sprintf("**ERROR**: Hibernation can't be enabled: %s", describeError(err))
 			return
 		}
+	}
+
+	runInstancesInput = buildRunInstancesInput()
 
-	} else {
+	result, err = MakeInstance(context.TODO(), client, runInstancesInput)
+	if err != nil {
+		log.Println("Error creating EC2 instance:", err)
+		statusMessage = fmt.Sprintf("**ERROR**: There was an error creating your EC2 instance: %s", describeError(err))
 		return
 	}
 
-	return
+	UserInstanceId = *result.Instances[0].InstanceId
+
+	log.Println("Instance created:", UserInstanceId)
+	statusMessage = fmt.Sprintf("Your EC2 instance has been created!\nInstance ID: `%s`", UserInstanceId)
+	instanceIds = append(instanceIds, UserInstanceId)
+
+	tagInput := &ec2.CreateTagsInput{
+		Resources: []string{UserInstanceId},
+		Tags: []types.Tag{
+			{
+				Key:   aws.String(UserTagKey),
+				Value: aws.String(UserTagValue),
+			},
+		},
+	}
+
+	_, err = CreateTag(context.TODO(), client, tagInput)
+	if err != nil {
+		log.Println("Error tagging resources:", err)
+	}
+
+	return statusMessage, UserInstanceId, UserTagKey, UserTagValue, UserServiceName, UserServicePort, ServiceCheckPort
 }
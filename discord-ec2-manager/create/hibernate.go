@@ -0,0 +1,120 @@
+package create
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+var (
+	// Set by !create --hibernate, so the instance can be hibernated by !stop --hibernate
+	Hibernate bool
+
+	// The encrypted root volume a hibernating instance needs, worked out by planHibernation
+	hibernateRootDevice string
+	hibernateRootSize   int32
+)
+
+// EC2HibernationAPI defines the interface for the calls used to size a hibernating instance's root volume.
+type EC2HibernationAPI interface {
+	DescribeImages(ctx context.Context,
+		params *ec2.DescribeImagesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error)
+
+	DescribeInstanceTypes(ctx context.Context,
+		params *ec2.DescribeInstanceTypesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceTypesOutput, error)
+}
+
+// Checks the instance type supports hibernation and sizes the root volume to hold the AMI plus a copy of the instance's
+// RAM, which is where EC2 saves it while the instance hibernates
+func planHibernation(api EC2HibernationAPI) (memoryGiB int32, err error) {
+	hibernateRootDevice, hibernateRootSize = "", 0
+
+	typeResult, err := api.DescribeInstanceTypes(context.TODO(), &ec2.DescribeInstanceTypesInput{
+		InstanceTypes: []types.InstanceType{types.InstanceType(UserInstanceType)},
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(typeResult.InstanceTypes) < 1 {
+		return 0, fmt.Errorf("instance type `%s` could not be found", UserInstanceType)
+	}
+
+	instanceType := typeResult.InstanceTypes[0]
+	if !aws.ToBool(instanceType.HibernationSupported) {
+		return 0, fmt.Errorf("instance type `%s` doesn't support hibernation", UserInstanceType)
+	}
+	if instanceType.MemoryInfo != nil {
+		memoryGiB = int32(math.Ceil(float64(aws.ToInt64(instanceType.MemoryInfo.SizeInMiB)) / 1024))
+	}
+
+	images, err := api.DescribeImages(context.TODO(), &ec2.DescribeImagesInput{
+		ImageIds: []string{ResolvedAmiId},
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(images.Images) < 1 {
+		return 0, fmt.Errorf("AMI `%s` could not be found", ResolvedAmiId)
+	}
+
+	image := images.Images[0]
+	hibernateRootDevice = aws.ToString(image.RootDeviceName)
+	var imageSize int32
+	for _, mapping := range image.BlockDeviceMappings {
+		if aws.ToString(mapping.DeviceName) == hibernateRootDevice && mapping.Ebs != nil {
+			imageSize = aws.ToInt32(mapping.Ebs.VolumeSize)
+		}
+	}
+	if hibernateRootDevice == "" || imageSize == 0 {
+		return 0, errors.New("the AMI's root volume could not be found")
+	}
+
+	hibernateRootSize = imageSize + memoryGiB
+	return memoryGiB, nil
+}
+
+// Checks that the instance can hibernate, if --hibernate was asked for
+func checkHibernation(api EC2HibernationAPI) (check preflightCheck) {
+	if !Hibernate {
+		check.passed = true
+		check.description = "Hibernation not enabled"
+		return
+	}
+
+	memoryGiB, err := planHibernation(api)
+	if err != nil {
+		check.description = fmt.Sprintf("Hibernation can't be enabled: %s", describeError(err))
+		return
+	}
+
+	check.passed = true
+	check.description = fmt.Sprintf("Hibernation enabled, with an encrypted %d GiB root volume (%d GiB for the instance's RAM)", hibernateRootSize, memoryGiB)
+	return
+}
+
+// Enables hibernation on the launch, with the encrypted root volume planHibernation sized
+func applyHibernation(input *ec2.RunInstancesInput) {
+	if !Hibernate || hibernateRootDevice == "" {
+		return
+	}
+
+	input.HibernationOptions = &types.HibernationOptionsRequest{Configured: aws.Bool(true)}
+	input.BlockDeviceMappings = []types.BlockDeviceMapping{
+		{
+			DeviceName: aws.String(hibernateRootDevice),
+			Ebs: &types.EbsBlockDevice{
+				Encrypted:           aws.Bool(true),
+				VolumeSize:          aws.Int32(hibernateRootSize),
+				VolumeType:          types.VolumeTypeGp3,
+				DeleteOnTermination: aws.Bool(true),
+			},
+		},
+	}
+}
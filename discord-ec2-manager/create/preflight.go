@@ -0,0 +1,307 @@
+package create
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/smithy-go"
+
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/ami"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/userdata"
+)
+
+// EC2PreflightAPI defines the interface for the calls used to validate a !create before any OTP is requested.
+type EC2PreflightAPI interface {
+	DescribeSubnets(ctx context.Context,
+		params *ec2.DescribeSubnetsInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeSubnetsOutput, error)
+
+	DescribeImages(ctx context.Context,
+		params *ec2.DescribeImagesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error)
+
+	DescribeSecurityGroups(ctx context.Context,
+		params *ec2.DescribeSecurityGroupsInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeSecurityGroupsOutput, error)
+
+	DescribeKeyPairs(ctx context.Context,
+		params *ec2.DescribeKeyPairsInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeKeyPairsOutput, error)
+
+	DescribeInstanceTypeOfferings(ctx context.Context,
+		params *ec2.DescribeInstanceTypeOfferingsInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceTypeOfferingsOutput, error)
+
+	DescribeInstanceTypes(ctx context.Context,
+		params *ec2.DescribeInstanceTypesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceTypesOutput, error)
+
+	RunInstances(ctx context.Context,
+		params *ec2.RunInstancesInput,
+		optFns ...func(*ec2.Options)) (*ec2.RunInstancesOutput, error)
+}
+
+// A single line of the pre-flight checklist
+type preflightCheck struct {
+	passed      bool
+	description string
+}
+
+// Turns an AWS API error into something readable in chat, i.e. "`InvalidAMIID.NotFound`: The image id '[ami-123]' does not exist"
+func describeError(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return fmt.Sprintf("`%s`: %s", apiErr.ErrorCode(), apiErr.ErrorMessage())
+	}
+
+	return err.Error()
+}
+
+// Checks that the subnet exists, returning its availability zone and VPC
+func checkSubnet(api EC2PreflightAPI) (check preflightCheck, availabilityZone string, vpcId string) {
+	result, err := api.DescribeSubnets(context.TODO(), &ec2.DescribeSubnetsInput{
+		SubnetIds: []string{UserSubnetId},
+	})
+	if err != nil || len(result.Subnets) < 1 {
+		log.Println("Error describing subnet:", err)
+		check.description = fmt.Sprintf("Subnet `%s` could not be found", UserSubnetId)
+		if err != nil {
+			check.description += ": " + describeError(err)
+		}
+		return
+	}
+
+	subnet := result.Subnets[0]
+	availabilityZone = aws.ToString(subnet.AvailabilityZone)
+	vpcId = aws.ToString(subnet.VpcId)
+
+	check.passed = true
+	check.description = fmt.Sprintf("Subnet `%s` (`%s`, `%s`)", UserSubnetId, availabilityZone, vpcId)
+	return
+}
+
+// Resolves the AMI and checks that it exists in this region and is available to launch
+func checkImage(api EC2PreflightAPI, ssmClient ami.SSMParameterAPI) (check preflightCheck) {
+	err := resolveImage(api, ssmClient)
+	if err != nil {
+		check.description = fmt.Sprintf("AMI `%s` could not be resolved in this region: %s", UserAmiId, describeError(err))
+		return
+	}
+
+	imageName := fmt.Sprintf("`%s`", ResolvedAmiId)
+	if UserAmiId != "" && UserAmiId != ResolvedAmiId {
+		imageName = fmt.Sprintf("`%s` → `%s`", UserAmiId, ResolvedAmiId)
+	} else if UserAmiId == "" {
+		imageName = fmt.Sprintf("`%s` (default) → `%s`", ami.DefaultAlias, ResolvedAmiId)
+	}
+
+	result, err := api.DescribeImages(context.TODO(), &ec2.DescribeImagesInput{
+		ImageIds: []string{ResolvedAmiId},
+	})
+	if err != nil || len(result.Images) < 1 {
+		log.Println("Error describing image:", err)
+		check.description = fmt.Sprintf("AMI %s could not be found in this region", imageName)
+		if err != nil {
+			check.description += ": " + describeError(err)
+		}
+		return
+	}
+
+	image := result.Images[0]
+	if image.State != types.ImageStateAvailable {
+		check.description = fmt.Sprintf("AMI %s is `%s`, not `available`", imageName, image.State)
+		return
+	}
+
+	check.passed = true
+	check.description = fmt.Sprintf("AMI %s (%s, %s)", imageName, aws.ToString(image.Name), image.Architecture)
+	return
+}
+
+// Checks that the security group exists and lives in the same VPC as the subnet
+func checkSecurityGroup(api EC2PreflightAPI, vpcId string) (check preflightCheck) {
+	if UserSecurityGroupId == "" {
+		check.passed = true
+		check.description = "Security group not set, the VPC's default security group will be used"
+		return
+	}
+
+	result, err := api.DescribeSecurityGroups(context.TODO(), &ec2.DescribeSecurityGroupsInput{
+		GroupIds: []string{UserSecurityGroupId},
+	})
+	if err != nil || len(result.SecurityGroups) < 1 {
+		log.Println("Error describing security group:", err)
+		check.description = fmt.Sprintf("Security group `%s` could not be found", UserSecurityGroupId)
+		if err != nil {
+			check.description += ": " + describeError(err)
+		}
+		return
+	}
+
+	group := result.SecurityGroups[0]
+	if vpcId != "" && aws.ToString(group.VpcId) != vpcId {
+		check.description = fmt.Sprintf("Security group `%s` belongs to `%s`, but the subnet is in `%s`", UserSecurityGroupId, aws.ToString(group.VpcId), vpcId)
+		return
+	}
+
+	check.passed = true
+	check.description = fmt.Sprintf("Security group `%s` (%s)", UserSecurityGroupId, aws.ToString(group.GroupName))
+	return
+}
+
+// Checks that the key pair exists, if one was asked for
+func checkKeyPair(api EC2PreflightAPI) (check preflightCheck) {
+	if UserKeyName == "" {
+		check.passed = true
+		check.description = "Key pair not set, the instance will launch without SSH access"
+		return
+	}
+
+	_, err := api.DescribeKeyPairs(context.TODO(), &ec2.DescribeKeyPairsInput{
+		KeyNames: []string{UserKeyName},
+	})
+	if err != nil {
+		log.Println("Error describing key pair:", err)
+		check.description = fmt.Sprintf("Key pair `%s` could not be found: %s", UserKeyName, describeError(err))
+		return
+	}
+
+	check.passed = true
+	check.description = fmt.Sprintf("Key pair `%s`", UserKeyName)
+	return
+}
+
+// Checks that the instance type is offered in the subnet's availability zone (or the region, if the subnet couldn't be found)
+func checkInstanceType(api EC2PreflightAPI, availabilityZone string) (check preflightCheck) {
+	input := &ec2.DescribeInstanceTypeOfferingsInput{
+		LocationType: types.LocationTypeRegion,
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("instance-type"),
+				Values: []string{UserInstanceType},
+			},
+		},
+	}
+
+	location := "this region"
+	if availabilityZone != "" {
+		input.LocationType = types.LocationTypeAvailabilityZone
+		input.Filters = append(input.Filters, types.Filter{
+			Name:   aws.String("location"),
+			Values: []string{availabilityZone},
+		})
+		location = fmt.Sprintf("`%s`", availabilityZone)
+	}
+
+	result, err := api.DescribeInstanceTypeOfferings(context.TODO(), input)
+	if err != nil {
+		log.Println("Error describing instance type offerings:", err)
+		check.description = fmt.Sprintf("Instance type `%s` could not be checked: %s", UserInstanceType, describeError(err))
+		return
+	}
+
+	if len(result.InstanceTypeOfferings) < 1 {
+		check.description = fmt.Sprintf("Instance type `%s` is not offered in %s", UserInstanceType, location)
+		return
+	}
+
+	check.passed = true
+	check.description = fmt.Sprintf("Instance type `%s` is offered in %s", UserInstanceType, location)
+	return
+}
+
+// Renders the user data templates and checks they fit inside EC2's size limit
+func checkUserData() (check preflightCheck) {
+	err := renderUserData()
+	if err != nil {
+		check.description = fmt.Sprintf("User data `%s` could not be rendered: %s", UserDataTemplates, err)
+		return
+	}
+
+	check.passed = true
+	if len(userData) == 0 {
+		check.description = "User data not set, the instance will launch without a user data script"
+		return
+	}
+
+	check.description = fmt.Sprintf("User data `%s` rendered (%d of %d bytes)", UserDataTemplates, len(userData), userdata.MaxSize)
+	return
+}
+
+// Asks EC2 to validate the whole launch (including IAM permissions) without launching anything
+func checkDryRun(api EC2PreflightAPI) (check preflightCheck) {
+	input := buildRunInstancesInput()
+	input.DryRun = aws.Bool(true)
+
+	_, err := api.RunInstances(context.TODO(), input)
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "DryRunOperation" {
+		check.passed = true
+		check.description = "EC2 accepted the launch request (dry run)"
+		return
+	}
+
+	if err == nil {
+		err = errors.New("dry run unexpectedly succeeded")
+	}
+
+	log.Println("Error during RunInstances dry run:", err)
+	check.description = fmt.Sprintf("EC2 rejected the launch request (dry run): %s", describeError(err))
+	return
+}
+
+// Validates every !create parameter against EC2 and returns a readable checklist, and whether every check passed
+func PreflightEc2Instance(messageContentSlice []string, flagArray []string, client *ec2.Client, ssmClient *ssm.Client) (statusMessage string, passed bool) {
+	statusMessage = parseCreateFlags(messageContentSlice, flagArray)
+	if statusMessage != "" {
+		return
+	}
+
+	log.Println("Running pre-flight checks for !create...")
+
+	var api EC2PreflightAPI = client
+
+	subnetCheck, availabilityZone, vpcId := checkSubnet(api)
+	checks := []preflightCheck{
+		subnetCheck,
+		checkImage(api, ssmClient),
+		checkSecurityGroup(api, vpcId),
+		checkKeyPair(api),
+		checkInstanceType(api, availabilityZone),
+		checkHibernation(api),
+		checkUserData(),
+	}
+
+	passed = true
+	for _, check := range checks {
+		passed = passed && check.passed
+	}
+
+	// A dry run would only repeat whatever already failed above
+	if passed {
+		checks = append(checks, checkDryRun(api))
+		passed = checks[len(checks)-1].passed
+	}
+
+	var checklist strings.Builder
+	checklist.WriteString("**Pre-flight checks for `!create`:**\n")
+	for _, check := range checks {
+		if check.passed {
+			checklist.WriteString(":white_check_mark: ")
+		} else {
+			checklist.WriteString(":x: ")
+		}
+		checklist.WriteString(check.description + "\n")
+	}
+
+	statusMessage = checklist.String()
+	return
+}
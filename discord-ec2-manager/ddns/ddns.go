@@ -0,0 +1,130 @@
+package ddns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/awsclient"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/botconfig"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/inventory"
+)
+
+// Used when the config file doesn't set a TTL
+const defaultTTL = 60
+
+// How long to wait for an instance to be running before giving up on updating its record
+const runningWait = 10 * time.Minute
+
+// Provider points a hostname at an IP address, and removes it again
+type Provider interface {
+	Update(hostname string, ip string) error
+	Remove(hostname string) error
+}
+
+// EC2InstanceAPI defines the interface for the DescribeInstances function.
+type EC2InstanceAPI interface {
+	DescribeInstances(ctx context.Context,
+		params *ec2.DescribeInstancesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error)
+}
+
+// Creates the provider named in the config file, or returns nil if dynamic DNS is turned off
+func NewProvider(settings botconfig.DNS, clients *awsclient.Pool) Provider {
+	ttl := settings.TTL
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+
+	switch settings.Provider {
+	case "route53":
+		return &route53Provider{
+			client:       clients.Route53(settings.Account),
+			hostedZoneId: settings.HostedZoneId,
+			ttl:          ttl,
+		}
+	case "rfc2136":
+		return &rfc2136Provider{
+			server:        settings.Server,
+			zone:          fqdn(settings.Zone),
+			ttl:           uint32(ttl),
+			tsigKeyName:   settings.TsigKeyName,
+			tsigSecret:    settings.TsigSecret,
+			tsigAlgorithm: settings.TsigAlgorithm,
+		}
+	}
+
+	return nil
+}
+
+// Adds the trailing dot DNS names need to be fully qualified
+func fqdn(name string) string {
+	return strings.TrimSuffix(name, ".") + "."
+}
+
+// Returns the hostname published for an instance, i.e. mc.games.example.com, or an empty string if it doesn't get one
+func Hostname(settings botconfig.DNS, instance inventory.Instance) string {
+	if settings.Provider == "" || instance.Alias == "" {
+		return ""
+	}
+
+	return instance.Alias + "." + strings.TrimSuffix(settings.Zone, ".")
+}
+
+// Waits for the instance to be running, then points its hostname at its public IP, returning both
+func Publish(provider Provider, settings botconfig.DNS, api EC2InstanceAPI, instance inventory.Instance) (hostname string, ip string, err error) {
+	hostname = Hostname(settings, instance)
+	if provider == nil || hostname == "" {
+		return "", "", nil
+	}
+
+	input := &ec2.DescribeInstancesInput{InstanceIds: []string{instance.InstanceId}}
+	err = ec2.NewInstanceRunningWaiter(api).Wait(context.TODO(), input, runningWait)
+	if err != nil {
+		return hostname, "", err
+	}
+
+	result, err := api.DescribeInstances(context.TODO(), input)
+	if err != nil {
+		return hostname, "", err
+	}
+	for _, r := range result.Reservations {
+		for _, i := range r.Instances {
+			ip = aws.ToString(i.PublicIpAddress)
+		}
+	}
+	if ip == "" {
+		return hostname, "", errors.New("the instance has no public IP address")
+	}
+
+	err = provider.Update(hostname, ip)
+	if err != nil {
+		return hostname, ip, fmt.Errorf("error updating %s: %w", hostname, err)
+	}
+
+	log.Printf("Pointed %s at %s", hostname, ip)
+	return hostname, ip, nil
+}
+
+// Removes an instance's hostname once it's stopped or terminated, as its public IP is gone. Returns the hostname,
+// empty if the instance doesn't have one.
+func Unpublish(provider Provider, settings botconfig.DNS, instance inventory.Instance) (hostname string, err error) {
+	hostname = Hostname(settings, instance)
+	if provider == nil || hostname == "" {
+		return "", nil
+	}
+
+	err = provider.Remove(hostname)
+	if err != nil {
+		return hostname, fmt.Errorf("error removing %s: %w", hostname, err)
+	}
+
+	log.Printf("Removed %s", hostname)
+	return hostname, nil
+}
@@ -0,0 +1,311 @@
+package ddns
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/miekg/dns"
+
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/botconfig"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/inventory"
+)
+
+const (
+	testZone       = "games.example.com."
+	testKeyName    = "discord-ec2-manager."
+	testTsigSecret = "c2VjcmV0LXNlY3JldC1zZWNyZXQtc2VjcmV0IQ=="
+)
+
+// A DNS server that applies RFC 2136 updates to the A records it holds, like BIND would
+type updateServer struct {
+	t       *testing.T
+	address string
+
+	mu      sync.Mutex
+	records map[string][]string
+	updates int
+}
+
+// Starts an updateServer on a local TCP port, requiring updates to be signed with the test TSIG key
+func startUpdateServer(t *testing.T) *updateServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := &updateServer{t: t, address: listener.Addr().String(), records: map[string][]string{}}
+	server := &dns.Server{
+		Listener:   listener,
+		TsigSecret: map[string]string{testKeyName: testTsigSecret},
+		Handler:    dns.HandlerFunc(u.serveDNS),
+
+		// The default only accepts queries and notifies
+		MsgAcceptFunc: func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+	}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+
+	return u
+}
+
+func (u *updateServer) serveDNS(w dns.ResponseWriter, r *dns.Msg) {
+	reply := new(dns.Msg)
+	reply.SetReply(r)
+
+	tsig := r.IsTsig()
+	switch {
+	case r.Opcode != dns.OpcodeUpdate:
+		u.t.Errorf("got opcode %s, want UPDATE", dns.OpcodeToString[r.Opcode])
+		reply.Rcode = dns.RcodeRefused
+	case len(r.Question) != 1 || r.Question[0].Name != testZone || r.Question[0].Qtype != dns.TypeSOA:
+		u.t.Errorf("got zone section %v, want %s SOA", r.Question, testZone)
+		reply.Rcode = dns.RcodeNotZone
+	case tsig == nil || tsig.Hdr.Name != testKeyName || tsig.Algorithm != dns.HmacSHA256:
+		u.t.Errorf("got TSIG %v, want %s signed with %s", tsig, testKeyName, dns.HmacSHA256)
+		reply.Rcode = dns.RcodeNotAuth
+	case w.TsigStatus() != nil:
+		reply.Rcode = dns.RcodeNotAuth
+	default:
+		u.apply(r.Ns)
+	}
+
+	if tsig != nil {
+		reply.SetTsig(tsig.Hdr.Name, tsig.Algorithm, 300, time.Now().Unix())
+	}
+	w.WriteMsg(reply)
+}
+
+// Applies an update section: class ANY deletes an RRset, class IN adds a record
+func (u *updateServer) apply(updates []dns.RR) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.updates++
+	for _, rr := range updates {
+		header := rr.Header()
+		if header.Rrtype != dns.TypeA {
+			u.t.Errorf("got a %s record in the update, want only A records", dns.TypeToString[header.Rrtype])
+			continue
+		}
+
+		switch header.Class {
+		case dns.ClassANY:
+			delete(u.records, header.Name)
+		case dns.ClassINET:
+			u.records[header.Name] = append(u.records[header.Name], rr.(*dns.A).A.String())
+			if header.Ttl != 60 {
+				u.t.Errorf("got TTL %d for %s, want 60", header.Ttl, header.Name)
+			}
+		default:
+			u.t.Errorf("got class %s in the update", dns.ClassToString[header.Class])
+		}
+	}
+}
+
+func (u *updateServer) updateCount() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.updates
+}
+
+func (u *updateServer) lookup(name string) []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.records[name]
+}
+
+// Answers DescribeInstances with a running instance at ip
+type fakeEC2 struct {
+	ip string
+}
+
+func (f *fakeEC2) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	return &ec2.DescribeInstancesOutput{
+		Reservations: []ec2types.Reservation{{
+			Instances: []ec2types.Instance{{
+				InstanceId:      aws.String(params.InstanceIds[0]),
+				PublicIpAddress: aws.String(f.ip),
+				State:           &ec2types.InstanceState{Name: ec2types.InstanceStateNameRunning},
+			}},
+		}},
+	}, nil
+}
+
+func TestRFC2136PublishesAndRemovesRecords(t *testing.T) {
+	server := startUpdateServer(t)
+	settings := botconfig.DNS{
+		Provider:    "rfc2136",
+		Zone:        "games.example.com",
+		Server:      server.address,
+		TsigKeyName: "discord-ec2-manager",
+		TsigSecret:  testTsigSecret,
+	}
+	provider := NewProvider(settings, nil)
+	instance := inventory.Instance{InstanceId: "i-0123456789abcdef0", Alias: "mc"}
+
+	// Started
+	hostname, ip, err := Publish(provider, settings, &fakeEC2{ip: "203.0.113.7"}, instance)
+	if err != nil {
+		t.Fatal("publishing on start:", err)
+	}
+	if hostname != "mc.games.example.com" || ip != "203.0.113.7" {
+		t.Errorf("got %s at %s, want mc.games.example.com at 203.0.113.7", hostname, ip)
+	}
+	if got := server.lookup("mc.games.example.com."); len(got) != 1 || got[0] != "203.0.113.7" {
+		t.Errorf("after start got A records %v, want [203.0.113.7]", got)
+	}
+
+	// Started again with a new IP, which replaces the old record rather than adding to it
+	_, _, err = Publish(provider, settings, &fakeEC2{ip: "203.0.113.8"}, instance)
+	if err != nil {
+		t.Fatal("publishing on restart:", err)
+	}
+	if got := server.lookup("mc.games.example.com."); len(got) != 1 || got[0] != "203.0.113.8" {
+		t.Errorf("after restart got A records %v, want [203.0.113.8]", got)
+	}
+
+	// Stopped
+	hostname, err = Unpublish(provider, settings, instance)
+	if err != nil {
+		t.Fatal("removing on stop:", err)
+	}
+	if hostname != "mc.games.example.com" {
+		t.Errorf("got hostname %s, want mc.games.example.com", hostname)
+	}
+	if got := server.lookup("mc.games.example.com."); len(got) != 0 {
+		t.Errorf("after stop got A records %v, want none", got)
+	}
+
+	// Started, then terminated
+	_, _, err = Publish(provider, settings, &fakeEC2{ip: "203.0.113.9"}, instance)
+	if err != nil {
+		t.Fatal("publishing on start:", err)
+	}
+	_, err = Unpublish(provider, settings, instance)
+	if err != nil {
+		t.Fatal("removing on terminate:", err)
+	}
+	if got := server.lookup("mc.games.example.com."); len(got) != 0 {
+		t.Errorf("after terminate got A records %v, want none", got)
+	}
+
+	if updates := server.updateCount(); updates != 5 {
+		t.Errorf("the server applied %d updates, want 5", updates)
+	}
+}
+
+func TestRFC2136RejectsWrongTsigSecret(t *testing.T) {
+	server := startUpdateServer(t)
+	provider := &rfc2136Provider{
+		server:      server.address,
+		zone:        testZone,
+		ttl:         60,
+		tsigKeyName: "discord-ec2-manager",
+		tsigSecret:  "d3Jvbmctc2VjcmV0LXdyb25nLXNlY3JldCE=",
+	}
+
+	err := provider.Update("mc.games.example.com", "203.0.113.7")
+	if err == nil {
+		t.Fatal("got no error updating with the wrong TSIG secret")
+	}
+	if got := server.lookup("mc.games.example.com."); len(got) != 0 {
+		t.Errorf("got A records %v after a refused update, want none", got)
+	}
+}
+
+func TestInstancesWithoutAliasesAreSkipped(t *testing.T) {
+	server := startUpdateServer(t)
+	settings := botconfig.DNS{Provider: "rfc2136", Zone: "games.example.com", Server: server.address}
+	provider := NewProvider(settings, nil)
+	instance := inventory.Instance{InstanceId: "i-0123456789abcdef0"}
+
+	hostname, _, err := Publish(provider, settings, &fakeEC2{ip: "203.0.113.7"}, instance)
+	if err != nil || hostname != "" {
+		t.Errorf("got hostname %q and error %v, want neither", hostname, err)
+	}
+	hostname, err = Unpublish(provider, settings, instance)
+	if err != nil || hostname != "" {
+		t.Errorf("got hostname %q and error %v, want neither", hostname, err)
+	}
+	if updates := server.updateCount(); updates != 0 {
+		t.Errorf("the server got %d updates, want none", updates)
+	}
+}
+
+// Keeps the record sets of one hosted zone, like Route 53
+type fakeRoute53 struct {
+	records map[string]types.ResourceRecordSet
+	changes []types.Change
+}
+
+func (f *fakeRoute53) ChangeResourceRecordSets(ctx context.Context, params *route53.ChangeResourceRecordSetsInput, optFns ...func(*route53.Options)) (*route53.ChangeResourceRecordSetsOutput, error) {
+	for _, change := range params.ChangeBatch.Changes {
+		f.changes = append(f.changes, change)
+		name := aws.ToString(change.ResourceRecordSet.Name)
+		if change.Action == types.ChangeActionDelete {
+			delete(f.records, name)
+		} else {
+			f.records[name] = *change.ResourceRecordSet
+		}
+	}
+
+	return &route53.ChangeResourceRecordSetsOutput{}, nil
+}
+
+func (f *fakeRoute53) ListResourceRecordSets(ctx context.Context, params *route53.ListResourceRecordSetsInput, optFns ...func(*route53.Options)) (*route53.ListResourceRecordSetsOutput, error) {
+	output := &route53.ListResourceRecordSetsOutput{}
+	if record, found := f.records[aws.ToString(params.StartRecordName)]; found {
+		output.ResourceRecordSets = []types.ResourceRecordSet{record}
+	}
+
+	return output, nil
+}
+
+func TestRoute53PublishesAndRemovesRecords(t *testing.T) {
+	client := &fakeRoute53{records: map[string]types.ResourceRecordSet{}}
+	provider := &route53Provider{client: client, hostedZoneId: "Z0123456789ABCDEFGHIJ", ttl: 60}
+	settings := botconfig.DNS{Provider: "route53", Zone: "games.example.com"}
+	instance := inventory.Instance{InstanceId: "i-0123456789abcdef0", Alias: "mc"}
+
+	_, _, err := Publish(provider, settings, &fakeEC2{ip: "203.0.113.7"}, instance)
+	if err != nil {
+		t.Fatal("publishing on start:", err)
+	}
+	record, found := client.records["mc.games.example.com."]
+	if !found || record.Type != types.RRTypeA || aws.ToInt64(record.TTL) != 60 || len(record.ResourceRecords) != 1 || aws.ToString(record.ResourceRecords[0].Value) != "203.0.113.7" {
+		t.Errorf("after start got record %+v, want an A record for 203.0.113.7", record)
+	}
+	if client.changes[0].Action != types.ChangeActionUpsert {
+		t.Errorf("got action %s on start, want UPSERT", client.changes[0].Action)
+	}
+
+	_, err = Unpublish(provider, settings, instance)
+	if err != nil {
+		t.Fatal("removing on stop:", err)
+	}
+	if _, found := client.records["mc.games.example.com."]; found {
+		t.Error("the record is still there after stop")
+	}
+	if deleted := client.changes[1]; deleted.Action != types.ChangeActionDelete || aws.ToString(deleted.ResourceRecordSet.ResourceRecords[0].Value) != "203.0.113.7" {
+		t.Errorf("got change %+v on stop, want the record deleted with its current value", deleted)
+	}
+
+	// Terminating an instance whose record is already gone doesn't send a change Route 53 would reject
+	_, err = Unpublish(provider, settings, instance)
+	if err != nil {
+		t.Fatal("removing on terminate:", err)
+	}
+	if len(client.changes) != 2 {
+		t.Errorf("got %d changes, want 2", len(client.changes))
+	}
+}
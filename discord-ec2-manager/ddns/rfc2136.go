@@ -0,0 +1,71 @@
+package ddns
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Sends RFC 2136 dynamic updates to a DNS server (i.e. BIND or Knot), optionally signed with a TSIG key
+type rfc2136Provider struct {
+	server        string
+	zone          string
+	ttl           uint32
+	tsigKeyName   string
+	tsigSecret    string
+	tsigAlgorithm string
+}
+
+// Replaces the hostname's A records with one pointing at ip
+func (p *rfc2136Provider) Update(hostname string, ip string) error {
+	address := net.ParseIP(ip).To4()
+	if address == nil {
+		return fmt.Errorf("%q is not an IPv4 address", ip)
+	}
+
+	header := dns.RR_Header{Name: fqdn(hostname), Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: p.ttl}
+
+	message := new(dns.Msg)
+	message.SetUpdate(p.zone)
+	message.RemoveRRset([]dns.RR{&dns.A{Hdr: header}})
+	message.Insert([]dns.RR{&dns.A{Hdr: header, A: address}})
+
+	return p.send(message)
+}
+
+// Removes the hostname's A records
+func (p *rfc2136Provider) Remove(hostname string) error {
+	message := new(dns.Msg)
+	message.SetUpdate(p.zone)
+	message.RemoveRRset([]dns.RR{&dns.A{Hdr: dns.RR_Header{Name: fqdn(hostname), Rrtype: dns.TypeA, Class: dns.ClassINET}}})
+
+	return p.send(message)
+}
+
+// Signs the update with the TSIG key (if there is one) and sends it to the server over TCP
+func (p *rfc2136Provider) send(message *dns.Msg) error {
+	client := new(dns.Client)
+	client.Net = "tcp"
+	if p.tsigKeyName != "" {
+		keyName := fqdn(p.tsigKeyName)
+		algorithm := p.tsigAlgorithm
+		if algorithm == "" {
+			algorithm = dns.HmacSHA256
+		}
+
+		client.TsigSecret = map[string]string{keyName: p.tsigSecret}
+		message.SetTsig(keyName, fqdn(algorithm), 300, time.Now().Unix())
+	}
+
+	reply, _, err := client.Exchange(message, p.server)
+	if err != nil {
+		return err
+	}
+	if reply.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("%s refused the update: %s", p.server, dns.RcodeToString[reply.Rcode])
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package ddns
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// Route53API defines the interface for the ChangeResourceRecordSets and ListResourceRecordSets functions.
+type Route53API interface {
+	ChangeResourceRecordSets(ctx context.Context,
+		params *route53.ChangeResourceRecordSetsInput,
+		optFns ...func(*route53.Options)) (*route53.ChangeResourceRecordSetsOutput, error)
+
+	ListResourceRecordSets(ctx context.Context,
+		params *route53.ListResourceRecordSetsInput,
+		optFns ...func(*route53.Options)) (*route53.ListResourceRecordSetsOutput, error)
+}
+
+// Updates records in a Route 53 hosted zone
+type route53Provider struct {
+	client       Route53API
+	hostedZoneId string
+	ttl          int64
+}
+
+// Creates or replaces the hostname's A record
+func (p *route53Provider) Update(hostname string, ip string) error {
+	_, err := p.client.ChangeResourceRecordSets(context.TODO(), &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(p.hostedZoneId),
+		ChangeBatch: &types.ChangeBatch{
+			Comment: aws.String("discord-ec2-manager dynamic DNS"),
+			Changes: []types.Change{
+				{
+					Action: types.ChangeActionUpsert,
+					ResourceRecordSet: &types.ResourceRecordSet{
+						Name:            aws.String(fqdn(hostname)),
+						Type:            types.RRTypeA,
+						TTL:             aws.Int64(p.ttl),
+						ResourceRecords: []types.ResourceRecord{{Value: aws.String(ip)}},
+					},
+				},
+			},
+		},
+	})
+
+	return err
+}
+
+// Deletes the hostname's A record, if it has one. Route 53 only deletes a record given its current value, so it's
+// looked up first.
+func (p *route53Provider) Remove(hostname string) error {
+	result, err := p.client.ListResourceRecordSets(context.TODO(), &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(p.hostedZoneId),
+		StartRecordName: aws.String(fqdn(hostname)),
+		StartRecordType: types.RRTypeA,
+		MaxItems:        aws.Int32(1),
+	})
+	if err != nil {
+		return err
+	}
+	if len(result.ResourceRecordSets) < 1 {
+		return nil
+	}
+
+	record := result.ResourceRecordSets[0]
+	if !strings.EqualFold(aws.ToString(record.Name), fqdn(hostname)) || record.Type != types.RRTypeA {
+		return nil
+	}
+
+	_, err = p.client.ChangeResourceRecordSets(context.TODO(), &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(p.hostedZoneId),
+		ChangeBatch: &types.ChangeBatch{
+			Comment: aws.String("discord-ec2-manager dynamic DNS"),
+			Changes: []types.Change{{Action: types.ChangeActionDelete, ResourceRecordSet: &record}},
+		},
+	})
+
+	return err
+}
@@ -0,0 +1,297 @@
+package eip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/backup"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/cost"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/inventory"
+)
+
+// What AWS charges per hour for a public IPv4 address, including Elastic IPs that aren't associated with anything
+const HourlyPrice = 0.005
+
+// How long to wait for a new instance to be running before its Elastic IP can be associated
+const runningWait = 5 * time.Minute
+
+// EC2AddressAPI defines the interface for the EC2 functions used to manage Elastic IPs.
+type EC2AddressAPI interface {
+	DescribeInstances(ctx context.Context,
+		params *ec2.DescribeInstancesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error)
+
+	DescribeAddresses(ctx context.Context,
+		params *ec2.DescribeAddressesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeAddressesOutput, error)
+
+	AllocateAddress(ctx context.Context,
+		params *ec2.AllocateAddressInput,
+		optFns ...func(*ec2.Options)) (*ec2.AllocateAddressOutput, error)
+
+	AssociateAddress(ctx context.Context,
+		params *ec2.AssociateAddressInput,
+		optFns ...func(*ec2.Options)) (*ec2.AssociateAddressOutput, error)
+
+	DisassociateAddress(ctx context.Context,
+		params *ec2.DisassociateAddressInput,
+		optFns ...func(*ec2.Options)) (*ec2.DisassociateAddressOutput, error)
+
+	ReleaseAddress(ctx context.Context,
+		params *ec2.ReleaseAddressInput,
+		optFns ...func(*ec2.Options)) (*ec2.ReleaseAddressOutput, error)
+}
+
+// Returns the value of a tag, or an empty string if it isn't set
+func tagValue(tags []types.Tag, key string) string {
+	for _, tag := range tags {
+		if aws.ToString(tag.Key) == key {
+			return aws.ToString(tag.Value)
+		}
+	}
+
+	return ""
+}
+
+// Gets the Elastic IPs associated with the given instances
+func ForInstances(api EC2AddressAPI, instanceIds []string) ([]types.Address, error) {
+	result, err := api.DescribeAddresses(context.TODO(), &ec2.DescribeAddressesInput{
+		Filters: []types.Filter{
+			{Name: aws.String("instance-id"), Values: instanceIds},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Addresses, nil
+}
+
+// Lists the Elastic IPs the bot allocated in the client's account and region
+func List(api EC2AddressAPI) ([]types.Address, error) {
+	result, err := api.DescribeAddresses(context.TODO(), &ec2.DescribeAddressesInput{
+		Filters: []types.Filter{
+			{Name: aws.String("tag-key"), Values: []string{backup.AliasTag}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Addresses, nil
+}
+
+// Reports whether the bot allocated the address for the instance, going by its alias tag. Addresses without it were
+// allocated by hand, so the bot never releases them.
+func allocatedFor(address types.Address, instance inventory.Instance) bool {
+	return tagValue(address.Tags, backup.AliasTag) == instance.Name()
+}
+
+// Returned by Attach when the instance already has an Elastic IP the bot didn't allocate for it, which is left alone
+type ForeignAddressError struct {
+	PublicIp string
+}
+
+func (e *ForeignAddressError) Error() string {
+	return fmt.Sprintf("the instance already has the Elastic IP %s, which the bot didn't allocate for it", e.PublicIp)
+}
+
+// Returns the Elastic IPs allocated for an instance (by its alias tag) that aren't associated with anything
+func unassociated(api EC2AddressAPI, instance inventory.Instance) ([]types.Address, error) {
+	addresses, err := List(api)
+	if err != nil {
+		return nil, err
+	}
+
+	var idle []types.Address
+	for _, address := range addresses {
+		if address.AssociationId == nil && allocatedFor(address, instance) {
+			idle = append(idle, address)
+		}
+	}
+
+	return idle, nil
+}
+
+// Associates an Elastic IP with the instance, returning the address. An address already allocated for the instance is
+// reused, otherwise a new one is allocated and tagged with the instance's alias. Does nothing if the instance already
+// has one (returning a ForeignAddressError if the bot didn't allocate it), and waits on a new instance until it's no
+// longer pending.
+func Attach(api EC2AddressAPI, instance inventory.Instance) (publicIp string, err error) {
+	existing, err := ForInstances(api, []string{instance.InstanceId})
+	if err != nil {
+		return "", err
+	}
+	for _, address := range existing {
+		if allocatedFor(address, instance) {
+			return aws.ToString(address.PublicIp), nil
+		}
+	}
+	if len(existing) > 0 {
+		return "", &ForeignAddressError{PublicIp: aws.ToString(existing[0].PublicIp)}
+	}
+
+	err = ec2.NewInstanceRunningWaiter(api).Wait(context.TODO(), &ec2.DescribeInstancesInput{InstanceIds: []string{instance.InstanceId}}, runningWait, func(o *ec2.InstanceRunningWaiterOptions) {
+		o.Retryable = instancePending
+	})
+	if err != nil {
+		return "", err
+	}
+
+	idle, err := unassociated(api, instance)
+	if err != nil {
+		return "", err
+	}
+
+	var address types.Address
+	allocated := false
+	if len(idle) > 0 {
+		address = idle[0]
+	} else {
+		allocation, err := api.AllocateAddress(context.TODO(), &ec2.AllocateAddressInput{
+			Domain: types.DomainTypeVpc,
+			TagSpecifications: []types.TagSpecification{
+				{
+					ResourceType: types.ResourceTypeElasticIp,
+					Tags: []types.Tag{
+						{Key: aws.String("Name"), Value: aws.String(instance.Name())},
+						{Key: aws.String(backup.AliasTag), Value: aws.String(instance.Name())},
+					},
+				},
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+
+		address = types.Address{AllocationId: allocation.AllocationId, PublicIp: allocation.PublicIp}
+		allocated = true
+		log.Printf("Allocated Elastic IP %s (%s) for %s", aws.ToString(address.PublicIp), aws.ToString(address.AllocationId), instance.Name())
+	}
+
+	_, err = api.AssociateAddress(context.TODO(), &ec2.AssociateAddressInput{
+		AllocationId: address.AllocationId,
+		InstanceId:   aws.String(instance.InstanceId),
+	})
+	if err != nil {
+		// Doesn't leave a new address behind to be charged for
+		if allocated {
+			releaseErr := Release(api, address)
+			if releaseErr != nil {
+				log.Printf("Error releasing Elastic IP %s: %v", aws.ToString(address.AllocationId), releaseErr)
+			}
+		}
+		return "", err
+	}
+
+	return aws.ToString(address.PublicIp), nil
+}
+
+// Releases the Elastic IPs the bot allocated for the instance, returning the released addresses. Any other address
+// associated with the instance was allocated by hand, so it's only disassociated (and returned in disassociated), and
+// stays in the account.
+func ReleaseFor(api EC2AddressAPI, instance inventory.Instance) (released []string, disassociated []string, err error) {
+	addresses, err := ForInstances(api, []string{instance.InstanceId})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	idle, err := unassociated(api, instance)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, address := range append(addresses, idle...) {
+		if !allocatedFor(address, instance) {
+			err = Disassociate(api, address)
+			if err != nil {
+				return released, disassociated, err
+			}
+			disassociated = append(disassociated, aws.ToString(address.PublicIp))
+			continue
+		}
+
+		err = Release(api, address)
+		if err != nil {
+			return released, disassociated, err
+		}
+		released = append(released, aws.ToString(address.PublicIp))
+	}
+
+	return released, disassociated, nil
+}
+
+// Waits on the instance while it's pending (or too new for EC2 to know about yet), since a stopped instance can have an
+// Elastic IP associated too
+func instancePending(ctx context.Context, params *ec2.DescribeInstancesInput, output *ec2.DescribeInstancesOutput, err error) (bool, error) {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidInstanceID.NotFound" {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for _, r := range output.Reservations {
+		for _, i := range r.Instances {
+			if i.State != nil && i.State.Name == types.InstanceStateNamePending {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// Disassociates an Elastic IP from its instance, if it's associated, keeping it in the account
+func Disassociate(api EC2AddressAPI, address types.Address) error {
+	if address.AssociationId == nil {
+		return nil
+	}
+
+	_, err := api.DisassociateAddress(context.TODO(), &ec2.DisassociateAddressInput{
+		AssociationId: address.AssociationId,
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Disassociated Elastic IP %s (%s)", aws.ToString(address.PublicIp), aws.ToString(address.AllocationId))
+	return nil
+}
+
+// Disassociates an Elastic IP (if it's associated) and releases it
+func Release(api EC2AddressAPI, address types.Address) error {
+	err := Disassociate(api, address)
+	if err != nil {
+		return err
+	}
+
+	_, err = api.ReleaseAddress(context.TODO(), &ec2.ReleaseAddressInput{
+		AllocationId: address.AllocationId,
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Released Elastic IP %s (%s)", aws.ToString(address.PublicIp), aws.ToString(address.AllocationId))
+	return nil
+}
+
+// Describes an Elastic IP for !eip, warning about addresses that are costing money without being used
+func Describe(address types.Address) string {
+	alias := tagValue(address.Tags, backup.AliasTag)
+	if address.InstanceId == nil {
+		return fmt.Sprintf(":warning: `%s` (allocated for `%s`) isn't associated with an instance, but still costs $%.3f/hour (about $%.2f a month). Use `!eip attach` or `!eip release` on it.", aws.ToString(address.PublicIp), alias, HourlyPrice, HourlyPrice*cost.HoursPerMonth)
+	}
+
+	return fmt.Sprintf("`%s`: `%s` (`%s`)", alias, aws.ToString(address.PublicIp), aws.ToString(address.InstanceId))
+}
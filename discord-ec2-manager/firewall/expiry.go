@@ -0,0 +1,48 @@
+package firewall
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/awsclient"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/inventory"
+)
+
+// How often rules are checked for expiry
+const expiryCheckInterval = time.Minute
+
+// Removes rules once they expire, in every account and region the bot has instances in. Runs until the bot exits.
+func EnforceExpiry(inv *inventory.Inventory, clients *awsclient.Pool, post func(message string, about ...inventory.Instance)) {
+	for {
+		now := time.Now()
+		locations := inventory.ByLocation(inv.All())
+		for _, location := range inventory.SortedLocations(locations) {
+			api := clients.EC2(location)
+			rules, err := listRules(api, "")
+			if err != nil {
+				log.Printf("Error listing ingress rules in %s: %v", location, err)
+				continue
+			}
+
+			for _, rule := range rules {
+				if rule.Expires.IsZero() || rule.Expires.After(now) {
+					continue
+				}
+
+				err = Revoke(api, rule)
+				if err != nil {
+					log.Printf("Error revoking expired rule %s: %v", rule.Id, err)
+					continue
+				}
+				instance, found := inv.Find(rule.Alias)
+				if !found {
+					instance = inventory.Instance{Alias: rule.Alias}
+				}
+				post(fmt.Sprintf(":hourglass: %s's access to `%s` from `%s` has expired.", rule.RequestedBy, rule.Alias, rule.Cidr), instance)
+			}
+		}
+
+		time.Sleep(expiryCheckInterval)
+	}
+}
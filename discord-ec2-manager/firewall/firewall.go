@@ -0,0 +1,263 @@
+package firewall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/backup"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/inventory"
+)
+
+// Tags recording who asked for an ingress rule, and when the bot should remove it
+const (
+	RequestedByTag = "discord-ec2-manager:requested-by"
+	ExpiresTag     = "discord-ec2-manager:expires"
+)
+
+// EC2RuleAPI defines the interface for the EC2 functions used to manage an instance's ingress rules.
+type EC2RuleAPI interface {
+	DescribeInstances(ctx context.Context,
+		params *ec2.DescribeInstancesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error)
+
+	DescribeSecurityGroupRules(ctx context.Context,
+		params *ec2.DescribeSecurityGroupRulesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeSecurityGroupRulesOutput, error)
+
+	AuthorizeSecurityGroupIngress(ctx context.Context,
+		params *ec2.AuthorizeSecurityGroupIngressInput,
+		optFns ...func(*ec2.Options)) (*ec2.AuthorizeSecurityGroupIngressOutput, error)
+
+	RevokeSecurityGroupIngress(ctx context.Context,
+		params *ec2.RevokeSecurityGroupIngressInput,
+		optFns ...func(*ec2.Options)) (*ec2.RevokeSecurityGroupIngressOutput, error)
+}
+
+// The port (and protocol) players connect to, which rules are opened on
+type Port struct {
+	Number   int32
+	Protocol string
+}
+
+// An ingress rule the bot added for someone
+type Rule struct {
+	Id          string
+	GroupId     string
+	Cidr        string
+	Alias       string
+	RequestedBy string
+
+	// Zero if the rule never expires
+	Expires time.Time
+}
+
+// Describes a rule for !allow, i.e. `1.2.3.4/32` (for jacob, until 2023-01-12 21:15 UTC)
+func (r Rule) String() string {
+	expires := "never expires"
+	if !r.Expires.IsZero() {
+		expires = "until " + r.Expires.UTC().Format("2006-01-02 15:04 MST")
+	}
+
+	return fmt.Sprintf("`%s` (for %s, %s)", r.Cidr, r.RequestedBy, expires)
+}
+
+// Returns the value of a tag, or an empty string if it isn't set
+func tagValue(tags []types.Tag, key string) string {
+	for _, tag := range tags {
+		if aws.ToString(tag.Key) == key {
+			return aws.ToString(tag.Value)
+		}
+	}
+
+	return ""
+}
+
+// Turns an IP address into a single address CIDR block, reporting whether it's IPv6
+func singleAddress(ip string) (cidr string, ipv6 bool, err error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", false, fmt.Errorf("%q is not an IP address", ip)
+	}
+	if parsed.To4() != nil {
+		return parsed.To4().String() + "/32", false, nil
+	}
+
+	return parsed.String() + "/128", true, nil
+}
+
+// Finds the security group rules for an instance go in, its first security group
+func securityGroup(api EC2RuleAPI, instance inventory.Instance) (string, error) {
+	result, err := api.DescribeInstances(context.TODO(), &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instance.InstanceId},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	for _, r := range result.Reservations {
+		for _, i := range r.Instances {
+			if len(i.SecurityGroups) > 0 {
+				return aws.ToString(i.SecurityGroups[0].GroupId), nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("instance %s has no security group", instance.InstanceId)
+}
+
+// Converts a security group rule the bot added into a Rule
+func fromSecurityGroupRule(rule types.SecurityGroupRule) Rule {
+	cidr := aws.ToString(rule.CidrIpv4)
+	if cidr == "" {
+		cidr = aws.ToString(rule.CidrIpv6)
+	}
+
+	expires, _ := time.Parse(time.RFC3339, tagValue(rule.Tags, ExpiresTag))
+	return Rule{
+		Id:          aws.ToString(rule.SecurityGroupRuleId),
+		GroupId:     aws.ToString(rule.GroupId),
+		Cidr:        cidr,
+		Alias:       tagValue(rule.Tags, backup.AliasTag),
+		RequestedBy: tagValue(rule.Tags, RequestedByTag),
+		Expires:     expires,
+	}
+}
+
+// Lists the rules the bot added, either for one security group or (with an empty groupId) every group in the client's region
+func listRules(api EC2RuleAPI, groupId string) ([]Rule, error) {
+	filters := []types.Filter{
+		{Name: aws.String("tag-key"), Values: []string{RequestedByTag}},
+	}
+	if groupId != "" {
+		filters = append(filters, types.Filter{Name: aws.String("group-id"), Values: []string{groupId}})
+	}
+
+	var rules []Rule
+	paginator := ec2.NewDescribeSecurityGroupRulesPaginator(api, &ec2.DescribeSecurityGroupRulesInput{Filters: filters})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rule := range page.SecurityGroupRules {
+			if !aws.ToBool(rule.IsEgress) {
+				rules = append(rules, fromSecurityGroupRule(rule))
+			}
+		}
+	}
+
+	return rules, nil
+}
+
+// Lists the rules the bot added to an instance's security group
+func List(api EC2RuleAPI, instance inventory.Instance) ([]Rule, error) {
+	groupId, err := securityGroup(api, instance)
+	if err != nil {
+		return nil, err
+	}
+
+	return listRules(api, groupId)
+}
+
+// Opens the port to an IP address on the instance's security group, tagging the rule with who asked for it and when it
+// expires (a zero expires never does)
+func Allow(api EC2RuleAPI, instance inventory.Instance, port Port, ip string, requestedBy string, expires time.Time) (Rule, error) {
+	cidr, ipv6, err := singleAddress(ip)
+	if err != nil {
+		return Rule{}, err
+	}
+
+	groupId, err := securityGroup(api, instance)
+	if err != nil {
+		return Rule{}, err
+	}
+
+	description := aws.String(fmt.Sprintf("discord-ec2-manager: %s for %s", instance.Name(), requestedBy))
+	permission := types.IpPermission{
+		IpProtocol: aws.String(port.Protocol),
+		FromPort:   aws.Int32(port.Number),
+		ToPort:     aws.Int32(port.Number),
+	}
+	if ipv6 {
+		permission.Ipv6Ranges = []types.Ipv6Range{{CidrIpv6: aws.String(cidr), Description: description}}
+	} else {
+		permission.IpRanges = []types.IpRange{{CidrIp: aws.String(cidr), Description: description}}
+	}
+
+	tags := []types.Tag{
+		{Key: aws.String(backup.AliasTag), Value: aws.String(instance.Name())},
+		{Key: aws.String(RequestedByTag), Value: aws.String(requestedBy)},
+	}
+	if !expires.IsZero() {
+		tags = append(tags, types.Tag{Key: aws.String(ExpiresTag), Value: aws.String(expires.UTC().Format(time.RFC3339))})
+	}
+
+	result, err := api.AuthorizeSecurityGroupIngress(context.TODO(), &ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId:       aws.String(groupId),
+		IpPermissions: []types.IpPermission{permission},
+		TagSpecifications: []types.TagSpecification{
+			{ResourceType: types.ResourceTypeSecurityGroupRule, Tags: tags},
+		},
+	})
+	if err != nil {
+		return Rule{}, err
+	}
+	if len(result.SecurityGroupRules) < 1 {
+		return Rule{}, errors.New("EC2 didn't return the new rule")
+	}
+
+	rule := fromSecurityGroupRule(result.SecurityGroupRules[0])
+	log.Printf("Allowed %s to %s on port %d/%s (%s) for %s", rule.Cidr, instance.Name(), port.Number, port.Protocol, rule.Id, requestedBy)
+	return rule, nil
+}
+
+// Removes the bot's rules for an IP address from the instance's security group, returning the rules removed
+func Deny(api EC2RuleAPI, instance inventory.Instance, ip string) ([]Rule, error) {
+	cidr, _, err := singleAddress(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := List(api, instance)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []Rule
+	for _, rule := range rules {
+		if rule.Cidr != cidr {
+			continue
+		}
+
+		err = Revoke(api, rule)
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, rule)
+	}
+
+	return removed, nil
+}
+
+// Removes a single rule
+func Revoke(api EC2RuleAPI, rule Rule) error {
+	_, err := api.RevokeSecurityGroupIngress(context.TODO(), &ec2.RevokeSecurityGroupIngressInput{
+		GroupId:              aws.String(rule.GroupId),
+		SecurityGroupRuleIds: []string{rule.Id},
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Revoked %s (%s) from %s", rule.Id, rule.Cidr, rule.GroupId)
+	return nil
+}
@@ -0,0 +1,120 @@
+package firewall
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/inventory"
+)
+
+// How long a me-link can be opened for after it's posted
+const meLinkLifetime = 15 * time.Minute
+
+// A pending !allow waiting on the player to open their me-link
+type MeRequest struct {
+	Instance    inventory.Instance
+	RequestedBy string
+	Expires     time.Time
+	created     time.Time
+}
+
+// MeLinks hands out single use links that allow whoever opens them, so players don't need to know their own IP
+type MeLinks struct {
+	publicUrl  string
+	trustProxy bool
+
+	// Called with the visitor's IP when a link is opened, returning the message shown in their browser
+	onVisit func(request MeRequest, ip string) string
+
+	mu      sync.Mutex
+	pending map[string]MeRequest
+}
+
+// Creates MeLinks for links under publicUrl (i.e. https://bot.example.com). With trustProxy, the visitor's IP is read
+// from the X-Forwarded-For entry added by the load balancer the bot sits behind.
+func NewMeLinks(publicUrl string, trustProxy bool, onVisit func(request MeRequest, ip string) string) *MeLinks {
+	return &MeLinks{
+		publicUrl:  strings.TrimSuffix(publicUrl, "/"),
+		trustProxy: trustProxy,
+		onVisit:    onVisit,
+		pending:    map[string]MeRequest{},
+	}
+}
+
+// Returns a new link that fulfils the request when opened
+func (l *MeLinks) Create(request MeRequest) (string, error) {
+	buffer := make([]byte, 16)
+	_, err := rand.Read(buffer)
+	if err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buffer)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Drops links nobody opened in time
+	for pendingToken, pending := range l.pending {
+		if time.Since(pending.created) > meLinkLifetime {
+			delete(l.pending, pendingToken)
+		}
+	}
+
+	request.created = time.Now()
+	l.pending[token] = request
+	return fmt.Sprintf("%s/me/%s", l.publicUrl, token), nil
+}
+
+// Works out the visitor's IP address. Behind a proxy that's the last X-Forwarded-For entry, which the proxy appended
+// itself: earlier ones come from the client, so anyone could claim any address with them.
+func (l *MeLinks) visitorIp(r *http.Request) string {
+	if l.trustProxy {
+		forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+		if last := strings.TrimSpace(forwarded[len(forwarded)-1]); last != "" {
+			return last
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// Serves /me/<token>. Opening the link shows a button, and only pressing it (a POST) allows the visitor's IP, so link
+// previews (i.e. Discord's own) can't use the link up.
+func (l *MeLinks) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, "/me/")
+
+	l.mu.Lock()
+	request, ok := l.pending[token]
+	if ok && r.Method == http.MethodPost {
+		delete(l.pending, token)
+	}
+	l.mu.Unlock()
+
+	if !ok || time.Since(request.created) > meLinkLifetime {
+		http.Error(w, "This link has expired or already been used, ask for a new one with !allow in Discord.", http.StatusNotFound)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, `<!DOCTYPE html><title>discord-ec2-manager</title><form method="post"><button type="submit">Allow my IP address to connect to %s</button></form>`, html.EscapeString(request.Instance.Name()))
+		return
+	}
+
+	ip := l.visitorIp(r)
+	log.Printf("Me-link for %s opened from %s", request.Instance.Name(), ip)
+	fmt.Fprintln(w, l.onVisit(request, ip))
+}
@@ -5,8 +5,15 @@ go 1.16
 require (
 	github.com/aws/aws-sdk-go-v2 v1.16.7
 	github.com/aws/aws-sdk-go-v2/config v1.15.14
+	github.com/aws/aws-sdk-go-v2/credentials v1.12.9
 	github.com/aws/aws-sdk-go-v2/service/ec2 v1.49.1
+	github.com/aws/aws-sdk-go-v2/service/route53 v1.21.3
+	github.com/aws/aws-sdk-go-v2/service/sqs v1.19.0
+	github.com/aws/aws-sdk-go-v2/service/ssm v1.27.5
+	github.com/aws/aws-sdk-go-v2/service/sts v1.16.9
+	github.com/aws/smithy-go v1.12.0
 	github.com/bwmarrin/discordgo v0.25.0
 	github.com/gorilla/websocket v1.5.0 // indirect
+	github.com/miekg/dns v1.1.50
 	golang.org/x/crypto v0.6.0 // indirect
 )
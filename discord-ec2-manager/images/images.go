@@ -0,0 +1,187 @@
+package images
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/backup"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/inventory"
+)
+
+// Marks an AMI (and its snapshots) as baked by the bot, holding the name !create --ami-alias looks it up by
+const NameTag = "discord-ec2-manager:image"
+
+// How long to wait for a new AMI to become available
+const availableWait = 60 * time.Minute
+
+// Image names end up in AMI names and tags, so they're kept simple
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// EC2ImageAPI defines the interface for the EC2 functions used to bake, list and delete the bot's AMIs.
+type EC2ImageAPI interface {
+	CreateImage(ctx context.Context,
+		params *ec2.CreateImageInput,
+		optFns ...func(*ec2.Options)) (*ec2.CreateImageOutput, error)
+
+	DescribeImages(ctx context.Context,
+		params *ec2.DescribeImagesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error)
+
+	DeregisterImage(ctx context.Context,
+		params *ec2.DeregisterImageInput,
+		optFns ...func(*ec2.Options)) (*ec2.DeregisterImageOutput, error)
+
+	DeleteSnapshot(ctx context.Context,
+		params *ec2.DeleteSnapshotInput,
+		optFns ...func(*ec2.Options)) (*ec2.DeleteSnapshotOutput, error)
+}
+
+// Returns the value of a tag, or an empty string if it isn't set
+func tagValue(tags []types.Tag, key string) string {
+	for _, tag := range tags {
+		if aws.ToString(tag.Key) == key {
+			return aws.ToString(tag.Value)
+		}
+	}
+
+	return ""
+}
+
+// Returns an image's name, as given to !image --name
+func Name(image types.Image) string {
+	return tagValue(image.Tags, NameTag)
+}
+
+// Lists the bot's AMIs in the client's account and region, sorted by name
+func List(api EC2ImageAPI) ([]types.Image, error) {
+	result, err := api.DescribeImages(context.TODO(), &ec2.DescribeImagesInput{
+		Owners: []string{"self"},
+		Filters: []types.Filter{
+			{Name: aws.String("tag-key"), Values: []string{NameTag}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(result.Images, func(i, j int) bool {
+		return Name(result.Images[i]) < Name(result.Images[j])
+	})
+
+	return result.Images, nil
+}
+
+// Finds one of the bot's AMIs by name
+func Find(api EC2ImageAPI, name string) (types.Image, bool, error) {
+	result, err := api.DescribeImages(context.TODO(), &ec2.DescribeImagesInput{
+		Owners: []string{"self"},
+		Filters: []types.Filter{
+			{Name: aws.String("tag:" + NameTag), Values: []string{name}},
+		},
+	})
+	if err != nil {
+		return types.Image{}, false, err
+	}
+	if len(result.Images) < 1 {
+		return types.Image{}, false, nil
+	}
+
+	return result.Images[0], true, nil
+}
+
+// Starts baking an AMI from an instance, tagging it (and its snapshots) with name. Without noReboot the instance is
+// rebooted so its file systems are consistent.
+func Create(api EC2ImageAPI, instance inventory.Instance, name string, noReboot bool) (imageId string, err error) {
+	if !validName.MatchString(name) {
+		return "", fmt.Errorf("%q isn't a valid image name, use letters, numbers, dots, dashes and underscores", name)
+	}
+
+	_, taken, err := Find(api, name)
+	if err != nil {
+		return "", err
+	}
+	if taken {
+		return "", fmt.Errorf("there's already an image called %s, delete it with !image delete first", name)
+	}
+
+	tags := []types.Tag{
+		{Key: aws.String("Name"), Value: aws.String(name)},
+		{Key: aws.String(NameTag), Value: aws.String(name)},
+		{Key: aws.String(backup.AliasTag), Value: aws.String(instance.Name())},
+	}
+
+	result, err := api.CreateImage(context.TODO(), &ec2.CreateImageInput{
+		InstanceId:  aws.String(instance.InstanceId),
+		Name:        aws.String(fmt.Sprintf("discord-ec2-manager-%s-%s", name, time.Now().UTC().Format("20060102-150405"))),
+		Description: aws.String(fmt.Sprintf("discord-ec2-manager image %s, baked from %s", name, instance.Name())),
+		NoReboot:    aws.Bool(noReboot),
+		TagSpecifications: []types.TagSpecification{
+			{ResourceType: types.ResourceTypeImage, Tags: tags},
+			{ResourceType: types.ResourceTypeSnapshot, Tags: tags},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("Baking image %s (%s) from %s", name, aws.ToString(result.ImageId), instance.Name())
+	return aws.ToString(result.ImageId), nil
+}
+
+// Waits for a new AMI to become available
+func Wait(api EC2ImageAPI, imageId string) error {
+	return ec2.NewImageAvailableWaiter(api).Wait(context.TODO(), &ec2.DescribeImagesInput{ImageIds: []string{imageId}}, availableWait)
+}
+
+// Deregisters one of the bot's AMIs and deletes the snapshots behind it, returning the deleted snapshots' IDs
+func Delete(api EC2ImageAPI, name string) (snapshotIds []string, err error) {
+	image, found, err := Find(api, name)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("there's no image called %s", name)
+	}
+
+	_, err = api.DeregisterImage(context.TODO(), &ec2.DeregisterImageInput{
+		ImageId: image.ImageId,
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Deregistered image %s (%s)", name, aws.ToString(image.ImageId))
+
+	for _, mapping := range image.BlockDeviceMappings {
+		if mapping.Ebs == nil || mapping.Ebs.SnapshotId == nil {
+			continue
+		}
+
+		_, err = api.DeleteSnapshot(context.TODO(), &ec2.DeleteSnapshotInput{
+			SnapshotId: mapping.Ebs.SnapshotId,
+		})
+		if err != nil {
+			return snapshotIds, err
+		}
+		snapshotIds = append(snapshotIds, aws.ToString(mapping.Ebs.SnapshotId))
+	}
+
+	return snapshotIds, nil
+}
+
+// Describes an image for !images, i.e. `mc-base`: `ami-1234` (available, baked from `mc` on 2023-01-11)
+func Describe(image types.Image) string {
+	created := aws.ToString(image.CreationDate)
+	if len(created) > 10 {
+		created = created[:10]
+	}
+
+	return fmt.Sprintf("`%s`: `%s` (%s, baked from `%s` on %s)", Name(image), aws.ToString(image.ImageId), image.State, tagValue(image.Tags, backup.AliasTag), created)
+}
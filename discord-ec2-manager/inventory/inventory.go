@@ -0,0 +1,279 @@
+package inventory
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/awsclient"
+)
+
+// A single EC2 instance managed by the bot
+type Instance struct {
+	Alias      string `json:"alias,omitempty"`
+	InstanceId string `json:"instance_id"`
+	Region     string `json:"region"`
+
+	// The account from the bot's config the instance lives in, empty for the bot's own credentials
+	Account string `json:"account,omitempty"`
+
+	// The instance type the bot last saw, kept so terminated instances can still be priced
+	InstanceType string `json:"instance_type,omitempty"`
+
+	// State changes the bot has seen, oldest first, used to work out how long the instance has been running
+	Transitions []Transition `json:"transitions,omitempty"`
+}
+
+// A change in an instance's state (running, stopped, terminated) and when it happened
+type Transition struct {
+	State string    `json:"state"`
+	Time  time.Time `json:"time"`
+}
+
+// How long transitions are kept for, long enough to cover last month's costs
+const transitionRetention = 62 * 24 * time.Hour
+
+// Returns the last state the bot recorded for the instance, if any
+func (i Instance) LastTransition() (Transition, bool) {
+	if len(i.Transitions) < 1 {
+		return Transition{}, false
+	}
+
+	return i.Transitions[len(i.Transitions)-1], true
+}
+
+// Returns the account and region the instance lives in
+func (i Instance) Location() awsclient.Location {
+	return awsclient.Location{Account: i.Account, Region: i.Region}
+}
+
+// Returns the instance's alias, or its ID if it doesn't have one
+func (i Instance) Name() string {
+	if i.Alias != "" {
+		return i.Alias
+	}
+
+	return i.InstanceId
+}
+
+// The instances managed by the bot, persisted as JSON so they survive restarts
+type Inventory struct {
+	path string
+	mu   sync.Mutex
+
+	Instances []Instance `json:"instances"`
+
+	// Terminated instances, kept until their transitions are too old to matter for costs
+	Retired []Instance `json:"retired,omitempty"`
+}
+
+// Loads the inventory from path, starting an empty one if the file doesn't exist yet
+func Load(path string) (*Inventory, error) {
+	inv := &Inventory{path: path}
+
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Printf("No inventory found at %s, starting with an empty one", path)
+		return inv, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(content, inv)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Loaded %d instance(s) from %s", len(inv.Instances), path)
+	return inv, nil
+}
+
+// Writes the inventory back to disk, callers must hold inv.mu
+func (inv *Inventory) save() error {
+	content, err := json.MarshalIndent(inv, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(inv.path, content, 0600)
+}
+
+// Adds an instance to the inventory, replacing any existing entry with the same instance ID
+func (inv *Inventory) Add(instance Instance) error {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	for i := range inv.Instances {
+		if inv.Instances[i].InstanceId == instance.InstanceId {
+			inv.Instances[i] = instance
+			return inv.save()
+		}
+	}
+
+	inv.Instances = append(inv.Instances, instance)
+	return inv.save()
+}
+
+// Removes an instance from the inventory, recording it as terminated and keeping it around for cost reports
+func (inv *Inventory) Remove(instanceId string) error {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	for i := range inv.Instances {
+		if inv.Instances[i].InstanceId == instanceId {
+			removed := inv.Instances[i]
+			removed.Transitions = appendTransition(removed.Transitions, "terminated", time.Now())
+
+			inv.Instances = append(inv.Instances[:i], inv.Instances[i+1:]...)
+			inv.Retired = append(inv.Retired, removed)
+			return inv.save()
+		}
+	}
+
+	return nil
+}
+
+// Adds a transition unless the instance is already in that state, dropping transitions too old to matter
+// (except the newest of them, which still says what state the instance was in when the retention window starts)
+func appendTransition(transitions []Transition, state string, at time.Time) []Transition {
+	if len(transitions) > 0 && transitions[len(transitions)-1].State == state {
+		return transitions
+	}
+	transitions = append(transitions, Transition{State: state, Time: at})
+
+	cutoff := at.Add(-transitionRetention)
+	firstKept := 0
+	for firstKept+1 < len(transitions) && transitions[firstKept+1].Time.Before(cutoff) {
+		firstKept++
+	}
+
+	return transitions[firstKept:]
+}
+
+// Records that the instance changed state, the same way RecordTransition does, for use inside Update
+func (i *Instance) AddTransition(state string, at time.Time) {
+	i.Transitions = appendTransition(i.Transitions, state, at)
+}
+
+// Changes an instance in place and saves the inventory, doing nothing if the instance isn't in it
+func (inv *Inventory) Update(instanceId string, change func(instance *Instance)) error {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	for i := range inv.Instances {
+		if inv.Instances[i].InstanceId == instanceId {
+			change(&inv.Instances[i])
+			return inv.save()
+		}
+	}
+
+	return nil
+}
+
+// Records that an instance changed state
+func (inv *Inventory) RecordTransition(instanceId string, state string, at time.Time) error {
+	return inv.Update(instanceId, func(instance *Instance) {
+		instance.AddTransition(state, at)
+	})
+}
+
+// Returns a copy of every retired instance, dropping any whose last transition is too old to matter
+func (inv *Inventory) AllRetired() []Instance {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	cutoff := time.Now().Add(-transitionRetention)
+	var kept []Instance
+	for _, instance := range inv.Retired {
+		last, ok := instance.LastTransition()
+		if ok && last.Time.After(cutoff) {
+			kept = append(kept, instance)
+		}
+	}
+
+	if len(kept) != len(inv.Retired) {
+		inv.Retired = kept
+		err := inv.save()
+		if err != nil {
+			log.Println("Error saving inventory:", err)
+		}
+	}
+
+	return append([]Instance(nil), kept...)
+}
+
+// Finds an instance by alias or instance ID
+func (inv *Inventory) Find(aliasOrId string) (Instance, bool) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	for _, instance := range inv.Instances {
+		if instance.Alias == aliasOrId || instance.InstanceId == aliasOrId {
+			return instance, true
+		}
+	}
+
+	return Instance{}, false
+}
+
+// Returns a copy of every instance in the inventory
+func (inv *Inventory) All() []Instance {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	return append([]Instance(nil), inv.Instances...)
+}
+
+// Returns the instances targeted by a command's -i flags (aliases or instance IDs), or every instance if there are none.
+// Instance IDs the bot doesn't know about are assumed to live in defaultLocation.
+func (inv *Inventory) Select(messageContentSlice []string, defaultLocation awsclient.Location) []Instance {
+	var selected []Instance
+	for i := 1; i < len(messageContentSlice)-1; i++ {
+		if messageContentSlice[i] != "-i" || messageContentSlice[i+1] == "-i" {
+			continue
+		}
+
+		instance, found := inv.Find(messageContentSlice[i+1])
+		if !found {
+			instance = Instance{InstanceId: messageContentSlice[i+1], Region: defaultLocation.Region, Account: defaultLocation.Account}
+		}
+		selected = append(selected, instance)
+	}
+
+	if selected == nil {
+		return inv.All()
+	}
+
+	return selected
+}
+
+// Groups instance IDs by account and region, so each location's EC2 client can be called once
+func ByLocation(instances []Instance) map[awsclient.Location][]string {
+	locations := map[awsclient.Location][]string{}
+	for _, instance := range instances {
+		locations[instance.Location()] = append(locations[instance.Location()], instance.InstanceId)
+	}
+
+	return locations
+}
+
+// Returns the locations in a ByLocation map sorted by account then region, so replies are stable
+func SortedLocations(locations map[awsclient.Location][]string) []awsclient.Location {
+	var sorted []awsclient.Location
+	for location := range locations {
+		sorted = append(sorted, location)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Account != sorted[j].Account {
+			return sorted[i].Account < sorted[j].Account
+		}
+		return sorted[i].Region < sorted[j].Region
+	})
+
+	return sorted
+}
@@ -0,0 +1,51 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// The context key a command's correlation ID is kept under while its AWS calls run
+type correlationKey struct{}
+
+// Tags every AWS call a client makes with a command's correlation ID, so LogAWSCalls can log it. Added to the
+// client's APIOptions.
+func Correlate(correlationId string) func(stack *middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("Correlate", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			return next.HandleInitialize(context.WithValue(ctx, correlationKey{}, correlationId), in)
+		}), middleware.Before)
+	}
+}
+
+// Logs every AWS call a client makes with its request ID, at debug level (or warn if it fails). Added to the bot's AWS
+// config's APIOptions.
+func LogAWSCalls(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("LogAWSCalls", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		started := time.Now()
+		out, metadata, err := next.HandleInitialize(ctx, in)
+
+		requestId, _ := awsmiddleware.GetRequestIDMetadata(metadata)
+		fields := []string{
+			"service", awsmiddleware.GetServiceID(ctx),
+			"operation", awsmiddleware.GetOperationName(ctx),
+			"aws_request_id", requestId,
+			"duration_ms", fmt.Sprint(time.Since(started).Milliseconds()),
+		}
+		if correlationId, ok := ctx.Value(correlationKey{}).(string); ok {
+			fields = append(fields, "correlation_id", correlationId)
+		}
+
+		if err != nil {
+			Warn("AWS call failed", append(fields, "error", err.Error())...)
+		} else {
+			Debug("AWS call", fields...)
+		}
+
+		return out, metadata, err
+	}), middleware.After)
+}
@@ -0,0 +1,260 @@
+package logging
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/botconfig"
+)
+
+// Log levels, lowest first
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// What secrets are replaced with
+const redacted = "[REDACTED]"
+
+// How many one time passwords are remembered for redaction
+const maxSecrets = 20
+
+// Where one time passwords are written when otp_file isn't set. They never go in the log itself.
+const defaultOtpFile = "otp.log"
+
+// Marks a line from a command's logger, see ForCommand
+const correlationPrefix = "correlation_id="
+
+var (
+	mu       sync.Mutex
+	out      io.Writer = os.Stderr
+	format             = "text"
+	minLevel           = levelInfo
+	otpFile            = defaultOtpFile
+
+	// Secrets that never change (the bot token), and ones that come and go (one time passwords)
+	staticSecrets  []string
+	dynamicSecrets []string
+)
+
+// Sends the standard logger (and so every log.Println in the bot) through the configured format and level, with
+// secrets redacted
+func Setup(settings botconfig.Logging, secrets ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if settings.Format != "" {
+		format = settings.Format
+	}
+	for level, name := range levelNames {
+		if settings.Level == name {
+			minLevel = level
+		}
+	}
+	otpFile = settings.OtpFile
+	if otpFile == "" {
+		otpFile = defaultOtpFile
+	}
+
+	for _, secret := range secrets {
+		if secret != "" {
+			staticSecrets = append(staticSecrets, secret)
+		}
+	}
+
+	log.SetFlags(0)
+	log.SetOutput(lineWriter{})
+}
+
+// Adds a secret that comes and goes, forgetting the oldest once there are too many. Callers must hold mu.
+func remember(secret string) {
+	dynamicSecrets = append(dynamicSecrets, secret)
+	if len(dynamicSecrets) > maxSecrets {
+		dynamicSecrets = dynamicSecrets[len(dynamicSecrets)-maxSecrets:]
+	}
+}
+
+// Replaces any secrets in text. Callers must hold mu.
+func redact(text string) string {
+	for _, secrets := range [][]string{staticSecrets, dynamicSecrets} {
+		for _, secret := range secrets {
+			text = replaceToken(text, secret)
+		}
+	}
+
+	return text
+}
+
+// Replaces secret where it appears as a whole token, so a short one time password like 123456 doesn't mangle a longer
+// number (i.e. an instance ID or a timestamp) that happens to contain it
+func replaceToken(text string, secret string) string {
+	var replaced strings.Builder
+	for {
+		i := strings.Index(text, secret)
+		if i < 0 {
+			replaced.WriteString(text)
+			return replaced.String()
+		}
+
+		end := i + len(secret)
+		if (i > 0 && isWordByte(text[i-1])) || (end < len(text) && isWordByte(text[end])) {
+			replaced.WriteString(text[:i+1])
+			text = text[i+1:]
+			continue
+		}
+
+		replaced.WriteString(text[:i] + redacted)
+		text = text[end:]
+	}
+}
+
+func isWordByte(b byte) bool {
+	return b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+// Returns a short random ID to tie together everything one command does
+func NewCorrelationId() string {
+	buffer := make([]byte, 4)
+	_, err := rand.Read(buffer)
+	if err != nil {
+		return fmt.Sprintf("%08x", time.Now().UnixNano()&0xffffffff)
+	}
+
+	return hex.EncodeToString(buffer)
+}
+
+// Returns a logger whose lines carry the command's correlation ID
+func ForCommand(correlationId string) *log.Logger {
+	return log.New(lineWriter{}, correlationPrefix+correlationId+" ", 0)
+}
+
+// Writes a record, if its level is high enough. fields are key, value pairs. Callers must hold mu.
+func write(level int, message string, fields []string) {
+	if level < minLevel {
+		return
+	}
+
+	message = redact(message)
+	for i := range fields {
+		fields[i] = redact(fields[i])
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+
+	var line bytes.Buffer
+	if format == "json" {
+		// Built by hand so the keys stay in a readable order
+		line.WriteString(`{"time":` + quote(now) + `,"level":` + quote(levelNames[level]) + `,"msg":` + quote(message))
+		for i := 0; i+1 < len(fields); i += 2 {
+			line.WriteString("," + quote(fields[i]) + ":" + quote(fields[i+1]))
+		}
+		line.WriteString("}\n")
+	} else {
+		line.WriteString(fmt.Sprintf("%s %-5s %s", now, strings.ToUpper(levelNames[level]), message))
+		for i := 0; i+1 < len(fields); i += 2 {
+			line.WriteString(fmt.Sprintf(" %s=%q", fields[i], fields[i+1]))
+		}
+		line.WriteString("\n")
+	}
+
+	_, err := out.Write(line.Bytes())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing log:", err)
+	}
+}
+
+// Quotes a string for JSON
+func quote(text string) string {
+	quoted, _ := json.Marshal(text)
+	return string(quoted)
+}
+
+// Logs a message with key, value pairs, i.e. logging.Info("Instance started", "instance_id", id)
+func Debug(message string, fields ...string) { logAt(levelDebug, message, fields) }
+func Info(message string, fields ...string)  { logAt(levelInfo, message, fields) }
+func Warn(message string, fields ...string)  { logAt(levelWarn, message, fields) }
+func Error(message string, fields ...string) { logAt(levelError, message, fields) }
+
+func logAt(level int, message string, fields []string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	write(level, message, append([]string{}, fields...))
+}
+
+// Writes a secret someone needs to read (i.e. a one time password) to the otp_file, never the log, then redacts it
+// from everything logged afterwards
+func Secret(message string, secret string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	remember(secret)
+
+	err := appendSecret(message + " " + secret)
+	if err != nil {
+		write(levelError, "Error writing to otp_file: "+err.Error(), nil)
+		return
+	}
+
+	write(levelInfo, message+" (written to otp_file)", []string{"otp_file", otpFile})
+}
+
+// Appends a line to the otp_file, which only the bot's user can read. Callers must hold mu.
+func appendSecret(line string) error {
+	file, err := os.OpenFile(otpFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "%s %s\n", time.Now().UTC().Format(time.RFC3339), line)
+	return err
+}
+
+// Works out the level of a plain log.Println line from how it starts, i.e. "Error sending message: ..."
+func inferLevel(message string) int {
+	lower := strings.ToLower(message)
+	switch {
+	case strings.HasPrefix(lower, "error"):
+		return levelError
+	case strings.HasPrefix(lower, "warning"):
+		return levelWarn
+	}
+
+	return levelInfo
+}
+
+// Turns lines from the standard logger (or a command's logger) into records
+type lineWriter struct{}
+
+func (lineWriter) Write(p []byte) (int, error) {
+	message := strings.TrimSuffix(string(p), "\n")
+
+	var fields []string
+	if strings.HasPrefix(message, correlationPrefix) {
+		parts := strings.SplitN(strings.TrimPrefix(message, correlationPrefix), " ", 2)
+		fields = []string{"correlation_id", parts[0]}
+		message = ""
+		if len(parts) > 1 {
+			message = parts[1]
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	write(inferLevel(message), message, fields)
+	return len(p), nil
+}
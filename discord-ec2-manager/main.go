@@ -1,30 +1,60 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/bwmarrin/discordgo"
 
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/api"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/audit"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/awsclient"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/backup"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/botconfig"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/budget"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/console"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/cost"
 	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/create"
-	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/start"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/ddns"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/eip"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/firewall"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/images"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/inventory"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/logging"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/metrics"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/reboot"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/resize"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/restore"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/run"
 	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/status"
-	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/stop"
 	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/terminate"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/watch"
 )
 
 // Used alongside GenerateOTP to create a 6 digit psuedorandom OTP to block !create / !terminate from being used by non-admins
 const otpChars = "1234567890"
 
+// The longest !run output posted as a code block, longer output is attached as a file (Discord messages are limited to
+// 2,000 characters)
+const runOutputLimit = 1800
+
 // Used to accept CLI Parameters
 var (
 
@@ -37,7 +67,8 @@ var (
 	UserSecurityGroupId string
 	UserAmiId           string
 	UserSubnetId        string
-	UserPathToScript    string
+	UserDataTemplate    string
+	UserDataDir         string
 	UserTagKey          string
 	UserTagValue        string
 	UserKeyName         string
@@ -60,38 +91,62 @@ var (
 	// Run Instances Input for Key Pair Name Check
 	runInstancesInput *ec2.RunInstancesInput
 
-	// One Time Password
-	oneTimePassword string
+	// The !create / !restore / !terminate messages waiting on a one time password, by the channel they were sent in, so
+	// one channel's confirmation can't replace (or confirm) another's
+	pendingOtps   = map[string]pendingOtp{}
+	pendingOtpsMu sync.Mutex
 
-	// Status Message
-	statusMessage string
+	// Flags accepted by !create
+	createFlagArray = []string{"-sn", "-sg", "-ami", "-tk", "-tv", "-u", "-svc", "-sp", "-scp", "-ia", "-in", "-k", "-it", "-alias", "--var"}
 
-	// Instance ID
-	instanceIds []string
+	// Instances managed by the bot, and where they're saved
+	inv           *inventory.Inventory
+	InventoryPath string
 
-	// SG IDs
-	SecurityGroupIds []string
+	// One AWS client per account and region
+	clients    *awsclient.Pool
+	UserRegion string
+
+	// Settings from the bot's config file (AWS accounts, etc.)
+	botCfg     *botconfig.Config
+	ConfigPath string
+
+	// Hourly instance prices for !cost
+	prices cost.PriceTable
+
+	// Publishes each instance's hostname when it starts, nil unless dynamic DNS is configured
+	dnsProvider ddns.Provider
+
+	// Hands out !allow's me-links, nil unless the bot's HTTP server has a public URL
+	meLinks *firewall.MeLinks
 
-	// Used to keep track of recent messages in the bot's discord channel
-	previousDiscordMessages []string
+	// Records every action taken through the bot, for !audit
+	auditLog *audit.Log
 
-	validUserSubnetId bool
+	// SG IDs
+	SecurityGroupIds []string
 )
 
 // Initializes the Discord Part of the App for DiscordGo module
 func init() {
 	// Discord Bot stuff if you have an existing EC2 instance
 	flag.StringVar(&Token, "t", "", "Your Bot's Token (required).")
-	flag.StringVar(&ChannelId, "c", "", "Your Discord Channel ID that you want messages to post in (required).")
+	flag.StringVar(&ChannelId, "c", "", "Your Discord Channel ID that you want messages to post in (required). More channels can be added to the config file.")
 
 	// Optional, but needed for !start, !stop and !status unless you're using !create to build a new EC2 instance
 	flag.StringVar(&UserInstanceId, "i", "", "The EC2 Instance ID you want to control via !status, !start, and !stop via your Discord server (optional).")
 
+	// Where the bot keeps track of the instances it manages, and which region to use when one isn't given
+	flag.StringVar(&InventoryPath, "inv", "inventory.json", "The path to the file the bot saves its inventory of managed instances to (optional).")
+	flag.StringVar(&ConfigPath, "cfg", "", "The path to the bot's JSON config file, used for settings like AWS accounts that don't fit in a flag (optional).")
+	flag.StringVar(&UserRegion, "r", "", "The AWS region used when a command or instance doesn't specify one. Defaults to the region from your AWS config (optional).")
+
 	// Stuff for !create
 	flag.StringVar(&UserSecurityGroupId, "sg", "", "The Security Group ID you want to attach to your EC2 instance on !create commands (optional).")
-	flag.StringVar(&UserAmiId, "a", "", "The AMI ID you want to launch your EC2 instance with on !create (optional).")
+	flag.StringVar(&UserAmiId, "a", "", "The AMI you want to launch your EC2 instance with on !create. Accepts an AMI ID, an alias (i.e. ubuntu-22.04, al2023), an owner:name-pattern pair, or an SSM parameter path. Defaults to ubuntu-22.04 (optional).")
 	flag.StringVar(&UserSubnetId, "sn", "", "The Subnet ID you want to launch your EC2 instance with on !create (required if using !create).")
-	flag.StringVar(&UserPathToScript, "u", "", "The absolute path to your userdata.sh script (optional).")
+	flag.StringVar(&UserDataTemplate, "u", "", "The name of the user data template (in the -ud directory) to render for !create. Separate several names with commas to combine them into a cloud-init multipart archive (optional).")
+	flag.StringVar(&UserDataDir, "ud", "", "The directory holding your user data templates. !create can only use templates from this directory (optional).")
 	flag.StringVar(&UserTagKey, "tk", "Name", "The key of the tag you'd like to assign your EC2 instance (optional).")
 	flag.StringVar(&UserTagValue, "tv", "Created by Discord", "The value of the tag you'd like to assign your EC2 instance (optional).")
 	flag.StringVar(&UserKeyName, "k", "", "The name of the key pair you'd like to assign to your EC2 instance for remote access (optional).")
@@ -110,6 +165,446 @@ func init() {
 	flag.IntVar(&OTPLength, "o", 6, "The length of the OTP you'd like to generate (optional).")
 
 	flag.Parse()
+
+	// Makes the start up flags the defaults for !create
+	create.UserSecurityGroupId = UserSecurityGroupId
+	create.UserAmiId = UserAmiId
+	create.UserSubnetId = UserSubnetId
+	create.UserDataTemplates = UserDataTemplate
+	create.UserDataDir = UserDataDir
+	create.ChannelId = ChannelId
+	create.UserTagKey = UserTagKey
+	create.UserTagValue = UserTagValue
+	create.UserKeyName = UserKeyName
+	create.UserInstanceType = UserInstanceType
+	create.UserIamArn = UserIamArn
+	create.UserIamProfileName = UserIamProfileName
+	create.UserServiceName = UserServiceName
+	create.UserServicePort = UserServicePort
+	create.ServiceCheckPort = ServiceCheckPort
+	create.SaveDefaults()
+}
+
+// Removes a boolean flag (i.e. --dry-run) from a message, reporting whether it was there
+func popFlag(messageContentSlice []string, flagName string) ([]string, bool) {
+	var remaining []string
+	found := false
+	for _, field := range messageContentSlice {
+		if field == flagName {
+			found = true
+			continue
+		}
+		remaining = append(remaining, field)
+	}
+
+	return remaining, found
+}
+
+// Removes a flag and its value (i.e. --region us-west-2) from a message, returning the value
+func popFlagValue(messageContentSlice []string, flagName string) ([]string, string) {
+	var remaining []string
+	value := ""
+	for i := 0; i < len(messageContentSlice); i++ {
+		if messageContentSlice[i] == flagName && i+1 < len(messageContentSlice) {
+			value = messageContentSlice[i+1]
+			i++
+			continue
+		}
+		remaining = append(remaining, messageContentSlice[i])
+	}
+
+	return remaining, value
+}
+
+// Runs a command against the targeted instances one account and region at a time, joining each location's reply
+func forEachLocation(targets []inventory.Instance, run func(location awsclient.Location, instanceIds []string) string) string {
+	locations := inventory.ByLocation(targets)
+
+	var messages []string
+	for _, location := range inventory.SortedLocations(locations) {
+		messages = append(messages, run(location, locations[location]))
+	}
+
+	return strings.Join(messages, "\n\n")
+}
+
+// Reads the --account and --region flags from a !create message, falling back to the defaults
+func createLocation(messageContentSlice []string) ([]string, awsclient.Location, error) {
+	messageContentSlice, account := popFlagValue(messageContentSlice, "--account")
+	messageContentSlice, region := popFlagValue(messageContentSlice, "--region")
+
+	err := clients.ValidAccount(account)
+	if err != nil {
+		return messageContentSlice, awsclient.Location{}, err
+	}
+
+	return messageContentSlice, clients.Resolve(awsclient.Location{Account: account, Region: region}), nil
+}
+
+// Records that the bot changed the state of some instances, for !cost
+func recordTransitions(instanceIds []string, state string) {
+	for _, instanceId := range instanceIds {
+		err := inv.RecordTransition(instanceId, state, time.Now())
+		if err != nil {
+			log.Println("Error saving inventory:", err)
+		}
+	}
+}
+
+// Checks the budgets covering targets, returning a refusal unless they still have room or an admin has overridden them
+func budgetRefusal(targets []inventory.Instance, override bool, c caller) string {
+	refusal := budget.Refusal(botCfg.Budget, inv, prices, targets)
+	if refusal == "" {
+		return ""
+	}
+
+	if override && c.Admin {
+		log.Printf("Budget overridden by admin %s", c.Name)
+		return ""
+	}
+
+	if override {
+		refusal += "\nOnly the bot's admins can override a budget."
+	}
+
+	return refusal
+}
+
+// Adds an instance the bot just created to the inventory
+func addCreatedInstance(instanceId string, location awsclient.Location) {
+	if instanceId == "" {
+		return
+	}
+
+	err := inv.Add(inventory.Instance{
+		Alias:        create.UserAlias,
+		InstanceId:   instanceId,
+		Region:       location.Region,
+		Account:      location.Account,
+		InstanceType: create.UserInstanceType,
+		Transitions: []inventory.Transition{
+			{State: "running", Time: time.Now()},
+		},
+	})
+	if err != nil {
+		log.Println("Error saving inventory:", err)
+	}
+}
+
+// Works out what a !restore message will do. With --new, the instance can be one that's since been terminated, and
+// the !create flags left over are returned for launching the new instance.
+func (c *command) planRestore(messageContentSlice []string) ([]string, restore.Plan, error) {
+	messageContentSlice, snapshot := popFlagValue(messageContentSlice, "--snapshot")
+	messageContentSlice, launchNew := popFlag(messageContentSlice, "--new")
+	messageContentSlice, architecture := popFlagValue(messageContentSlice, "--arch")
+	if snapshot == "" {
+		snapshot = "latest"
+	}
+
+	if !launchNew {
+		instance, err := c.namedInstance(messageContentSlice)
+		if err != nil {
+			return nil, restore.Plan{}, err
+		}
+
+		plan, err := restore.PlanSwap(c.clients.EC2(instance.Location()), instance, snapshot)
+		return nil, plan, err
+	}
+
+	instance, err := c.namedInstance(messageContentSlice)
+	if err != nil && (len(messageContentSlice) < 2 || strings.HasPrefix(messageContentSlice[1], "-")) {
+		return nil, restore.Plan{}, err
+	}
+	if err != nil && !c.channel.Manages(messageContentSlice[1]) {
+		return nil, restore.Plan{}, fmt.Errorf("`%s` can't be managed from this channel.", messageContentSlice[1])
+	}
+	if err != nil {
+		instance = inventory.Instance{Alias: messageContentSlice[1], Region: defaultLocation().Region, Account: defaultLocation().Account}
+		for _, retired := range inv.AllRetired() {
+			if retired.Alias == messageContentSlice[1] || retired.InstanceId == messageContentSlice[1] {
+				instance = retired
+			}
+		}
+	}
+
+	// Everything after the instance's name is passed on to !create
+	createFlags := messageContentSlice[1:]
+	if len(createFlags) > 0 && !strings.HasPrefix(createFlags[0], "-") {
+		createFlags = createFlags[1:]
+	}
+	createSlice := append([]string{"!create"}, createFlags...)
+	if _, alias := popFlagValue(createSlice, "-alias"); alias != "" {
+		if _, taken := inv.Find(alias); taken {
+			return nil, restore.Plan{}, fmt.Errorf("the alias `%s` is already used by another instance", alias)
+		}
+	}
+
+	// Checked before any AMI is registered, so a refused restore doesn't leave one behind
+	if refusal := c.createRefusal(createSlice); refusal != "" {
+		return nil, restore.Plan{}, errors.New(refusal)
+	}
+
+	plan, err := restore.PlanLaunch(c.clients.EC2(instance.Location()), instance, snapshot, architecture)
+	return createSlice, plan, err
+}
+
+// Builds the !create message a !restore --new launches its instance with, from the AMI registered in location
+func restoreCreateContent(createSlice []string, location awsclient.Location, imageId string) string {
+	createSlice, _ = popFlag(createSlice, "--dry-run")
+	createSlice, _ = popFlagValue(createSlice, "--region")
+	createSlice, _ = popFlagValue(createSlice, "--account")
+	createSlice, _ = popFlagValue(createSlice, "-ami")
+	createSlice, _ = popFlagValue(createSlice, "--ami-alias")
+
+	createSlice = append(createSlice, "--region", location.Region, "-ami", imageId)
+	if location.Account != "" {
+		createSlice = append(createSlice, "--account", location.Account)
+	}
+
+	return strings.Join(createSlice, " ")
+}
+
+// Runs terminate's safety checks against the targets one account and region at a time
+func checkTermination(targets []inventory.Instance) (summary string, refusals []string, err error) {
+	locations := inventory.ByLocation(targets)
+
+	var summaries []string
+	for _, location := range inventory.SortedLocations(locations) {
+		var instances []inventory.Instance
+		for _, target := range targets {
+			if target.Location() == location {
+				instances = append(instances, target)
+			}
+		}
+
+		locationSummary, locationRefusals, err := terminate.CheckEc2Instances(clients.EC2(location), instances)
+		if err != nil {
+			return "", nil, err
+		}
+		summaries = append(summaries, locationSummary)
+		refusals = append(refusals, locationRefusals...)
+	}
+
+	return strings.Join(summaries, "\n"), refusals, nil
+}
+
+// Backs up every volume of the targets and waits for the snapshots to complete
+func takeFinalBackups(targets []inventory.Instance) error {
+	var started []backup.Backup
+	for _, target := range targets {
+		b, err := backup.Create(clients.EC2(target.Location()), target, false)
+		if err != nil {
+			return err
+		}
+		started = append(started, b)
+	}
+
+	for i, b := range started {
+		_, err := backup.Wait(clients.EC2(targets[i].Location()), b, func(backup.Backup) {})
+		if err != nil {
+			return err
+		}
+		log.Printf("Final backup %s of %s completed", b.Id, b.Alias)
+	}
+
+	return nil
+}
+
+// Swaps a !create message's --ami-alias for the ID of the image the bot baked with that name
+func resolveAmiAlias(messageContentSlice []string, location awsclient.Location) ([]string, error) {
+	messageContentSlice, name := popFlagValue(messageContentSlice, "--ami-alias")
+	if name == "" {
+		return messageContentSlice, nil
+	}
+
+	image, found, err := images.Find(clients.EC2(location), name)
+	if err != nil {
+		return messageContentSlice, err
+	}
+	if !found {
+		return messageContentSlice, fmt.Errorf("there's no image called `%s` in `%s`, see `!images`", name, location)
+	}
+
+	return append(messageContentSlice, "-ami", aws.ToString(image.ImageId)), nil
+}
+
+// Releases the Elastic IPs the bot allocated for a terminated instance so they don't keep costing money, describing
+// what was released. Addresses allocated by hand are only disassociated.
+func releaseElasticIps(instanceId string, location awsclient.Location) string {
+	instance, found := inv.Find(instanceId)
+	if !found {
+		instance = inventory.Instance{InstanceId: instanceId}
+	}
+
+	released, disassociated, err := eip.ReleaseFor(clients.EC2(location), instance)
+	if err != nil {
+		log.Printf("Error releasing Elastic IPs of %s: %v", instanceId, err)
+		return fmt.Sprintf("\n:warning: There was an error releasing `%s`'s Elastic IP, release it with `!eip release` so it doesn't keep costing money.", instance.Name())
+	}
+
+	message := ""
+	if len(released) > 0 {
+		message += fmt.Sprintf("\nReleased `%s`'s Elastic IP `%s`.", instance.Name(), strings.Join(released, "`, `"))
+	}
+	if len(disassociated) > 0 {
+		message += fmt.Sprintf("\n:warning: Disassociated `%s` from `%s` but kept it, since the bot didn't allocate it. It still costs money until you release it in the AWS console.", strings.Join(disassociated, "`, `"), instance.Name())
+	}
+
+	return message
+}
+
+// Once the instance is running, points its hostname at its new public IP and lets the channel know where to find it.
+// Does nothing unless dynamic DNS is configured and the instance has an alias.
+func announceRunning(s *discordgo.Session, channelId string, instanceId string) {
+	instance, found := inv.Find(instanceId)
+	if dnsProvider == nil || !found || ddns.Hostname(botCfg.DNS, instance) == "" {
+		return
+	}
+
+	go func() {
+		hostname, ip, err := ddns.Publish(dnsProvider, botCfg.DNS, clients.EC2(instance.Location()), instance)
+		message := fmt.Sprintf(":white_check_mark: `%s` is running at `%s` (`%s`)", instance.Name(), hostname, ip)
+		if err != nil {
+			log.Printf("Error publishing the hostname of %s: %v", instance.Name(), err)
+			message = fmt.Sprintf("**ERROR**: There was an error pointing `%s` at `%s`'s new IP address. Please see your bot's error logs for more information.", hostname, instance.Name())
+		}
+
+		_, err = s.ChannelMessageSend(channelId, message)
+		if err != nil {
+			log.Println("Error sending message:", err)
+		}
+	}()
+}
+
+// Removes the hostnames of instances the bot stopped or terminated, so they don't point at IP addresses the instances
+// no longer have. Errors are only logged, as the instances have already been stopped.
+func withdrawHostnames(instanceIds []string) {
+	for _, instanceId := range instanceIds {
+		instance, found := inv.Find(instanceId)
+		if !found {
+			continue
+		}
+
+		_, err := ddns.Unpublish(dnsProvider, botCfg.DNS, instance)
+		if err != nil {
+			log.Printf("Error removing the hostname of %s: %v", instance.Name(), err)
+		}
+	}
+}
+
+// Reports whether EC2 says an instance is running right now
+func isRunning(api status.EC2InstanceAPI, instanceId string) bool {
+	output, err := status.GetInstances(context.TODO(), api, &ec2.DescribeInstancesInput{InstanceIds: []string{instanceId}})
+	if err != nil {
+		log.Printf("Error describing %s: %v", instanceId, err)
+		return false
+	}
+
+	for _, r := range output.Reservations {
+		for _, i := range r.Instances {
+			if i.State != nil && i.State.Name == types.InstanceStateNameRunning {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// The port !allow opens, from the config file or the -sp service port
+func allowPort() (firewall.Port, error) {
+	port := firewall.Port{Number: botCfg.Allowlist.Port, Protocol: botCfg.Allowlist.Protocol}
+	if port.Protocol == "" {
+		port.Protocol = "tcp"
+	}
+
+	if port.Number == 0 && UserServicePort != "" {
+		number, err := strconv.ParseInt(UserServicePort, 10, 32)
+		if err != nil {
+			return port, fmt.Errorf("the service port `%s` isn't a number", UserServicePort)
+		}
+		port.Number = int32(number)
+	}
+	if port.Number == 0 {
+		return port, fmt.Errorf("no port to open, set `allowlist.port` in the bot's config file or start the bot with `-sp`")
+	}
+
+	return port, nil
+}
+
+// Opens the instance's port to an IP address, describing the result
+func allowIp(instance inventory.Instance, ip string, requestedBy string, expires time.Time) string {
+	port, err := allowPort()
+	if err != nil {
+		return fmt.Sprintf(":x: Can't allow `%s`: %s", ip, err)
+	}
+
+	rule, err := firewall.Allow(clients.EC2(instance.Location()), instance, port, ip, requestedBy, expires)
+	if err != nil {
+		log.Printf("Error allowing %s to %s: %v", ip, instance.Name(), err)
+		return fmt.Sprintf("**ERROR**: There was an error allowing `%s` to connect to `%s`. Please see your bot's error logs for more information.", ip, instance.Name())
+	}
+
+	return fmt.Sprintf(":unlock: %s can now connect to `%s` on port `%d/%s`.", rule, instance.Name(), port.Number, port.Protocol)
+}
+
+// Serves the bot's HTTP endpoints (!allow's me-links, the REST API and /metrics), if the config file sets a listen address
+func startHttpServer(dg *discordgo.Session) {
+	if botCfg.HTTP.Listen == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	if botCfg.HTTP.PublicUrl != "" {
+		meLinks = firewall.NewMeLinks(botCfg.HTTP.PublicUrl, botCfg.HTTP.TrustProxy, func(request firewall.MeRequest, ip string) string {
+			message := allowIp(request.Instance, ip, request.RequestedBy, request.Expires)
+			postAbout(dg, message, request.Instance)
+
+			if strings.HasPrefix(message, ":unlock:") {
+				return fmt.Sprintf("Done! %s can now connect to %s.", ip, request.Instance.Name())
+			}
+			return "Sorry, your IP address couldn't be allowed. Check Discord for more information."
+		})
+		mux.Handle("/me/", meLinks)
+	}
+	if len(botCfg.API.Keys) > 0 {
+		mux.Handle("/api/", api.NewServer(botCfg.API.Keys, apiOperations{s: dg}))
+	}
+	if botCfg.HTTP.Metrics {
+		mux.Handle("/metrics", metrics.Handler())
+	}
+
+	go func() {
+		log.Println("HTTP server listening on", botCfg.HTTP.Listen)
+		err := http.ListenAndServe(botCfg.HTTP.Listen, mux)
+		log.Println("HTTP server stopped:", err)
+	}()
+}
+
+// Reads !audit's --since, i.e. 24h or 7d. Empty means the last day.
+func parseSince(value string) (time.Duration, error) {
+	if value == "" {
+		return 24 * time.Hour, nil
+	}
+
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil || days < 1 {
+			return 0, fmt.Errorf("invalid number of days %q", value)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	since, err := time.ParseDuration(value)
+	if err == nil && since <= 0 {
+		err = fmt.Errorf("--since must be positive")
+	}
+	return since, err
+}
+
+// The location unknown instance IDs are assumed to live in
+func defaultLocation() awsclient.Location {
+	return clients.Resolve(awsclient.Location{})
 }
 
 // Generates a one time password
@@ -125,11 +620,59 @@ func GenerateOTP(length int) (string, error) {
 		buffer[i] = otpChars[int(buffer[i])%otpCharsLength]
 	}
 
-	oneTimePassword = string(buffer)
+	return string(buffer), nil
+}
 
-	log.Println("Your One Time Password:", oneTimePassword)
+// A message waiting on its one time password
+type pendingOtp struct {
+	password string
+	command  string
 
-	return string(buffer), nil
+	// For !terminate, the instances its summary listed, which are the only ones it terminates
+	instanceIds   []string
+	finalSnapshot bool
+}
+
+// Generates a one time password for a !create / !restore / !terminate message, replacing any the channel was already
+// waiting on
+func awaitOtp(channelId string, pending pendingOtp) {
+	password, err := GenerateOTP(OTPLength)
+	if err != nil {
+		log.Println("Error generating one time password:", err)
+		return
+	}
+	pending.password = password
+
+	pendingOtpsMu.Lock()
+	pendingOtps[channelId] = pending
+	pendingOtpsMu.Unlock()
+
+	logging.Secret(fmt.Sprintf("Your One Time Password for channel %s:", channelId), password)
+}
+
+// Returns the message waiting on the one time password in the channel, if content is that password
+func otpCommand(channelId string, content string) (string, bool) {
+	pendingOtpsMu.Lock()
+	defer pendingOtpsMu.Unlock()
+
+	pending, found := pendingOtps[channelId]
+	if !found || content != pending.password {
+		return "", false
+	}
+	return pending.command, true
+}
+
+// Like otpCommand, but uses up the password so it can only confirm the message once
+func takeOtp(channelId string, content string) (pendingOtp, bool) {
+	pendingOtpsMu.Lock()
+	defer pendingOtpsMu.Unlock()
+
+	pending, found := pendingOtps[channelId]
+	if !found || content != pending.password {
+		return pendingOtp{}, false
+	}
+	delete(pendingOtps, channelId)
+	return pending, true
 }
 
 // Listens for new messages to get the command issued by users in the Discord Channel (i.e. !start, !stop, etc.)
@@ -140,126 +683,889 @@ func messageCreated(s *discordgo.Session, m *discordgo.MessageCreate) {
 		return
 	}
 
-	cfg, err := config.LoadDefaultConfig(context.TODO())
-	if err != nil {
-		log.Println("Error loading config:", err)
+	// Ignores channels the bot hasn't been set up to take commands in
+	if _, ok := channelConfig(m.ChannelID); !ok {
+		return
 	}
 
-	client := ec2.NewFromConfig(cfg)
+	cmd := newCommand(s, m)
+	defer cmd.finish()
+
+	if name := cmd.refusedCommand(m.Content); name != "" {
+		_, err := cmd.send(fmt.Sprintf(":no_entry: `%s` can't be used in this channel.", name))
+		if err != nil {
+			cmd.log.Println("Error sending message:", err)
+		}
+		return
+	}
 
 	switch m.Content {
 	case "!help":
 		if UserServiceName != "" && UserServicePort != "" {
-			helpMessage := fmt.Sprintf("**`!create`** -- Creates a brand new EC2 instances\n**`!status`** -- Checks the status of the EC2 instance, checks for public IP address\n**`!start`** -- Starts your EC2 instance\n**`!stop`** -- Stops your EC2 instance\n**`!terminate`** -- Terminates (deletes) your EC2 instance\n**`!help`** -- Displays commands and what they do :smile:\n\n`%s` is running on port `%s`", UserServiceName, UserServicePort)
-			_, err := s.ChannelMessageSend(ChannelId, helpMessage)
+			helpMessage := fmt.Sprintf("**`!create`** -- Creates a brand new EC2 instances (add `--dry-run` to only run the pre-flight checks)\n**`!status`** -- Checks the status of the EC2 instance, checks for public IP address\n**`!start`** -- Starts your EC2 instance\n**`!stop`** -- Stops your EC2 instance (add `--hibernate` to hibernate it)\n**`!terminate`** -- Terminates (deletes) your EC2 instance (add `--final-snapshot` to back it up first)\n**`!cost`** -- Estimates what your EC2 instances have cost today, this week and this month\n**`!backup`** -- Snapshots an instance's volumes, i.e. `!backup mc`\n**`!restore`** -- Restores an instance from a backup, i.e. `!restore mc --snapshot latest`\n**`!image`** -- Bakes an AMI from an instance, i.e. `!image mc --name mc-base`\n**`!images`** -- Lists the AMIs the bot has baked\n**`!eip`** -- Lists, attaches (`!eip attach mc`) and releases (`!eip release mc`) Elastic IPs\n**`!allow`** -- Lets an IP address connect to an instance, i.e. `!allow mc 203.0.113.7` or `!allow mc me`\n**`!deny`** -- Removes an IP address added by `!allow`, i.e. `!deny mc 203.0.113.7`\n**`!reboot`** -- Reboots an instance, i.e. `!reboot mc`\n**`!resize`** -- Changes an instance's type, stopping and starting it if it's running, i.e. `!resize mc t3.large`\n**`!console`** -- Attaches the end of an instance's console output, i.e. `!console mc`\n**`!screenshot`** -- Attaches a screenshot of an instance's console, i.e. `!screenshot mc`\n**`!run`** -- Runs a pre-approved command on an instance, i.e. `!run mc restart` (`!run` on its own lists them)\n**`!audit`** -- Shows who did what through the bot, i.e. `!audit mc --since 7d`\n**`!help`** -- Displays commands and what they do :smile:\n\n`%s` is running on port `%s`", UserServiceName, UserServicePort)
+			_, err := cmd.send(helpMessage)
 			if err != nil {
-				log.Println("Error sending message:", err)
+				cmd.log.Println("Error sending message:", err)
 			}
 		} else if UserServiceName != "" && UserServicePort == "" {
-			helpMessage := fmt.Sprintf("**`!create`** -- Creates a brand new EC2 instances\n**`!status`** -- Checks the status of the EC2 instance, checks for public IP address\n**`!start`** -- Starts your EC2 instance\n**`!stop`** -- Stops your EC2 instance\n**`!terminate`** -- Terminates (deletes) your EC2 instance\n**`!help`** -- Displays commands and what they do :smile:\n\nYour EC2 instance is running `%s`.", UserServiceName)
-			_, err := s.ChannelMessageSend(ChannelId, helpMessage)
+			helpMessage := fmt.Sprintf("**`!create`** -- Creates a brand new EC2 instances (add `--dry-run` to only run the pre-flight checks)\n**`!status`** -- Checks the status of the EC2 instance, checks for public IP address\n**`!start`** -- Starts your EC2 instance\n**`!stop`** -- Stops your EC2 instance (add `--hibernate` to hibernate it)\n**`!terminate`** -- Terminates (deletes) your EC2 instance (add `--final-snapshot` to back it up first)\n**`!cost`** -- Estimates what your EC2 instances have cost today, this week and this month\n**`!backup`** -- Snapshots an instance's volumes, i.e. `!backup mc`\n**`!restore`** -- Restores an instance from a backup, i.e. `!restore mc --snapshot latest`\n**`!image`** -- Bakes an AMI from an instance, i.e. `!image mc --name mc-base`\n**`!images`** -- Lists the AMIs the bot has baked\n**`!eip`** -- Lists, attaches (`!eip attach mc`) and releases (`!eip release mc`) Elastic IPs\n**`!allow`** -- Lets an IP address connect to an instance, i.e. `!allow mc 203.0.113.7` or `!allow mc me`\n**`!deny`** -- Removes an IP address added by `!allow`, i.e. `!deny mc 203.0.113.7`\n**`!reboot`** -- Reboots an instance, i.e. `!reboot mc`\n**`!resize`** -- Changes an instance's type, stopping and starting it if it's running, i.e. `!resize mc t3.large`\n**`!console`** -- Attaches the end of an instance's console output, i.e. `!console mc`\n**`!screenshot`** -- Attaches a screenshot of an instance's console, i.e. `!screenshot mc`\n**`!run`** -- Runs a pre-approved command on an instance, i.e. `!run mc restart` (`!run` on its own lists them)\n**`!audit`** -- Shows who did what through the bot, i.e. `!audit mc --since 7d`\n**`!help`** -- Displays commands and what they do :smile:\n\nYour EC2 instance is running `%s`.", UserServiceName)
+			_, err := cmd.send(helpMessage)
 			if err != nil {
-				log.Println("Error sending message:", err)
+				cmd.log.Println("Error sending message:", err)
 			}
 		} else {
-			helpMessage := fmt.Sprintf("**`!create`** -- Creates a brand new EC2 instances\n**`!status`** -- Checks the status of the EC2 instance, checks for public IP address\n**`!start`** -- Starts your EC2 instance\n**`!stop`** -- Stops your EC2 instance\n**`!terminate`** -- Terminates (deletes) your EC2 instance\n**`!help`** -- Displays commands and what they do :smile:")
-			_, err := s.ChannelMessageSend(ChannelId, helpMessage)
+			helpMessage := fmt.Sprintf("**`!create`** -- Creates a brand new EC2 instances (add `--dry-run` to only run the pre-flight checks)\n**`!status`** -- Checks the status of the EC2 instance, checks for public IP address\n**`!start`** -- Starts your EC2 instance\n**`!stop`** -- Stops your EC2 instance (add `--hibernate` to hibernate it)\n**`!terminate`** -- Terminates (deletes) your EC2 instance (add `--final-snapshot` to back it up first)\n**`!cost`** -- Estimates what your EC2 instances have cost today, this week and this month\n**`!backup`** -- Snapshots an instance's volumes, i.e. `!backup mc`\n**`!restore`** -- Restores an instance from a backup, i.e. `!restore mc --snapshot latest`\n**`!image`** -- Bakes an AMI from an instance, i.e. `!image mc --name mc-base`\n**`!images`** -- Lists the AMIs the bot has baked\n**`!eip`** -- Lists, attaches (`!eip attach mc`) and releases (`!eip release mc`) Elastic IPs\n**`!allow`** -- Lets an IP address connect to an instance, i.e. `!allow mc 203.0.113.7` or `!allow mc me`\n**`!deny`** -- Removes an IP address added by `!allow`, i.e. `!deny mc 203.0.113.7`\n**`!reboot`** -- Reboots an instance, i.e. `!reboot mc`\n**`!resize`** -- Changes an instance's type, stopping and starting it if it's running, i.e. `!resize mc t3.large`\n**`!console`** -- Attaches the end of an instance's console output, i.e. `!console mc`\n**`!screenshot`** -- Attaches a screenshot of an instance's console, i.e. `!screenshot mc`\n**`!run`** -- Runs a pre-approved command on an instance, i.e. `!run mc restart` (`!run` on its own lists them)\n**`!audit`** -- Shows who did what through the bot, i.e. `!audit mc --since 7d`\n**`!help`** -- Displays commands and what they do :smile:")
+			_, err := cmd.send(helpMessage)
 			if err != nil {
-				log.Println("Error sending message:", err)
+				cmd.log.Println("Error sending message:", err)
 			}
 		}
 	default:
-		// Clears out previous message array
-		previousDiscordMessages = nil
+		// Handlers run concurrently, so everything about this message stays local to it
+		content := m.Content
+		var statusMessage string
+		var err error
 
-		log.Println("Getting last 3 messages...")
-		previousDiscordMessagesStructs, err := s.ChannelMessages(ChannelId, 3, "", "", "")
-		if err != nil {
-			log.Println("Error getting previous messages:", err)
+		if strings.Contains(content, "!status") {
+			statusMessage = cmd.instanceStatuses(cmd.selectInstances(strings.Fields(content)))
+
+			_, err = cmd.send(statusMessage)
+			if err != nil {
+				cmd.log.Println("Error sending message:", err)
+				return
+			}
 		}
 
-		if len(previousDiscordMessagesStructs) > 0 {
-			for i := 0; i < len(previousDiscordMessagesStructs); i++ {
-				previousDiscordMessages = append(previousDiscordMessages, previousDiscordMessagesStructs[i].Content)
+		if strings.Contains(content, "!start") {
+			messageContentSlice, overrideBudget := popFlag(strings.Fields(content), "--override-budget")
+
+			statusMessage, _ = cmd.startInstances(cmd.selectInstances(messageContentSlice), overrideBudget)
+			_, err = cmd.send(statusMessage)
+			if err != nil {
+				cmd.log.Println("Error sending message:", err)
 			}
 		}
 
-		if strings.Contains(previousDiscordMessages[0], "!status") {
-			messageContentSlice := strings.Fields(previousDiscordMessages[0])
+		if strings.Contains(content, "!stop") {
+			messageContentSlice, hibernate := popFlag(strings.Fields(content), "--hibernate")
 
-			statusMessage = status.GetEc2InstanceStatus(messageContentSlice, instanceIds, UserTagKey, UserTagValue, ServiceCheckPort, UserServiceName, UserServicePort, client)
-			_, err = s.ChannelMessageSend(ChannelId, statusMessage)
+			statusMessage, _ = cmd.stopInstances(cmd.selectInstances(messageContentSlice), hibernate)
+			_, err = cmd.send(statusMessage)
 			if err != nil {
-				log.Println("Error sending message:", err)
+				cmd.log.Println("Error sending message:", err)
+			}
+		}
+
+		if strings.Contains(content, "!cost") {
+			statusMessage = cost.GetCostReport(inv, clients, prices, cmd.manages)
+			_, err = cmd.send(statusMessage)
+			if err != nil {
+				cmd.log.Println("Error sending message:", err)
+			}
+		}
+
+		if strings.Contains(content, "!audit") {
+			messageContentSlice, sinceValue := popFlagValue(strings.Fields(content), "--since")
+			since, err := parseSince(sinceValue)
+			if err != nil {
+				_, err = cmd.send("Invalid `--since`, use a duration like `24h`, `90m` or `7d`.")
+				if err != nil {
+					cmd.log.Println("Error sending message:", err)
+				}
+				return
+			}
+
+			// Terminated instances have left the inventory, so names that aren't found are looked up as they are
+			name := ""
+			if len(messageContentSlice) > 1 {
+				name = messageContentSlice[1]
+				if instance, found := inv.Find(name); found {
+					name = instance.Name()
+				}
+			}
+
+			entries, err := auditLog.Query(name, time.Now().Add(-since))
+			if err != nil {
+				cmd.log.Println("Error reading the audit log:", err)
+				statusMessage = "**ERROR**: There was an error reading the audit log. Please see your bot's error logs for more information."
+			} else {
+				// Channels that only manage some instances only see what was done to them
+				if len(cmd.channel.Instances) > 0 {
+					entries = audit.Filter(entries, func(instance string) bool { return cmd.channel.Manages(instance) })
+				}
+				statusMessage = audit.Format(entries)
+			}
+
+			_, err = cmd.send(statusMessage)
+			if err != nil {
+				cmd.log.Println("Error sending message:", err)
+			}
+		}
+
+		if strings.Contains(content, "!backup") {
+			instance, err := cmd.namedInstance(strings.Fields(content))
+			if err != nil {
+				_, err = cmd.send(err.Error())
+				if err != nil {
+					cmd.log.Println("Error sending message:", err)
+				}
+				return
+			}
+
+			api := cmd.clients.EC2(instance.Location())
+			started, err := backup.Create(api, instance, false)
+			if err != nil {
+				cmd.log.Printf("Error backing up %s: %v", instance.Name(), err)
+				_, err = cmd.send(fmt.Sprintf("**ERROR**: There was an error starting the backup of `%s`. Please see your bot's error logs for more information.", instance.Name()))
+				if err != nil {
+					cmd.log.Println("Error sending message:", err)
+				}
+				return
+			}
+
+			progressMessage, err := cmd.send(backup.Progress(started))
+			if err != nil {
+				cmd.log.Println("Error sending message:", err)
+				return
+			}
+
+			// Keeps the progress message up to date until every snapshot has completed
+			go func() {
+				finished, err := backup.Wait(api, started, func(progress backup.Backup) {
+					_, err := s.ChannelMessageEdit(cmd.channelId, progressMessage.ID, backup.Progress(progress))
+					if err != nil {
+						cmd.log.Println("Error editing message:", err)
+					}
+				})
+
+				message := fmt.Sprintf(":floppy_disk: Backup `%s` of `%s` completed.", finished.Id, instance.Name())
+				if err != nil {
+					cmd.log.Printf("Error waiting for backup %s: %v", finished.Id, err)
+					message = fmt.Sprintf("**ERROR**: Backup `%s` of `%s` failed. Please see your bot's error logs for more information.", finished.Id, instance.Name())
+				}
+
+				_, err = cmd.send(message)
+				if err != nil {
+					cmd.log.Println("Error sending message:", err)
+				}
+			}()
+			return
+		}
+
+		if strings.Contains(content, "!reboot") {
+			instance, err := cmd.namedInstance(strings.Fields(content))
+			if err != nil {
+				statusMessage = err.Error()
+			} else {
+				statusMessage, _ = reboot.RebootEc2Instance(instance, cmd.clients.EC2(instance.Location()))
+			}
+
+			_, err = cmd.send(statusMessage)
+			if err != nil {
+				cmd.log.Println("Error sending message:", err)
+			}
+			return
+		}
+
+		if strings.Contains(content, "!resize") {
+			messageContentSlice, override := popFlag(strings.Fields(content), "--override-budget")
+			if len(messageContentSlice) != 3 {
+				_, err := cmd.send("**Usage:** `!resize <alias> <instance-type>`, i.e. `!resize mc t3.large`")
+				if err != nil {
+					cmd.log.Println("Error sending message:", err)
+				}
+				return
+			}
+
+			instance, err := cmd.namedInstance(messageContentSlice)
+			if err != nil {
+				_, err = cmd.send(err.Error())
+				if err != nil {
+					cmd.log.Println("Error sending message:", err)
+				}
+				return
+			}
+
+			api := cmd.clients.EC2(instance.Location())
+			plan, err := resize.PlanResize(api, instance, messageContentSlice[2])
+			refusal := ""
+			if err != nil {
+				refusal = err.Error()
+			} else if plan.WasRunning {
+				// The instance is started again afterwards, so the same budget rules as !start apply
+				refusal = budgetRefusal([]inventory.Instance{instance}, override, cmd.caller)
+			}
+			if refusal != "" {
+				_, err = cmd.send(refusal)
+				if err != nil {
+					cmd.log.Println("Error sending message:", err)
+				}
+				return
+			}
+
+			specLine := func(spec resize.Spec) string {
+				price, ok := prices.HourlyPrice(instance.Region, spec.InstanceType)
+				if !ok {
+					return spec.String() + ", price unknown"
+				}
+				return fmt.Sprintf("%s, `$%.4f/hour`", spec.String(), price)
+			}
+			summary := fmt.Sprintf("**Before:** %s\n**After:** %s", specLine(plan.From), specLine(plan.To))
+
+			progressMessage, err := cmd.send(fmt.Sprintf(":gear: Resizing `%s`...\n%s", instance.Name(), summary))
+			if err != nil {
+				cmd.log.Println("Error sending message:", err)
+				return
+			}
+
+			// Stopping and starting takes a few minutes, so the progress message is kept up to date in the background
+			go func() {
+				err := resize.Resize(api, inv, plan, func(step string) {
+					_, err := s.ChannelMessageEdit(cmd.channelId, progressMessage.ID, fmt.Sprintf(":gear: %s `%s`...\n%s", step, instance.Name(), summary))
+					if err != nil {
+						cmd.log.Println("Error editing message:", err)
+					}
+				})
+
+				message := fmt.Sprintf(":white_check_mark: `%s` is now a `%s`.\n%s", instance.Name(), plan.To.InstanceType, summary)
+				if err != nil {
+					cmd.log.Printf("Error resizing %s: %v", instance.Name(), err)
+					message = fmt.Sprintf("**ERROR**: There was an error resizing `%s`, it may need starting again with `!start`. Please see your bot's error logs for more information.", instance.Name())
+				} else {
+					err = inv.Update(instance.InstanceId, func(updated *inventory.Instance) {
+						updated.InstanceType = plan.To.InstanceType
+					})
+					if err != nil {
+						cmd.log.Println("Error saving inventory:", err)
+					}
+					if plan.WasRunning {
+						announceRunning(s, cmd.replyChannel(), instance.InstanceId)
+					}
+				}
+
+				_, err = cmd.send(message)
+				if err != nil {
+					cmd.log.Println("Error sending message:", err)
+				}
+			}()
+			return
+		}
+
+		if strings.Contains(content, "!console") || strings.Contains(content, "!screenshot") {
+			messageContentSlice := strings.Fields(content)
+			instance, err := cmd.namedInstance(messageContentSlice)
+			if err != nil {
+				_, err = cmd.send(err.Error())
+				if err != nil {
+					cmd.log.Println("Error sending message:", err)
+				}
+				return
+			}
+
+			api := cmd.clients.EC2(instance.Location())
+			if messageContentSlice[0] == "!screenshot" {
+				image, err := console.Screenshot(api, instance)
+				if err != nil {
+					cmd.log.Printf("Error taking a screenshot of %s: %v", instance.Name(), err)
+					_, err = cmd.send(fmt.Sprintf("**ERROR**: There was an error taking a screenshot of `%s`: %s", instance.Name(), err))
+				} else {
+					_, err = cmd.sendFile(fmt.Sprintf(":camera: Console of `%s`:", instance.Name()), instance.Name()+"-screenshot.jpg", bytes.NewReader(image))
+				}
+				if err != nil {
+					cmd.log.Println("Error sending message:", err)
+				}
 				return
 			}
+
+			output, err := console.Get(api, instance, console.TailLines)
+			switch {
+			case err != nil:
+				cmd.log.Printf("Error getting the console output of %s: %v", instance.Name(), err)
+				_, err = cmd.send(fmt.Sprintf("**ERROR**: There was an error getting the console output of `%s`. Please see your bot's error logs for more information.", instance.Name()))
+			case output.Text == "":
+				_, err = cmd.send(fmt.Sprintf("`%s` has no console output yet. EC2 captures it shortly after the instance boots, so try again in a few minutes.", instance.Name()))
+			default:
+				message := fmt.Sprintf(":scroll: The end of `%s`'s console output", instance.Name())
+				if !output.Timestamp.IsZero() {
+					message += fmt.Sprintf(" as of %s", output.Timestamp.UTC().Format("2006-01-02 15:04 MST"))
+				}
+				if !output.Latest {
+					message += " (captured after its last boot, as it isn't a Nitro instance)"
+				}
+				_, err = cmd.sendFile(message+":", instance.Name()+"-console.txt", strings.NewReader(output.Text))
+			}
+			if err != nil {
+				cmd.log.Println("Error sending message:", err)
+			}
+			return
 		}
 
-		if strings.Contains(previousDiscordMessages[0], "!start") {
-			messageContentSlice := strings.Fields(previousDiscordMessages[0])
+		if strings.Contains(content, "!run") {
+			messageContentSlice := strings.Fields(content)
+			if len(messageContentSlice) < 3 {
+				statusMessage = "No actions are set up for `!run`, add some under `run.actions` in the bot's config file."
+				if len(botCfg.Run.Actions) > 0 {
+					statusMessage = "**Usage:** `!run <alias> <action> [arguments]`\n**Actions:**\n" + run.Describe(botCfg.Run)
+				}
+
+				_, err := cmd.send(statusMessage)
+				if err != nil {
+					cmd.log.Println("Error sending message:", err)
+				}
+				return
+			}
+
+			instance, err := cmd.namedInstance(messageContentSlice)
+			if err != nil {
+				_, err = cmd.send(err.Error())
+				if err != nil {
+					cmd.log.Println("Error sending message:", err)
+				}
+				return
+			}
+
+			var roleIds []string
+			if m.Member != nil {
+				roleIds = m.Member.Roles
+			}
+
+			actionName := messageContentSlice[2]
+			action, ok := botCfg.Run.Actions[actionName]
+			command := ""
+			switch {
+			case !ok:
+				statusMessage = fmt.Sprintf("There's no action called `%s`. Use `!run` on its own to list them.", actionName)
+			case !cmd.caller.Admin && !run.Allowed(botCfg, action, m.Author.ID, roleIds):
+				statusMessage = fmt.Sprintf(":no_entry: %s, you don't have a role allowed to use `%s`.", m.Author.Mention(), actionName)
+			default:
+				command, err = run.Render(action, instance, messageContentSlice[3:])
+				if err != nil {
+					statusMessage = fmt.Sprintf("Can't run `%s`: %s", actionName, err)
+				}
+			}
+			if command == "" {
+				_, err = cmd.send(statusMessage)
+				if err != nil {
+					cmd.log.Println("Error sending message:", err)
+				}
+				return
+			}
+
+			_, err = cmd.send(fmt.Sprintf(":gear: Running `%s` on `%s`...", actionName, instance.Name()))
+			if err != nil {
+				cmd.log.Println("Error sending message:", err)
+			}
+
+			// Commands can take a while, so the result is posted once it's in
+			go func() {
+				result, err := run.Send(cmd.clients.SSM(instance.Location()), instance, action, command, m.Author.String())
+				if err != nil {
+					cmd.log.Printf("Error running %s on %s: %v", actionName, instance.Name(), err)
+					_, err = cmd.send(fmt.Sprintf("**ERROR**: There was an error running `%s` on `%s`: %s", actionName, instance.Name(), err))
+					if err != nil {
+						cmd.log.Println("Error sending message:", err)
+					}
+					return
+				}
+
+				heading := fmt.Sprintf(":white_check_mark: `%s` on `%s` finished.", actionName, instance.Name())
+				if !result.Succeeded() {
+					heading = fmt.Sprintf(":x: `%s` on `%s` finished with status `%s` (exit code %d).", actionName, instance.Name(), result.Status, result.ResponseCode)
+				}
+
+				output := run.Output(result)
+				switch {
+				case output == "":
+					_, err = cmd.send(heading + " It didn't print anything.")
+				case len(output) <= runOutputLimit:
+					// Breaks up any backticks in the output so they can't end the code block early
+					_, err = cmd.send(heading + "\n```\n" + strings.ReplaceAll(output, "```", "`\u200b``") + "\n```")
+				default:
+					// Too long for a message, so it's attached instead
+					_, err = cmd.sendFile(heading+" Its output is attached.", fmt.Sprintf("%s-%s.txt", instance.Name(), actionName), strings.NewReader(output))
+				}
+				if err != nil {
+					cmd.log.Println("Error sending message:", err)
+				}
+			}()
+			return
+		}
+
+		if strings.Contains(content, "!allow") || strings.Contains(content, "!deny") {
+			messageContentSlice, hours := popFlagValue(strings.Fields(content), "--hours")
+			instance, err := cmd.namedInstance(messageContentSlice)
+			if err != nil {
+				_, err = cmd.send(err.Error())
+				if err != nil {
+					cmd.log.Println("Error sending message:", err)
+				}
+				return
+			}
+
+			api := cmd.clients.EC2(instance.Location())
+			expiryHours := botCfg.Allowlist.DefaultExpiryHours
+			if hours != "" {
+				expiryHours, err = strconv.Atoi(hours)
+			}
+			var expires time.Time
+			if expiryHours > 0 {
+				expires = time.Now().Add(time.Duration(expiryHours) * time.Hour)
+			}
+
+			switch {
+			case err != nil || expiryHours < 0:
+				statusMessage = fmt.Sprintf("Invalid `--hours`: `%s`, please give a whole number of hours (0 for access that never expires).", hours)
+			case len(messageContentSlice) < 3 && messageContentSlice[0] == "!deny":
+				statusMessage = fmt.Sprintf("Please give the IP address to remove, i.e. `!deny %s 203.0.113.7`", instance.Name())
+			case len(messageContentSlice) < 3:
+				rules, err := firewall.List(api, instance)
+				if err != nil {
+					cmd.log.Printf("Error listing ingress rules of %s: %v", instance.Name(), err)
+					statusMessage = fmt.Sprintf("**ERROR**: There was an error listing who can connect to `%s`. Please see your bot's error logs for more information.", instance.Name())
+					break
+				}
+
+				var lines []string
+				for _, rule := range rules {
+					lines = append(lines, rule.String())
+				}
+				statusMessage = fmt.Sprintf("**Allowed to connect to `%s`**\n", instance.Name()) + strings.Join(lines, "\n")
+				if len(lines) < 1 {
+					statusMessage = fmt.Sprintf("Nobody has been allowed to connect to `%s` with `!allow` yet. Use `!allow %s <ip>` or `!allow %s me`.", instance.Name(), instance.Name(), instance.Name())
+				}
+			case messageContentSlice[0] == "!deny":
+				removed, err := firewall.Deny(api, instance, messageContentSlice[2])
+				if err != nil {
+					cmd.log.Printf("Error denying %s: %v", messageContentSlice[2], err)
+					statusMessage = fmt.Sprintf("**ERROR**: There was an error removing `%s` from `%s`: %s", messageContentSlice[2], instance.Name(), err)
+				} else if len(removed) < 1 {
+					statusMessage = fmt.Sprintf("`%s` wasn't allowed to connect to `%s` by `!allow`.", messageContentSlice[2], instance.Name())
+				} else {
+					statusMessage = fmt.Sprintf(":lock: `%s` can no longer connect to `%s`.", messageContentSlice[2], instance.Name())
+				}
+			case messageContentSlice[2] == "me":
+				if meLinks == nil {
+					statusMessage = "Me-links need the bot's HTTP server, set `http.listen` and `http.public_url` in the bot's config file."
+					break
+				}
+
+				link, err := meLinks.Create(firewall.MeRequest{Instance: instance, RequestedBy: m.Author.String(), Expires: expires})
+				if err != nil {
+					cmd.log.Println("Error creating me-link:", err)
+					statusMessage = "**ERROR**: There was an error creating your link. Please see your bot's error logs for more information."
+					break
+				}
+				statusMessage = fmt.Sprintf("%s, open <%s> from the computer you play on (within 15 minutes) to allow its IP address to connect to `%s`.", m.Author.Mention(), link, instance.Name())
+			default:
+				statusMessage = allowIp(instance, messageContentSlice[2], m.Author.String(), expires)
+			}
+
+			_, err = cmd.send(statusMessage)
+			if err != nil {
+				cmd.log.Println("Error sending message:", err)
+			}
+			return
+		}
+
+		if strings.Contains(content, "!eip") {
+			messageContentSlice := strings.Fields(content)
+			action := "list"
+			if len(messageContentSlice) > 1 {
+				action = messageContentSlice[1]
+			}
+
+			switch action {
+			case "attach", "release":
+				instance, err := cmd.namedInstance(append([]string{"!eip " + action}, messageContentSlice[2:]...))
+				if err != nil {
+					statusMessage = err.Error()
+					break
+				}
+
+				api := cmd.clients.EC2(instance.Location())
+				if action == "attach" {
+					publicIp, err := eip.Attach(api, instance)
+					var foreign *eip.ForeignAddressError
+					if errors.As(err, &foreign) {
+						statusMessage = fmt.Sprintf(":x: `%s` already has the Elastic IP `%s`, which the bot didn't allocate, so it's been left as it is.", instance.Name(), foreign.PublicIp)
+						break
+					}
+					if err != nil {
+						cmd.log.Printf("Error attaching an Elastic IP to %s: %v", instance.Name(), err)
+						statusMessage = fmt.Sprintf("**ERROR**: There was an error attaching an Elastic IP to `%s`. Please see your bot's error logs for more information.", instance.Name())
+						break
+					}
+					statusMessage = fmt.Sprintf(":pushpin: `%s`'s address is now the Elastic IP `%s`, which stays the same when it's stopped and started. It costs `$%.3f/hour`, even while the instance is stopped.", instance.Name(), publicIp, eip.HourlyPrice)
+
+					// A stopped instance's hostname is published when it's next started
+					if isRunning(api, instance.InstanceId) {
+						announceRunning(s, cmd.replyChannel(), instance.InstanceId)
+					}
+				} else {
+					statusMessage = strings.TrimPrefix(releaseElasticIps(instance.InstanceId, instance.Location()), "\n")
+					if statusMessage == "" {
+						statusMessage = fmt.Sprintf("`%s` doesn't have an Elastic IP.", instance.Name())
+					}
+				}
+			case "list":
+				locations := inventory.ByLocation(cmd.instances())
+				locations[defaultLocation()] = nil
+
+				var lines []string
+				for _, location := range inventory.SortedLocations(locations) {
+					addresses, err := eip.List(cmd.clients.EC2(location))
+					if err != nil {
+						cmd.log.Printf("Error listing Elastic IPs in %s: %v", location, err)
+						lines = append(lines, fmt.Sprintf(":warning: Couldn't list the Elastic IPs in `%s`", location))
+						continue
+					}
+					for _, address := range addresses {
+						lines = append(lines, eip.Describe(address))
+					}
+				}
 
-			statusMessage, UserInstanceId = start.StartEc2Instance(messageContentSlice, instanceIds, client)
-			_, err = s.ChannelMessageSend(ChannelId, statusMessage)
+				statusMessage = "**Elastic IPs allocated by the bot**\n" + strings.Join(lines, "\n")
+				if len(lines) < 1 {
+					statusMessage = "The bot hasn't allocated any Elastic IPs. Use `!eip attach <alias>` to give an instance one."
+				}
+			default:
+				statusMessage = "Usage: `!eip`, `!eip attach <alias>` or `!eip release <alias>`"
+			}
+
+			_, err = cmd.send(statusMessage)
 			if err != nil {
-				log.Println("Error sending message:", err)
+				cmd.log.Println("Error sending message:", err)
 			}
+			return
 		}
 
-		if strings.Contains(previousDiscordMessages[0], "!stop") {
-			messageContentSlice := strings.Fields(previousDiscordMessages[0])
+		if strings.Contains(content, "!images") {
+			locations := inventory.ByLocation(cmd.instances())
+			locations[defaultLocation()] = nil
 
-			statusMessage = stop.StopEc2Instance(messageContentSlice, instanceIds, client)
-			_, err = s.ChannelMessageSend(ChannelId, statusMessage)
+			var lines []string
+			for _, location := range inventory.SortedLocations(locations) {
+				baked, err := images.List(cmd.clients.EC2(location))
+				if err != nil {
+					cmd.log.Printf("Error listing images in %s: %v", location, err)
+					lines = append(lines, fmt.Sprintf(":warning: Couldn't list the images in `%s`", location))
+					continue
+				}
+				for _, image := range baked {
+					lines = append(lines, fmt.Sprintf("%s in `%s`", images.Describe(image), location))
+				}
+			}
+
+			statusMessage = "**Images baked by the bot**\n" + strings.Join(lines, "\n")
+			if len(lines) < 1 {
+				statusMessage = "The bot hasn't baked any images yet. Use `!image <alias> --name <name>` to bake one."
+			}
+
+			_, err = cmd.send(statusMessage)
 			if err != nil {
-				log.Println("Error sending message:", err)
+				cmd.log.Println("Error sending message:", err)
 			}
+			return
 		}
 
-		if strings.Contains(previousDiscordMessages[0], "!create") {
-			GenerateOTP(OTPLength)
+		if strings.Contains(content, "!image") {
+			messageContentSlice, name := popFlagValue(strings.Fields(content), "--name")
+			messageContentSlice, noReboot := popFlag(messageContentSlice, "--no-reboot")
+
+			if len(messageContentSlice) > 2 && messageContentSlice[1] == "delete" {
+				messageContentSlice, location, err := createLocation(messageContentSlice)
+				if err != nil {
+					statusMessage = fmt.Sprintf("Invalid `--account`: %s", err)
+				} else if snapshotIds, err := images.Delete(cmd.clients.EC2(location), messageContentSlice[2]); err != nil {
+					cmd.log.Println("Error deleting image:", err)
+					statusMessage = fmt.Sprintf("**ERROR**: There was an error deleting the image `%s` in `%s`: %s", messageContentSlice[2], location, err)
+				} else {
+					statusMessage = fmt.Sprintf(":wastebasket: Deregistered the image `%s` and deleted its snapshots: `%s`", messageContentSlice[2], strings.Join(snapshotIds, "`, `"))
+				}
+
+				_, err = cmd.send(statusMessage)
+				if err != nil {
+					cmd.log.Println("Error sending message:", err)
+				}
+				return
+			}
+
+			instance, err := cmd.namedInstance(messageContentSlice)
+			if err == nil && name == "" {
+				err = fmt.Errorf("Please give the image a name with `--name`, i.e. `!image %s --name %s-base`", instance.Name(), instance.Name())
+			}
+			if err != nil {
+				_, err = cmd.send(err.Error())
+				if err != nil {
+					cmd.log.Println("Error sending message:", err)
+				}
+				return
+			}
+
+			api := cmd.clients.EC2(instance.Location())
+			imageId, err := images.Create(api, instance, name, noReboot)
+			if err != nil {
+				cmd.log.Println("Error creating image:", err)
+				_, err = cmd.send(fmt.Sprintf("**ERROR**: There was an error baking an image of `%s`: %s", instance.Name(), err))
+				if err != nil {
+					cmd.log.Println("Error sending message:", err)
+				}
+				return
+			}
+
+			statusMessage = fmt.Sprintf(":hourglass_flowing_sand: Baking the image `%s` (`%s`) from `%s`, this can take a while...", name, imageId, instance.Name())
+			if !noReboot {
+				statusMessage += fmt.Sprintf("\n`%s` will be rebooted so its disks are consistent, add `--no-reboot` next time to avoid that.", instance.Name())
+			}
+			_, err = cmd.send(statusMessage)
+			if err != nil {
+				cmd.log.Println("Error sending message:", err)
+			}
+
+			go func() {
+				message := fmt.Sprintf(":white_check_mark: The image `%s` is ready. Use `!create --ami-alias %s` to launch an instance from it.", name, name)
+				err := images.Wait(api, imageId)
+				if err != nil {
+					cmd.log.Printf("Error waiting for image %s: %v", imageId, err)
+					message = fmt.Sprintf("**ERROR**: The image `%s` (`%s`) didn't become available. Please see your bot's error logs for more information.", name, imageId)
+				}
+
+				_, err = cmd.send(message)
+				if err != nil {
+					cmd.log.Println("Error sending message:", err)
+				}
+			}()
 			return
 		}
 
-		if strings.Contains(previousDiscordMessages[0], "!terminate") {
-			GenerateOTP(OTPLength)
+		if strings.Contains(content, "!create") {
+			statusMessage, passed, dryRun := cmd.preflightCreate(content)
+			if passed && !dryRun {
+				statusMessage += "\nEnter the one time password from the bot's OTP file to create this instance."
+			}
+
+			_, err = cmd.send(statusMessage)
+			if err != nil {
+				cmd.log.Println("Error sending message:", err)
+			}
+
+			if passed && !dryRun {
+				awaitOtp(cmd.channelId, pendingOtp{command: content})
+			}
 			return
 		}
 
-		if previousDiscordMessages[0] == oneTimePassword && strings.Contains(previousDiscordMessages[1], "!create") {
+		if strings.Contains(content, "!restore") {
+			_, plan, err := cmd.planRestore(strings.Fields(content))
+			if err != nil {
+				_, err = cmd.send(fmt.Sprintf(":x: Can't restore: %s", err))
+				if err != nil {
+					cmd.log.Println("Error sending message:", err)
+				}
+				return
+			}
 
-			// Breaks !create message into an array of strings
-			messageContentSlice := strings.Fields(previousDiscordMessages[1])
+			_, err = cmd.send(plan.Summary() + "\n\nEnter the one time password from the bot's OTP file to go ahead.")
+			if err != nil {
+				cmd.log.Println("Error sending message:", err)
+			}
 
-			// Sets flags we're looking for in this command
-			flagArray := []string{"-sn", "-sg", "-ami", "-tk", "-tv", "-u", "-svc", "-sp", "-scp", "-ia", "-in", "-k", "-it"}
+			awaitOtp(cmd.channelId, pendingOtp{command: content})
+			return
+		}
 
-			statusMessage, UserInstanceId, UserTagKey, UserTagValue, UserServiceName, UserServicePort, ServiceCheckPort = create.CreateEc2Instance(messageContentSlice, flagArray, client)
-			_, err = s.ChannelMessageSend(ChannelId, statusMessage)
+		if strings.Contains(content, "!terminate") {
+			messageContentSlice, finalSnapshot := popFlag(strings.Fields(content), "--final-snapshot")
+
+			targets := cmd.selectInstances(messageContentSlice)
+			statusMessage, ok := cmd.terminationSummary(targets, finalSnapshot)
+			if ok {
+				statusMessage += "\nEnter the one time password from the bot's OTP file to terminate them."
+			}
+
+			_, err = cmd.send(statusMessage)
 			if err != nil {
-				log.Println("Error sending message:", err)
+				cmd.log.Println("Error sending message:", err)
 			}
 
-			instanceIds = append(instanceIds, UserInstanceId)
+			if ok {
+				var instanceIds []string
+				for _, target := range targets {
+					instanceIds = append(instanceIds, target.InstanceId)
+				}
+				awaitOtp(cmd.channelId, pendingOtp{command: content, instanceIds: instanceIds, finalSnapshot: finalSnapshot})
+			}
+			return
 		}
 
-		if previousDiscordMessages[0] == oneTimePassword && strings.Contains(previousDiscordMessages[1], "!terminate") {
+		pending, confirmed := takeOtp(cmd.channelId, content)
+		if !confirmed {
+			return
+		}
+		pendingOtpCommand := pending.command
 
-			// Breaks !create message into an array of strings
-			messageContentSlice := strings.Fields(previousDiscordMessages[1])
+		if strings.Contains(pendingOtpCommand, "!create") {
+			cmd.createInstance(pendingOtpCommand, func(message string) {
+				_, err := cmd.send(message)
+				if err != nil {
+					cmd.log.Println("Error sending message:", err)
+				}
+			})
+		}
 
-			statusMessage, UserInstanceId = terminate.TerminateEc2Instance(messageContentSlice, instanceIds, client)
-			_, err = s.ChannelMessageSend(ChannelId, statusMessage)
+		if strings.Contains(pendingOtpCommand, "!restore") {
+			messageContentSlice, plan, err := cmd.planRestore(strings.Fields(pendingOtpCommand))
 			if err != nil {
-				log.Println("Error sending message:", err)
+				_, err = cmd.send(fmt.Sprintf(":x: Can't restore: %s", err))
+				if err != nil {
+					cmd.log.Println("Error sending message:", err)
+				}
 				return
 			}
+
+			_, err = cmd.send("One time password entered correctly, restoring. This can take a few minutes...")
+			if err != nil {
+				cmd.log.Println("Error sending message:", err)
+			}
+
+			location := plan.Instance.Location()
+			if plan.OldVolume != nil {
+				volumeId, err := restore.Swap(cmd.clients.EC2(location), plan)
+				var attachErr *restore.AttachError
+				if errors.As(err, &attachErr) {
+					cmd.log.Printf("Error restoring %s: %v", plan.Instance.Name(), err)
+					statusMessage = fmt.Sprintf("**ERROR**: There was an error attaching the restored volume to `%s`. %s Please see your bot's error logs for more information.", plan.Instance.Name(), attachErr.State())
+				} else if err != nil {
+					cmd.log.Printf("Error restoring %s: %v", plan.Instance.Name(), err)
+					statusMessage = fmt.Sprintf("**ERROR**: There was an error restoring `%s`. Please see your bot's error logs for more information.", plan.Instance.Name())
+				} else {
+					statusMessage = fmt.Sprintf(":white_check_mark: `%s`'s root volume is now `%s`, restored from `%s`. Use `!start` to start it.", plan.Instance.Name(), volumeId, aws.ToString(plan.Snapshot.SnapshotId))
+				}
+			} else {
+				imageId, err := restore.RegisterImage(cmd.clients.EC2(location), plan)
+				if err != nil {
+					cmd.log.Printf("Error registering an AMI for %s: %v", plan.Instance.Name(), err)
+					statusMessage = fmt.Sprintf("**ERROR**: There was an error registering an AMI from `%s`. Please see your bot's error logs for more information.", aws.ToString(plan.Snapshot.SnapshotId))
+				} else {
+					// The new instance goes through the same checks as any other !create, launched from the restored AMI
+					// where the backup was taken
+					content := restoreCreateContent(messageContentSlice, location, imageId)
+					message, passed, _ := cmd.preflightLaunch(content)
+					if passed {
+						cmd.createInstance(content, func(message string) {
+							_, err := cmd.send(message)
+							if err != nil {
+								cmd.log.Println("Error sending message:", err)
+							}
+						})
+						return
+					}
+					statusMessage = fmt.Sprintf(":x: The AMI `%s` was registered from `%s`, but the new instance wasn't launched:\n%s", imageId, aws.ToString(plan.Snapshot.SnapshotId), message)
+				}
+			}
+
+			_, err = cmd.send(statusMessage)
+			if err != nil {
+				cmd.log.Println("Error sending message:", err)
+			}
+		}
+
+		if strings.Contains(pendingOtpCommand, "!terminate") {
+			// Terminates exactly the instances the summary listed, not whatever the command would select now
+			var targets []inventory.Instance
+			for _, instanceId := range pending.instanceIds {
+				instance, found := inv.Find(instanceId)
+				if !found {
+					_, err = cmd.send(fmt.Sprintf("**ERROR**: `%s` is no longer in the bot's inventory, so nothing has been terminated. Send `!terminate` again to see what would be.", instanceId))
+					if err != nil {
+						cmd.log.Println("Error sending message:", err)
+					}
+					return
+				}
+				targets = append(targets, instance)
+			}
+
+			cmd.terminateInstances(targets, pending.finalSnapshot, func(message string) {
+				_, err := cmd.send(message)
+				if err != nil {
+					cmd.log.Println("Error sending message:", err)
+				}
+			})
 		}
 
 		return
 	}
 }
 
+// Adds the -i start up instance to the inventory, as long as it actually exists in the default region
+func seedInventory() {
+	if UserInstanceId == "" {
+		return
+	}
+
+	if _, found := inv.Find(UserInstanceId); found {
+		return
+	}
+
+	location := defaultLocation()
+	_, err := status.GetInstances(context.TODO(), clients.EC2(location), &ec2.DescribeInstancesInput{
+		InstanceIds: []string{UserInstanceId},
+	})
+	if err != nil {
+		log.Printf("Instance %s from -i could not be found in %s, not adding it to the inventory: %v", UserInstanceId, location, err)
+		return
+	}
+
+	err = inv.Add(inventory.Instance{InstanceId: UserInstanceId, Region: location.Region, Account: location.Account})
+	if err != nil {
+		log.Println("Error saving inventory:", err)
+	}
+}
+
 func main() {
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Println("Error loading config:", err)
+		return
+	}
+
+	botCfg, err = botconfig.Load(ConfigPath)
+	if err != nil {
+		log.Println("Error loading bot config:", err)
+		return
+	}
+
+	// Everything logged from here on is in the configured format, with the bot's secrets redacted
+	secrets := []string{Token}
+	for _, key := range botCfg.API.Keys {
+		secrets = append(secrets, key.Key)
+	}
+	logging.Setup(botCfg.Logging, secrets...)
+
+	prices, err = cost.NewPriceTable(botCfg.Prices)
+	if err != nil {
+		log.Println("Error loading price table:", err)
+		return
+	}
+
+	clients = awsclient.NewPool(cfg, UserRegion, botCfg)
+	dnsProvider = ddns.NewProvider(botCfg.DNS, clients)
+	log.Println("Default AWS account and region:", defaultLocation())
+
+	inv, err = inventory.Load(InventoryPath)
+	if err != nil {
+		log.Println("Error loading inventory:", err)
+		return
+	}
+
+	auditPath := botCfg.Audit.Path
+	if auditPath == "" {
+		auditPath = "audit.jsonl"
+	}
+	auditLog = audit.Open(auditPath)
+
+	seedInventory()
+
 	// Creating Discord Session Using Provided Bot Token
 	dg, err := discordgo.New("Bot " + Token)
 	if err != nil {
@@ -270,6 +1576,14 @@ func main() {
 	// Registers the messageCreated function as a callback for a MessageCreated Event
 	dg.AddHandler(messageCreated)
 
+	// Tracks the gateway connection for the bot's metrics
+	dg.AddHandler(func(s *discordgo.Session, c *discordgo.Connect) {
+		metrics.DiscordConnected.Set(1)
+	})
+	dg.AddHandler(func(s *discordgo.Session, d *discordgo.Disconnect) {
+		metrics.DiscordConnected.Set(0)
+	})
+
 	// Sets the intentions of the bot, read through the docs
 	dg.Identify.Intents = discordgo.IntentsGuildMessages
 
@@ -282,6 +1596,33 @@ func main() {
 		log.Println("Discord websocket connection opened successfully")
 	}
 
+	// Warns the channels managing the instances (and optionally stops them) as budgets get used up
+	go budget.Watch(botCfg.Budget, inv, clients, prices, func(message string, about ...inventory.Instance) {
+		postAbout(dg, message, about...)
+	})
+
+	// Takes scheduled backups and prunes old ones
+	go backup.Schedule(botCfg.Backups, inv, clients, func(message string, about ...inventory.Instance) {
+		postAbout(dg, message, about...)
+	})
+
+	// Posts state changes and scheduled maintenance, including ones made outside the bot
+	go watch.Instances(botCfg.Watch, inv, clients, func(message string, about ...inventory.Instance) {
+		postAbout(dg, message, about...)
+	})
+
+	// Keeps the health check and player count metrics up to date between !status commands
+	if botCfg.HTTP.Metrics && ServiceCheckPort != "" {
+		go status.PollServices(inv, clients, ServiceCheckPort, time.Minute)
+	}
+
+	// Removes !allow rules once they expire
+	go firewall.EnforceExpiry(inv, clients, func(message string, about ...inventory.Instance) {
+		postAbout(dg, message, about...)
+	})
+
+	startHttpServer(dg)
+
 	// Wait here until CTRL+C or other term signal is received.
 	log.Println("Bot is now running.  Press CTRL+C to exit.")
 	sc := make(chan os.Signal, 1)
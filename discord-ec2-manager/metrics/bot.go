@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"context"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// The bot's metrics
+var (
+	Commands = NewCounter("commands_total", "Commands handled from Discord or the HTTP API, by command and result (ok or error).", "command", "result")
+
+	AWSCalls  = NewCounter("aws_api_calls_total", "AWS API calls made, by service and operation.", "service", "operation")
+	AWSErrors = NewCounter("aws_api_errors_total", "AWS API calls that failed after retries, by service and operation.", "service", "operation")
+
+	HealthChecks        = NewCounter("health_checks_total", "Service health checks run by !status, by instance ID and result (active, inactive or error).", "instance_id", "result")
+	HealthCheckDuration = NewHistogram("health_check_duration_seconds", "How long service health checks took, by instance ID.", []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}, "instance_id")
+
+	Players = NewGauge("players", "Players online on each instance, when its service check's response reports them.", "instance_id")
+
+	InstanceState = NewGauge("instance_state", "1 for each instance's current state, as last seen by the watcher.", "instance_id", "state")
+
+	DiscordConnected = NewGauge("discord_connected", "1 while the bot's Discord gateway connection is open.")
+)
+
+// Counts every AWS API call a client makes, once its retries are done. Added to the bot's AWS config's APIOptions.
+func CountAWSCalls(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("CountAWSCalls", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		out, metadata, err := next.HandleInitialize(ctx, in)
+
+		service, operation := awsmiddleware.GetServiceID(ctx), awsmiddleware.GetOperationName(ctx)
+		AWSCalls.Inc(service, operation)
+		if err != nil {
+			AWSErrors.Inc(service, operation)
+		}
+
+		return out, metadata, err
+	}), middleware.After)
+}
@@ -0,0 +1,225 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Every metric's name starts with this
+const namespace = "discord_ec2_manager"
+
+// A metric that can write itself in the Prometheus text format
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []metric
+)
+
+func register(m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	registry = append(registry, m)
+}
+
+// Serves every metric in the Prometheus text format, for /metrics
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+		registryMu.Lock()
+		defer registryMu.Unlock()
+
+		for _, m := range registry {
+			m.write(w)
+		}
+	})
+}
+
+// The values of a metric by label values, joined with a separator that can't appear in them
+type series struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newSeries(name string, help string, kind string, labels []string) *series {
+	s := &series{name: namespace + "_" + name, help: help, kind: kind, labels: labels, values: map[string]float64{}}
+	register(s)
+	return s
+}
+
+func (s *series) key(labelValues []string) string {
+	if len(labelValues) != len(s.labels) {
+		panic(fmt.Sprintf("%s takes %d label values, got %d", s.name, len(s.labels), len(labelValues)))
+	}
+
+	return strings.Join(labelValues, "\x00")
+}
+
+// Formats label values for the text format, i.e. {command="start",result="ok"}
+func formatLabels(names []string, values []string) string {
+	if len(names) < 1 {
+		return ""
+	}
+
+	var pairs []string
+	for i, name := range names {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(values[i])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, value))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (s *series) write(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", s.name, s.help, s.name, s.kind)
+
+	var keys []string
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %g\n", s.name, formatLabels(s.labels, strings.Split(key, "\x00")), s.values[key])
+	}
+}
+
+// A count that only goes up, i.e. commands run
+type Counter struct {
+	*series
+}
+
+// Creates and registers a counter with the given label names
+func NewCounter(name string, help string, labels ...string) *Counter {
+	return &Counter{newSeries(name, help, "counter", labels)}
+}
+
+// Adds one to the count for the label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.values[c.key(labelValues)]++
+}
+
+// A value that can go up and down, i.e. whether Discord is connected
+type Gauge struct {
+	*series
+}
+
+// Creates and registers a gauge with the given label names
+func NewGauge(name string, help string, labels ...string) *Gauge {
+	return &Gauge{newSeries(name, help, "gauge", labels)}
+}
+
+// Sets the value for the label values
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.values[g.key(labelValues)] = value
+}
+
+// Removes the value for the label values, i.e. once an instance has gone
+func (g *Gauge) Delete(labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.values, g.key(labelValues))
+}
+
+// Counts observations into buckets, i.e. how long health checks take
+type Histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	counts map[string][]uint64
+	sums   map[string]float64
+	totals map[string]uint64
+}
+
+// Creates and registers a histogram with the given upper bucket bounds (in increasing order) and label names
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		name:    namespace + "_" + name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		counts:  map[string][]uint64{},
+		sums:    map[string]float64{},
+		totals:  map[string]uint64{},
+	}
+	register(h)
+	return h
+}
+
+// Records an observation for the label values
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	if len(labelValues) != len(h.labels) {
+		panic(fmt.Sprintf("%s takes %d label values, got %d", h.name, len(h.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\x00")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	counts, ok := h.counts[key]
+	if !ok {
+		counts = make([]uint64, len(h.buckets))
+		h.counts[key] = counts
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			counts[i]++
+		}
+	}
+	h.sums[key] += value
+	h.totals[key]++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+
+	var keys []string
+	for key := range h.counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	bucketLabels := append(append([]string{}, h.labels...), "le")
+	for _, key := range keys {
+		labelValues := strings.Split(key, "\x00")
+		if len(h.labels) < 1 {
+			labelValues = nil
+		}
+
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(bucketLabels, append(append([]string{}, labelValues...), fmt.Sprint(bound))), h.counts[key][i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(bucketLabels, append(append([]string{}, labelValues...), "+Inf")), h.totals[key])
+		fmt.Fprintf(w, "%s_sum%s %g\n", h.name, formatLabels(h.labels, labelValues), h.sums[key])
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, labelValues), h.totals[key])
+	}
+}
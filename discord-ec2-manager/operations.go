@@ -0,0 +1,454 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/api"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/awsclient"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/create"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/ddns"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/eip"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/inventory"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/start"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/status"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/stop"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/terminate"
+)
+
+// The operations below are shared by the Discord commands and the HTTP API, so both follow the same rules
+
+// Reply for commands that find nothing to act on
+const noInstancesMessage = "There are no instances in the bot's inventory yet. Use `!create` to add one."
+
+// The create package keeps a !create's flags in package variables, so only one can be parsed at a time. Each starts
+// from the bot's start up flags (see create.Reset), so nothing carries over from the last one.
+var createMu sync.Mutex
+
+// Gets the status of the targeted instances, one account and region at a time
+func (c *command) instanceStatuses(targets []inventory.Instance) string {
+	message := forEachLocation(targets, func(location awsclient.Location, instanceIds []string) string {
+		hostnames := map[string]string{}
+		for _, instanceId := range instanceIds {
+			if instance, found := inv.Find(instanceId); found && ddns.Hostname(botCfg.DNS, instance) != "" {
+				hostnames[instanceId] = ddns.Hostname(botCfg.DNS, instance)
+			}
+		}
+		return status.GetEc2InstanceStatus(instanceIds, location.String(), hostnames, UserTagKey, UserTagValue, ServiceCheckPort, UserServiceName, UserServicePort, c.clients.EC2(location))
+	})
+	if message == "" {
+		message = noInstancesMessage
+	}
+
+	return message
+}
+
+// Starts the targeted instances, unless a budget covering them is used up. ok is false if they were refused, or
+// starting them failed.
+func (c *command) startInstances(targets []inventory.Instance, overrideBudget bool) (message string, ok bool) {
+	c.target(targets...)
+	if refusal := budgetRefusal(targets, overrideBudget, c.caller); refusal != "" {
+		return refusal, false
+	}
+
+	ok = true
+	message = forEachLocation(targets, func(location awsclient.Location, instanceIds []string) string {
+		message, err := start.StartEc2Instance(instanceIds, c.clients.EC2(location))
+		ok = ok && err == nil
+		if err == nil {
+			recordTransitions(instanceIds, "running")
+			for _, instanceId := range instanceIds {
+				announceRunning(c.session, c.replyChannel(), instanceId)
+			}
+		}
+		return message
+	})
+	if message == "" {
+		return noInstancesMessage, false
+	}
+
+	return message, ok
+}
+
+// Stops (or hibernates) the targeted instances. ok is false if there was nothing to stop, or stopping them failed.
+func (c *command) stopInstances(targets []inventory.Instance, hibernate bool) (message string, ok bool) {
+	c.target(targets...)
+	ok = true
+	message = forEachLocation(targets, func(location awsclient.Location, instanceIds []string) string {
+		stopEc2Instance := stop.StopEc2Instance
+		if hibernate {
+			stopEc2Instance = stop.HibernateEc2Instance
+		}
+
+		message, err := stopEc2Instance(instanceIds, c.clients.EC2(location))
+		ok = ok && err == nil
+		if err == nil {
+			recordTransitions(instanceIds, "stopped")
+			withdrawHostnames(instanceIds)
+		}
+		return message
+	})
+	if message == "" {
+		return noInstancesMessage, false
+	}
+
+	return message, ok
+}
+
+// Returns the names of the profiles in the bot's config file, in alphabetical order
+func createProfileNames() []string {
+	var names []string
+	for name := range botCfg.CreateProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Replaces --profile with the !create flags saved under that name in the bot's config file. Flags given alongside it
+// come afterwards, so they win.
+func expandProfile(messageContentSlice []string) ([]string, error) {
+	messageContentSlice, name := popFlagValue(messageContentSlice, "--profile")
+	if name == "" {
+		return messageContentSlice, nil
+	}
+
+	profile, ok := botCfg.CreateProfiles[name]
+	if !ok {
+		return messageContentSlice, fmt.Errorf("there's no profile called `%s`, the bot's profiles are: `%s`", name, strings.Join(createProfileNames(), "`, `"))
+	}
+
+	expanded := append([]string{messageContentSlice[0]}, strings.Fields(profile)...)
+	return append(expanded, messageContentSlice[1:]...), nil
+}
+
+// The !create flags still taken in a channel with profiles, alongside --profile. The profile sets everything else.
+var profileChannelFlags = map[string]bool{"-alias": true, "--dry-run": true, "--override-budget": true}
+
+// Returns the flags in a !create that its channel won't take, because the channel's profiles must set them. Empty if
+// the channel has no profiles.
+func (c *command) refusedCreateFlags(messageContentSlice []string) []string {
+	if len(c.channel.Profiles) < 1 {
+		return nil
+	}
+
+	var refused []string
+	for i := 1; i < len(messageContentSlice); i++ {
+		argument := messageContentSlice[i]
+		switch {
+		case argument == "--profile" || argument == "-alias":
+			// Skips the value
+			i++
+		case profileChannelFlags[argument]:
+		default:
+			refused = append(refused, argument)
+
+			// Skips the refused flag's value, if it has one
+			if strings.HasPrefix(argument, "-") && i+1 < len(messageContentSlice) && !strings.HasPrefix(messageContentSlice[i+1], "-") {
+				i++
+			}
+		}
+	}
+
+	return refused
+}
+
+// Returns why the channel won't take a !create's flags, empty if it will. Only pass the flags the user typed, as the
+// ones the bot adds itself (i.e. !restore --new's -ami) aren't theirs to choose.
+func (c *command) createRefusal(messageContentSlice []string) string {
+	if _, profile := popFlagValue(messageContentSlice, "--profile"); !c.channel.AllowsProfile(profile) {
+		return fmt.Sprintf("Invalid `--profile`: instances created in this channel must use one of the profiles `%s`", strings.Join(c.channel.Profiles, "`, `"))
+	}
+	if refused := c.refusedCreateFlags(messageContentSlice); len(refused) > 0 {
+		return fmt.Sprintf("Invalid `!create`: instances created in this channel are set up by its profiles, which only take `-alias`, `--dry-run` and `--override-budget` alongside `--profile`. Remove `%s` and try again.", strings.Join(refused, "`, `"))
+	}
+
+	return ""
+}
+
+// Runs the pre-flight checks for a !create command, along with the channel, alias, budget and cost checks. passed is
+// true if the instance can be created, and dryRun if the command only asked for the checks.
+func (c *command) preflightCreate(content string) (message string, passed bool, dryRun bool) {
+	if refusal := c.createRefusal(strings.Fields(content)); refusal != "" {
+		return refusal, false, false
+	}
+
+	return c.preflightLaunch(content)
+}
+
+// Like preflightCreate, without checking the flags against the channel. Only for a !create the bot built itself, after
+// checking the flags the user typed with createRefusal.
+func (c *command) preflightLaunch(content string) (message string, passed bool, dryRun bool) {
+	createMu.Lock()
+	defer createMu.Unlock()
+	create.Reset()
+
+	messageContentSlice, err := expandProfile(strings.Fields(content))
+	if err != nil {
+		return fmt.Sprintf("Invalid `--profile`: %s", err), false, false
+	}
+
+	messageContentSlice, dryRun = popFlag(messageContentSlice, "--dry-run")
+	messageContentSlice, elasticIp := popFlag(messageContentSlice, "--eip")
+	messageContentSlice, create.Hibernate = popFlag(messageContentSlice, "--hibernate")
+	messageContentSlice, overrideBudget := popFlag(messageContentSlice, "--override-budget")
+	messageContentSlice, location, err := createLocation(messageContentSlice)
+	if err != nil {
+		return fmt.Sprintf("Invalid `--account`: %s. Configured accounts: `%s`", err, strings.Join(c.clients.AccountNames(), "`, `")), false, dryRun
+	}
+	create.UserRegion = location.Region
+	create.ChannelId = c.replyChannel()
+
+	messageContentSlice, err = resolveAmiAlias(messageContentSlice, location)
+	if err != nil {
+		return fmt.Sprintf("Invalid `--ami-alias`: %s", err), false, dryRun
+	}
+
+	// Validates everything up front so a bad parameter never gets as far as creating anything
+	message, passed = create.PreflightEc2Instance(messageContentSlice, createFlagArray, c.clients.EC2(location), c.clients.SSM(location))
+	message = fmt.Sprintf("Launching in `%s`\n", location) + message
+	if _, taken := inv.Find(create.UserAlias); passed && create.UserAlias != "" && taken {
+		message += fmt.Sprintf(":x: The alias `%s` is already used by another instance\n", create.UserAlias)
+		passed = false
+	}
+	if passed && !c.channel.Manages(create.UserAlias) {
+		message += fmt.Sprintf(":x: Instances created in this channel need an `-alias` it manages: `%s`\n", strings.Join(c.channel.Instances, "`, `"))
+		passed = false
+	}
+	if refusal := budgetRefusal([]inventory.Instance{{Alias: create.UserAlias}}, overrideBudget, c.caller); passed && refusal != "" {
+		message += refusal + "\n"
+		passed = false
+	}
+	if passed {
+		message += prices.HourlyCostLine(location.Region, create.UserInstanceType) + "\n"
+		if elasticIp {
+			message += fmt.Sprintf(":pushpin: An Elastic IP will be associated with the instance, adding `$%.3f/hour` (it's released when the instance is terminated)\n", eip.HourlyPrice)
+		}
+	}
+
+	return message, passed, dryRun
+}
+
+// Creates the instance a !create command describes, once its pre-flight checks have passed, sending each update to
+// reply. Returns the new instance's ID, empty if it wasn't created.
+func (c *command) createInstance(content string, reply func(message string)) string {
+	createMu.Lock()
+	defer createMu.Unlock()
+	create.Reset()
+
+	messageContentSlice, err := expandProfile(strings.Fields(content))
+	if err != nil {
+		c.log.Println("Error expanding --profile:", err)
+		reply(fmt.Sprintf("**ERROR**: Your EC2 instance wasn't created, its `--profile` is no longer valid: %s", err))
+		return ""
+	}
+
+	messageContentSlice, _ = popFlag(messageContentSlice, "--dry-run")
+	messageContentSlice, elasticIp := popFlag(messageContentSlice, "--eip")
+	messageContentSlice, create.Hibernate = popFlag(messageContentSlice, "--hibernate")
+	messageContentSlice, _ = popFlag(messageContentSlice, "--override-budget")
+	messageContentSlice, location, err := createLocation(messageContentSlice)
+	if err != nil {
+		c.log.Println("Error reading !create location:", err)
+		reply(fmt.Sprintf("**ERROR**: Your EC2 instance wasn't created, its `--account` is no longer valid: %s", err))
+		return ""
+	}
+	create.UserRegion = location.Region
+	create.ChannelId = c.replyChannel()
+
+	messageContentSlice, err = resolveAmiAlias(messageContentSlice, location)
+	if err != nil {
+		c.log.Println("Error resolving --ami-alias:", err)
+		reply(fmt.Sprintf("**ERROR**: Your EC2 instance wasn't created, its `--ami-alias` couldn't be resolved: %s", err))
+		return ""
+	}
+
+	message, instanceId, _, _, _, _, _ := create.CreateEc2Instance(messageContentSlice, createFlagArray, c.clients.EC2(location), c.clients.SSM(location))
+	reply(message)
+	if instanceId == "" {
+		return ""
+	}
+
+	addCreatedInstance(instanceId, location)
+	if instance, found := inv.Find(instanceId); found {
+		c.target(instance)
+	}
+
+	if elasticIp {
+		instance, _ := inv.Find(instanceId)
+		publicIp, err := eip.Attach(c.clients.EC2(location), instance)
+		if err != nil {
+			c.log.Printf("Error attaching an Elastic IP to %s: %v", instanceId, err)
+			reply("**ERROR**: There was an error attaching an Elastic IP to your new EC2 instance, try `!eip attach` once it's running. Please see your bot's error logs for more information.")
+		} else {
+			reply(fmt.Sprintf(":pushpin: Your EC2 instance's address is the Elastic IP `%s`", publicIp))
+		}
+	}
+
+	announceRunning(c.session, c.replyChannel(), instanceId)
+	return instanceId
+}
+
+// Summarizes what terminating the targeted instances would do, or why they can't be. ok is true if they can be.
+func (c *command) terminationSummary(targets []inventory.Instance, finalSnapshot bool) (message string, ok bool) {
+	c.target(targets...)
+	if len(targets) < 1 {
+		return noInstancesMessage, false
+	}
+
+	summary, refusals, err := checkTermination(targets)
+	if err != nil {
+		c.log.Println("Error checking instances before terminating:", err)
+		return "**ERROR**: There was an error checking your EC2 instances before terminating them. Please see your bot's error logs for more information.", false
+	}
+	if len(refusals) > 0 {
+		return "**Refusing to terminate:**\n" + strings.Join(refusals, "\n") + fmt.Sprintf("\nTurn off termination protection (or remove the `%s` tag) and try again, or leave these instances out with `-i`.", terminate.ProtectedTag), false
+	}
+
+	message = "**These instances will be terminated:**\n" + summary + "\n"
+	if finalSnapshot {
+		message += ":floppy_disk: A final backup of every volume will be taken first.\n"
+	} else {
+		message += ":warning: No final backup will be taken, add `--final-snapshot` to take one first.\n"
+	}
+
+	return message, true
+}
+
+// Terminates the targeted instances once the termination has been confirmed, sending each update to reply
+func (c *command) terminateInstances(targets []inventory.Instance, finalSnapshot bool, reply func(message string)) {
+	c.target(targets...)
+	// Checks again, in case protection was turned on since the summary was posted
+	_, refusals, err := checkTermination(targets)
+	if err != nil || len(refusals) > 0 {
+		c.log.Println("Instances can no longer be terminated:", err, refusals)
+		reply("**ERROR**: Your EC2 instances can no longer be terminated, send `!terminate` again to see why.")
+		return
+	}
+
+	if finalSnapshot {
+		reply("Taking final backups before terminating. This can take a while...")
+
+		err = takeFinalBackups(targets)
+		if err != nil {
+			c.log.Println("Error taking final backups:", err)
+			reply("**ERROR**: There was an error taking the final backups, so nothing has been terminated. Please see your bot's error logs for more information.")
+			return
+		}
+	}
+
+	reply(forEachLocation(targets, func(location awsclient.Location, instanceIds []string) string {
+		message, err := terminate.TerminateEc2Instance(instanceIds, c.clients.EC2(location))
+		if err == nil {
+			withdrawHostnames(instanceIds)
+			for _, instanceId := range instanceIds {
+				message += releaseElasticIps(instanceId, location)
+
+				err = inv.Remove(instanceId)
+				if err != nil {
+					c.log.Println("Error saving inventory:", err)
+				}
+			}
+		}
+		return message
+	}))
+}
+
+// The operations above, as the HTTP API calls them. Each request is handled as its own command.
+type apiOperations struct {
+	s *discordgo.Session
+}
+
+// Starts handling an API request as the named command, i.e. start. The caller must call finish once it's handled.
+func (o apiOperations) command(name string, c api.Caller) *command {
+	cmd := startCommand(o.s, c)
+	cmd.name = name
+	return cmd
+}
+
+func (o apiOperations) Find(name string) (inventory.Instance, bool) {
+	return inv.Find(name)
+}
+
+func (o apiOperations) All() []inventory.Instance {
+	return inv.All()
+}
+
+func (o apiOperations) Status(targets []inventory.Instance, c api.Caller) string {
+	cmd := o.command("status", c)
+	defer cmd.finish()
+
+	message := cmd.instanceStatuses(targets)
+	cmd.check(message)
+	return message
+}
+
+func (o apiOperations) Start(targets []inventory.Instance, overrideBudget bool, c api.Caller) (string, bool) {
+	cmd := o.command("start", c)
+	defer cmd.finish()
+
+	message, ok := cmd.startInstances(targets, overrideBudget)
+	cmd.check(message)
+	return message, ok
+}
+
+func (o apiOperations) Stop(targets []inventory.Instance, hibernate bool, c api.Caller) (string, bool) {
+	cmd := o.command("stop", c)
+	defer cmd.finish()
+
+	message, ok := cmd.stopInstances(targets, hibernate)
+	cmd.check(message)
+	return message, ok
+}
+
+// API keys are trusted to create from the bot's profiles without a one time password, so a passing pre-flight goes
+// straight on to creating the instance
+func (o apiOperations) Create(content string, c api.Caller) (string, string, bool) {
+	cmd := o.command("create", c)
+	cmd.arguments = strings.Fields(content)[1:]
+	defer cmd.finish()
+
+	message, passed, dryRun := cmd.preflightCreate(content)
+	cmd.check(message)
+	if !passed || dryRun {
+		return message, "", passed
+	}
+
+	var messages []string
+	instanceId := cmd.createInstance(content, func(message string) {
+		cmd.check(message)
+		messages = append(messages, message)
+		o.Post(message)
+	})
+
+	return strings.Join(messages, "\n"), instanceId, instanceId != ""
+}
+
+func (o apiOperations) TerminationSummary(targets []inventory.Instance, finalSnapshot bool, c api.Caller) (string, bool) {
+	cmd := o.command("terminate", c)
+	defer cmd.finish()
+
+	message, ok := cmd.terminationSummary(targets, finalSnapshot)
+	cmd.check(message)
+	return message, ok
+}
+
+func (o apiOperations) Terminate(targets []inventory.Instance, finalSnapshot bool, c api.Caller, reply func(message string)) {
+	cmd := o.command("terminate", c)
+	cmd.arguments = []string{"--confirm"}
+	defer cmd.finish()
+
+	cmd.terminateInstances(targets, finalSnapshot, func(message string) {
+		cmd.check(message)
+		reply(message)
+	})
+}
+
+func (o apiOperations) Post(message string, about ...inventory.Instance) {
+	postAbout(o.s, message, about...)
+}
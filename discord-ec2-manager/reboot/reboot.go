@@ -0,0 +1,39 @@
+package reboot
+
+import (
+	"context"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/inventory"
+)
+
+type EC2InstanceAPI interface {
+	RebootInstances(ctx context.Context,
+		params *ec2.RebootInstancesInput,
+		optFns ...func(*ec2.Options)) (*ec2.RebootInstancesOutput, error)
+}
+
+func RebootInstances(c context.Context, api EC2InstanceAPI, input *ec2.RebootInstancesInput) (*ec2.RebootInstancesOutput, error) {
+	return api.RebootInstances(c, input)
+}
+
+// Reboots the given instance, which keeps its public IP address and keeps being billed as running
+func RebootEc2Instance(instance inventory.Instance, client EC2InstanceAPI) (statusMessage string, err error) {
+	log.Println("Instance to reboot:", instance.InstanceId)
+
+	input := &ec2.RebootInstancesInput{
+		InstanceIds: []string{instance.InstanceId},
+	}
+
+	_, err = RebootInstances(context.TODO(), client, input)
+	if err != nil {
+		log.Println("Error rebooting EC2 instance:", err)
+		statusMessage = "**ERROR**: There was an error trying to reboot your EC2 instance. Please see your bot's error logs for more information."
+		return
+	}
+
+	statusMessage = ":arrows_counterclockwise: Rebooting `" + instance.Name() + "`..."
+	return
+}
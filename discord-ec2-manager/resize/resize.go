@@ -0,0 +1,249 @@
+package resize
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/inventory"
+)
+
+// How long stopping or starting the instance may take
+const waitTimeout = 10 * time.Minute
+
+// EC2ResizeAPI defines the interface for the EC2 functions used to change an instance's type.
+type EC2ResizeAPI interface {
+	DescribeInstances(ctx context.Context,
+		params *ec2.DescribeInstancesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error)
+
+	DescribeInstanceTypes(ctx context.Context,
+		params *ec2.DescribeInstanceTypesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceTypesOutput, error)
+
+	DescribeInstanceTypeOfferings(ctx context.Context,
+		params *ec2.DescribeInstanceTypeOfferingsInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceTypeOfferingsOutput, error)
+
+	StopInstances(ctx context.Context,
+		params *ec2.StopInstancesInput,
+		optFns ...func(*ec2.Options)) (*ec2.StopInstancesOutput, error)
+
+	StartInstances(ctx context.Context,
+		params *ec2.StartInstancesInput,
+		optFns ...func(*ec2.Options)) (*ec2.StartInstancesOutput, error)
+
+	ModifyInstanceAttribute(ctx context.Context,
+		params *ec2.ModifyInstanceAttributeInput,
+		optFns ...func(*ec2.Options)) (*ec2.ModifyInstanceAttributeOutput, error)
+}
+
+// The parts of an instance type players care about
+type Spec struct {
+	InstanceType  string
+	VCpus         int32
+	MemoryMiB     int64
+	Architectures []types.ArchitectureType
+	EnaSupport    types.EnaSupport
+}
+
+// Describes the spec, i.e. t3.large (2 vCPUs, 8 GiB)
+func (s Spec) String() string {
+	return fmt.Sprintf("`%s` (%d vCPUs, %g GiB)", s.InstanceType, s.VCpus, float64(s.MemoryMiB)/1024)
+}
+
+// Reports whether the instance type can run the given architecture
+func (s Spec) supports(architecture types.ArchitectureValues) bool {
+	for _, supported := range s.Architectures {
+		if string(supported) == string(architecture) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A checked resize, ready to go
+type Plan struct {
+	Instance         inventory.Instance
+	From             Spec
+	To               Spec
+	AvailabilityZone string
+
+	// Whether the instance has to be stopped first, in which case it's started again afterwards
+	WasRunning bool
+}
+
+// Looks up the specs of an instance type
+func describeType(api EC2ResizeAPI, instanceType string) (Spec, error) {
+	result, err := api.DescribeInstanceTypes(context.TODO(), &ec2.DescribeInstanceTypesInput{
+		InstanceTypes: []types.InstanceType{types.InstanceType(instanceType)},
+	})
+	if err != nil {
+		return Spec{}, err
+	}
+	if len(result.InstanceTypes) < 1 {
+		return Spec{}, fmt.Errorf("`%s` isn't an instance type", instanceType)
+	}
+
+	info := result.InstanceTypes[0]
+	spec := Spec{InstanceType: instanceType}
+	if info.VCpuInfo != nil {
+		spec.VCpus = aws.ToInt32(info.VCpuInfo.DefaultVCpus)
+	}
+	if info.MemoryInfo != nil {
+		spec.MemoryMiB = aws.ToInt64(info.MemoryInfo.SizeInMiB)
+	}
+	if info.ProcessorInfo != nil {
+		spec.Architectures = info.ProcessorInfo.SupportedArchitectures
+	}
+	if info.NetworkInfo != nil {
+		spec.EnaSupport = info.NetworkInfo.EnaSupport
+	}
+
+	return spec, nil
+}
+
+// Reports whether the instance type is offered in the availability zone
+func offeredIn(api EC2ResizeAPI, instanceType string, availabilityZone string) (bool, error) {
+	result, err := api.DescribeInstanceTypeOfferings(context.TODO(), &ec2.DescribeInstanceTypeOfferingsInput{
+		LocationType: types.LocationTypeAvailabilityZone,
+		Filters: []types.Filter{
+			{Name: aws.String("location"), Values: []string{availabilityZone}},
+			{Name: aws.String("instance-type"), Values: []string{instanceType}},
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return len(result.InstanceTypeOfferings) > 0, nil
+}
+
+// Checks the instance can be changed to the new type: the type must exist in the instance's availability zone, run its
+// architecture and (for Nitro types that need it) the instance must have ENA enabled. Errors are safe to show in Discord.
+func PlanResize(api EC2ResizeAPI, instance inventory.Instance, instanceType string) (Plan, error) {
+	result, err := api.DescribeInstances(context.TODO(), &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instance.InstanceId},
+	})
+	if err != nil {
+		log.Printf("Error describing %s: %v", instance.InstanceId, err)
+		return Plan{}, fmt.Errorf("**ERROR**: There was an error looking up `%s`. Please see your bot's error logs for more information.", instance.Name())
+	}
+	if len(result.Reservations) < 1 || len(result.Reservations[0].Instances) < 1 {
+		return Plan{}, fmt.Errorf("`%s` doesn't exist any more.", instance.Name())
+	}
+	ec2Instance := result.Reservations[0].Instances[0]
+
+	plan := Plan{Instance: instance}
+	if ec2Instance.Placement != nil {
+		plan.AvailabilityZone = aws.ToString(ec2Instance.Placement.AvailabilityZone)
+	}
+
+	switch ec2Instance.State.Name {
+	case types.InstanceStateNameRunning:
+		plan.WasRunning = true
+	case types.InstanceStateNameStopped:
+	default:
+		return Plan{}, fmt.Errorf("`%s` is %s, it can only be resized while it's running or stopped.", instance.Name(), ec2Instance.State.Name)
+	}
+
+	if string(ec2Instance.InstanceType) == instanceType {
+		return Plan{}, fmt.Errorf("`%s` is already a `%s`.", instance.Name(), instanceType)
+	}
+
+	plan.From, err = describeType(api, string(ec2Instance.InstanceType))
+	if err != nil {
+		log.Printf("Error describing instance type %s: %v", ec2Instance.InstanceType, err)
+		return Plan{}, fmt.Errorf("**ERROR**: There was an error looking up `%s`'s instance type. Please see your bot's error logs for more information.", instance.Name())
+	}
+
+	plan.To, err = describeType(api, instanceType)
+	if err != nil {
+		log.Printf("Error describing instance type %s: %v", instanceType, err)
+		return Plan{}, fmt.Errorf("`%s` isn't an instance type EC2 knows about in %s.", instanceType, instance.Region)
+	}
+
+	offered, err := offeredIn(api, instanceType, plan.AvailabilityZone)
+	if err != nil {
+		log.Printf("Error checking %s offerings in %s: %v", instanceType, plan.AvailabilityZone, err)
+		return Plan{}, fmt.Errorf("**ERROR**: There was an error checking whether `%s` is available. Please see your bot's error logs for more information.", instanceType)
+	}
+	if !offered {
+		return Plan{}, fmt.Errorf("`%s` isn't available in `%s`'s availability zone (`%s`).", instanceType, instance.Name(), plan.AvailabilityZone)
+	}
+
+	if !plan.To.supports(ec2Instance.Architecture) {
+		var architectures []string
+		for _, architecture := range plan.To.Architectures {
+			architectures = append(architectures, string(architecture))
+		}
+		return Plan{}, fmt.Errorf("`%s` runs %s, but `%s` is %s.", instanceType, strings.Join(architectures, "/"), instance.Name(), ec2Instance.Architecture)
+	}
+
+	if plan.To.EnaSupport == types.EnaSupportRequired && !aws.ToBool(ec2Instance.EnaSupport) {
+		return Plan{}, fmt.Errorf("`%s` needs ENA networking, which isn't enabled on `%s`.", instanceType, instance.Name())
+	}
+
+	return plan, nil
+}
+
+// Records that the resize changed the instance's state
+func recordTransition(inv *inventory.Inventory, instanceId string, state string) {
+	err := inv.RecordTransition(instanceId, state, time.Now())
+	if err != nil {
+		log.Println("Error saving inventory:", err)
+	}
+}
+
+// Carries out the plan: stops the instance if it's running, changes its type, then starts it again if it was running.
+// report is called as each step starts. The stop and start are recorded in the inventory, for !cost.
+func Resize(api EC2ResizeAPI, inv *inventory.Inventory, plan Plan, report func(step string)) error {
+	instanceIds := []string{plan.Instance.InstanceId}
+
+	if plan.WasRunning {
+		report("Stopping")
+		_, err := api.StopInstances(context.TODO(), &ec2.StopInstancesInput{InstanceIds: instanceIds})
+		if err != nil {
+			return fmt.Errorf("error stopping %s: %w", plan.Instance.InstanceId, err)
+		}
+		recordTransition(inv, plan.Instance.InstanceId, "stopped")
+
+		err = ec2.NewInstanceStoppedWaiter(api).Wait(context.TODO(), &ec2.DescribeInstancesInput{InstanceIds: instanceIds}, waitTimeout)
+		if err != nil {
+			return fmt.Errorf("error waiting for %s to stop: %w", plan.Instance.InstanceId, err)
+		}
+	}
+
+	report("Changing the instance type of")
+	_, err := api.ModifyInstanceAttribute(context.TODO(), &ec2.ModifyInstanceAttributeInput{
+		InstanceId:   aws.String(plan.Instance.InstanceId),
+		InstanceType: &types.AttributeValue{Value: aws.String(plan.To.InstanceType)},
+	})
+	if err != nil {
+		return fmt.Errorf("error changing the instance type of %s: %w", plan.Instance.InstanceId, err)
+	}
+	log.Printf("Changed %s from %s to %s", plan.Instance.InstanceId, plan.From.InstanceType, plan.To.InstanceType)
+
+	if plan.WasRunning {
+		report("Starting")
+		_, err = api.StartInstances(context.TODO(), &ec2.StartInstancesInput{InstanceIds: instanceIds})
+		if err != nil {
+			return fmt.Errorf("error starting %s: %w", plan.Instance.InstanceId, err)
+		}
+		recordTransition(inv, plan.Instance.InstanceId, "running")
+
+		err = ec2.NewInstanceRunningWaiter(api).Wait(context.TODO(), &ec2.DescribeInstancesInput{InstanceIds: instanceIds}, waitTimeout)
+		if err != nil {
+			return fmt.Errorf("error waiting for %s to start: %w", plan.Instance.InstanceId, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,452 @@
+package restore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/backup"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/inventory"
+)
+
+// How long to wait for volumes to attach and detach, and for a restored AMI to become available
+const (
+	volumeWait = 10 * time.Minute
+	imageWait  = 30 * time.Minute
+)
+
+// The root devices Amazon Linux and Ubuntu AMIs use, tried in order when the instance's own root device isn't known
+var commonRootDevices = []string{"/dev/xvda", "/dev/sda1"}
+
+// EC2RestoreAPI defines the interface for the EC2 functions used to restore an instance from a backup.
+type EC2RestoreAPI interface {
+	backup.EC2BackupAPI
+
+	DescribeImages(ctx context.Context,
+		params *ec2.DescribeImagesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error)
+
+	CreateVolume(ctx context.Context,
+		params *ec2.CreateVolumeInput,
+		optFns ...func(*ec2.Options)) (*ec2.CreateVolumeOutput, error)
+
+	AttachVolume(ctx context.Context,
+		params *ec2.AttachVolumeInput,
+		optFns ...func(*ec2.Options)) (*ec2.AttachVolumeOutput, error)
+
+	DetachVolume(ctx context.Context,
+		params *ec2.DetachVolumeInput,
+		optFns ...func(*ec2.Options)) (*ec2.DetachVolumeOutput, error)
+
+	ModifyInstanceAttribute(ctx context.Context,
+		params *ec2.ModifyInstanceAttributeInput,
+		optFns ...func(*ec2.Options)) (*ec2.ModifyInstanceAttributeOutput, error)
+
+	RegisterImage(ctx context.Context,
+		params *ec2.RegisterImageInput,
+		optFns ...func(*ec2.Options)) (*ec2.RegisterImageOutput, error)
+
+	CreateTags(ctx context.Context,
+		params *ec2.CreateTagsInput,
+		optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error)
+}
+
+// What a restore will do, worked out up front so it can be confirmed before anything changes
+type Plan struct {
+	Instance inventory.Instance
+	Snapshot types.Snapshot
+
+	// The device the restored volume becomes the root of, i.e. /dev/xvda
+	RootDevice string
+
+	// The root volume being swapped out, nil when launching a new instance instead
+	OldVolume *types.Volume
+
+	// Only used when launching a new instance
+	Architecture types.ArchitectureValues
+}
+
+// Returns the value of a tag, or an empty string if it isn't set
+func tagValue(tags []types.Tag, key string) string {
+	for _, tag := range tags {
+		if aws.ToString(tag.Key) == key {
+			return aws.ToString(tag.Value)
+		}
+	}
+
+	return ""
+}
+
+// Reports whether a snapshot is one of the bot's backups of the instance, going by the tags backup.Create gives them
+func backupOf(snapshot types.Snapshot, instance inventory.Instance) bool {
+	if tagValue(snapshot.Tags, backup.AliasTag) == instance.Name() {
+		return true
+	}
+
+	return instance.InstanceId != "" && tagValue(snapshot.Tags, backup.InstanceTag) == instance.InstanceId
+}
+
+// Finds the snapshot to restore: a snapshot ID, or "latest" for the newest completed backup of the instance's root
+// device. An empty rootDevice picks the backup's only snapshot, or the one taken of a common root device. A snapshot ID
+// must be one of the instance's own backups, so one channel can't restore another's.
+func FindSnapshot(api EC2RestoreAPI, instance inventory.Instance, spec string, rootDevice string) (types.Snapshot, error) {
+	alias := instance.Name()
+	if strings.HasPrefix(spec, "snap-") {
+		result, err := api.DescribeSnapshots(context.TODO(), &ec2.DescribeSnapshotsInput{
+			SnapshotIds: []string{spec},
+		})
+		if err != nil {
+			return types.Snapshot{}, err
+		}
+		if len(result.Snapshots) < 1 {
+			return types.Snapshot{}, fmt.Errorf("snapshot %s was not found", spec)
+		}
+		if result.Snapshots[0].State != types.SnapshotStateCompleted {
+			return types.Snapshot{}, fmt.Errorf("snapshot %s is still %s", spec, result.Snapshots[0].State)
+		}
+		if !backupOf(result.Snapshots[0], instance) {
+			return types.Snapshot{}, fmt.Errorf("snapshot %s isn't a backup of %s", spec, alias)
+		}
+
+		return result.Snapshots[0], nil
+	}
+
+	if spec != "latest" {
+		return types.Snapshot{}, fmt.Errorf("%q is not a snapshot ID or latest", spec)
+	}
+
+	backups, err := backup.List(api, alias)
+	if err != nil {
+		return types.Snapshot{}, err
+	}
+
+	for _, b := range backups {
+		if !b.Completed() {
+			continue
+		}
+
+		if rootDevice != "" {
+			if snapshot, ok := b.Snapshot(rootDevice); ok {
+				return snapshot, nil
+			}
+			continue
+		}
+
+		if len(b.Snapshots) == 1 {
+			return b.Snapshots[0], nil
+		}
+		for _, device := range commonRootDevices {
+			if snapshot, ok := b.Snapshot(device); ok {
+				return snapshot, nil
+			}
+		}
+	}
+
+	return types.Snapshot{}, fmt.Errorf("no completed backup of %s was found, take one with !backup", alias)
+}
+
+// Gets an instance, returning nil (without an error) if EC2 no longer knows about it
+func describeInstance(api EC2RestoreAPI, instanceId string) (*types.Instance, error) {
+	if instanceId == "" {
+		return nil, nil
+	}
+
+	result, err := api.DescribeInstances(context.TODO(), &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceId},
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidInstanceID.NotFound" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range result.Reservations {
+		if len(r.Instances) > 0 {
+			return &r.Instances[0], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Plans swapping a stopped instance's root volume for one created from a snapshot
+func PlanSwap(api EC2RestoreAPI, instance inventory.Instance, spec string) (Plan, error) {
+	plan := Plan{Instance: instance}
+
+	ec2Instance, err := describeInstance(api, instance.InstanceId)
+	if err != nil {
+		return plan, err
+	}
+	if ec2Instance == nil {
+		return plan, fmt.Errorf("instance %s no longer exists, use --new to launch a new instance from the backup instead", instance.InstanceId)
+	}
+	if ec2Instance.State.Name != types.InstanceStateNameStopped {
+		return plan, fmt.Errorf("instance %s is %s, stop it with !stop before restoring its root volume", instance.InstanceId, ec2Instance.State.Name)
+	}
+
+	plan.RootDevice = aws.ToString(ec2Instance.RootDeviceName)
+	var oldVolumeId string
+	for _, mapping := range ec2Instance.BlockDeviceMappings {
+		if aws.ToString(mapping.DeviceName) == plan.RootDevice && mapping.Ebs != nil {
+			oldVolumeId = aws.ToString(mapping.Ebs.VolumeId)
+		}
+	}
+	if oldVolumeId == "" {
+		return plan, fmt.Errorf("instance %s has no EBS root volume at %s", instance.InstanceId, plan.RootDevice)
+	}
+
+	volumes, err := api.DescribeVolumes(context.TODO(), &ec2.DescribeVolumesInput{
+		VolumeIds: []string{oldVolumeId},
+	})
+	if err != nil {
+		return plan, err
+	}
+	if len(volumes.Volumes) < 1 {
+		return plan, fmt.Errorf("root volume %s was not found", oldVolumeId)
+	}
+	plan.OldVolume = &volumes.Volumes[0]
+
+	plan.Snapshot, err = FindSnapshot(api, instance, spec, plan.RootDevice)
+	return plan, err
+}
+
+// Plans registering an AMI from a snapshot and launching a new instance from it. The instance (which may have been
+// terminated) only needs an alias; its root device and architecture are reused if EC2 still knows about it.
+func PlanLaunch(api EC2RestoreAPI, instance inventory.Instance, spec string, architecture string) (Plan, error) {
+	plan := Plan{Instance: instance, Architecture: types.ArchitectureValuesX8664}
+
+	ec2Instance, err := describeInstance(api, instance.InstanceId)
+	if err != nil {
+		return plan, err
+	}
+	if ec2Instance != nil {
+		plan.RootDevice = aws.ToString(ec2Instance.RootDeviceName)
+		plan.Architecture = ec2Instance.Architecture
+	}
+	if architecture != "" {
+		plan.Architecture = types.ArchitectureValues(architecture)
+	}
+
+	plan.Snapshot, err = FindSnapshot(api, instance, spec, plan.RootDevice)
+	if err != nil {
+		return plan, err
+	}
+
+	if plan.RootDevice == "" {
+		plan.RootDevice = tagValue(plan.Snapshot.Tags, backup.DeviceTag)
+	}
+	if plan.RootDevice == "" {
+		plan.RootDevice = commonRootDevices[0]
+	}
+
+	return plan, nil
+}
+
+// Describes what the restore will replace, for the confirmation message
+func (p Plan) Summary() string {
+	snapshot := fmt.Sprintf("`%s` (taken %s, %d GiB)", aws.ToString(p.Snapshot.SnapshotId), aws.ToTime(p.Snapshot.StartTime).UTC().Format("2006-01-02 15:04 MST"), aws.ToInt32(p.Snapshot.VolumeSize))
+
+	if p.OldVolume != nil {
+		return fmt.Sprintf("**Restoring `%s` (`%s`) from %s**\n:warning: Its root volume `%s` (`%s`, %d GiB) will be detached and replaced by a new volume created from the snapshot. The old volume is kept, delete it once you're happy with the restore.",
+			p.Instance.Name(), p.Instance.InstanceId, snapshot,
+			aws.ToString(p.OldVolume.VolumeId), p.RootDevice, aws.ToInt32(p.OldVolume.Size))
+	}
+
+	return fmt.Sprintf("**Launching a new instance from %s**\nAn AMI (`%s`, root device `%s`) will be registered from the snapshot and a new instance launched from it in `%s`. Nothing existing is replaced.",
+		snapshot, p.Architecture, p.RootDevice, p.Instance.Location())
+}
+
+// Creates a volume from the plan's snapshot and swaps it in as the instance's root volume, returning the new volume's ID
+func Swap(api EC2RestoreAPI, plan Plan) (volumeId string, err error) {
+	if plan.OldVolume == nil {
+		return "", errors.New("the plan has no root volume to swap")
+	}
+
+	input := &ec2.CreateVolumeInput{
+		AvailabilityZone: plan.OldVolume.AvailabilityZone,
+		SnapshotId:       plan.Snapshot.SnapshotId,
+		VolumeType:       plan.OldVolume.VolumeType,
+		TagSpecifications: []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeVolume,
+				Tags: []types.Tag{
+					{Key: aws.String("Name"), Value: aws.String(plan.Instance.Name() + "-restored")},
+					{Key: aws.String(backup.AliasTag), Value: aws.String(plan.Instance.Name())},
+				},
+			},
+		},
+	}
+	if plan.OldVolume.VolumeType == types.VolumeTypeIo1 || plan.OldVolume.VolumeType == types.VolumeTypeIo2 {
+		input.Iops = plan.OldVolume.Iops
+	}
+
+	created, err := api.CreateVolume(context.TODO(), input)
+	if err != nil {
+		return "", err
+	}
+	volumeId = aws.ToString(created.VolumeId)
+	log.Printf("Created volume %s from %s for %s", volumeId, aws.ToString(plan.Snapshot.SnapshotId), plan.Instance.Name())
+
+	err = ec2.NewVolumeAvailableWaiter(api).Wait(context.TODO(), &ec2.DescribeVolumesInput{VolumeIds: []string{volumeId}}, volumeWait)
+	if err != nil {
+		return volumeId, err
+	}
+
+	_, err = api.DetachVolume(context.TODO(), &ec2.DetachVolumeInput{
+		VolumeId:   plan.OldVolume.VolumeId,
+		InstanceId: aws.String(plan.Instance.InstanceId),
+	})
+	if err != nil {
+		return volumeId, err
+	}
+
+	err = ec2.NewVolumeAvailableWaiter(api).Wait(context.TODO(), &ec2.DescribeVolumesInput{VolumeIds: []string{aws.ToString(plan.OldVolume.VolumeId)}}, volumeWait)
+	if err != nil {
+		return volumeId, err
+	}
+	log.Printf("Detached %s from %s", aws.ToString(plan.OldVolume.VolumeId), plan.Instance.Name())
+
+	err = attach(api, plan.Instance.InstanceId, volumeId, plan.RootDevice)
+	if err != nil {
+		log.Printf("Error attaching %s to %s, putting %s back: %v", volumeId, plan.Instance.Name(), aws.ToString(plan.OldVolume.VolumeId), err)
+		return volumeId, &AttachError{Err: err, Plan: plan, VolumeId: volumeId, Reattached: reattach(api, plan, volumeId)}
+	}
+
+	// Attached volumes outlive the instance by default, unlike the root volume they replace
+	_, err = api.ModifyInstanceAttribute(context.TODO(), &ec2.ModifyInstanceAttributeInput{
+		InstanceId: aws.String(plan.Instance.InstanceId),
+		BlockDeviceMappings: []types.InstanceBlockDeviceMappingSpecification{
+			{
+				DeviceName: aws.String(plan.RootDevice),
+				Ebs:        &types.EbsInstanceBlockDeviceSpecification{DeleteOnTermination: aws.Bool(true)},
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Error setting DeleteOnTermination on %s: %v", volumeId, err)
+	}
+
+	log.Printf("Attached %s to %s at %s", volumeId, plan.Instance.Name(), plan.RootDevice)
+	return volumeId, nil
+}
+
+// Attaches a volume to an instance at device and waits until it's in use
+func attach(api EC2RestoreAPI, instanceId string, volumeId string, device string) error {
+	_, err := api.AttachVolume(context.TODO(), &ec2.AttachVolumeInput{
+		Device:     aws.String(device),
+		InstanceId: aws.String(instanceId),
+		VolumeId:   aws.String(volumeId),
+	})
+	if err != nil {
+		return err
+	}
+
+	return ec2.NewVolumeInUseWaiter(api).Wait(context.TODO(), &ec2.DescribeVolumesInput{VolumeIds: []string{volumeId}}, volumeWait)
+}
+
+// Puts the instance's old root volume back after the restored one couldn't be attached, returning whether it worked
+func reattach(api EC2RestoreAPI, plan Plan, volumeId string) bool {
+	// The restored volume may have got as far as attaching, which would leave the device taken
+	_, err := api.DetachVolume(context.TODO(), &ec2.DetachVolumeInput{
+		VolumeId:   aws.String(volumeId),
+		InstanceId: aws.String(plan.Instance.InstanceId),
+	})
+	if err == nil {
+		err = ec2.NewVolumeAvailableWaiter(api).Wait(context.TODO(), &ec2.DescribeVolumesInput{VolumeIds: []string{volumeId}}, volumeWait)
+		if err != nil {
+			log.Printf("Error waiting for %s to detach from %s: %v", volumeId, plan.Instance.Name(), err)
+		}
+	}
+
+	err = attach(api, plan.Instance.InstanceId, aws.ToString(plan.OldVolume.VolumeId), plan.RootDevice)
+	if err != nil {
+		log.Printf("Error reattaching %s to %s: %v", aws.ToString(plan.OldVolume.VolumeId), plan.Instance.Name(), err)
+		return false
+	}
+
+	log.Printf("Reattached %s to %s at %s", aws.ToString(plan.OldVolume.VolumeId), plan.Instance.Name(), plan.RootDevice)
+	return true
+}
+
+// Returned by Swap when the restored volume couldn't be attached, after the old root volume was detached
+type AttachError struct {
+	Err  error
+	Plan Plan
+
+	// The restored volume, left unattached
+	VolumeId string
+
+	// Whether the old root volume was put back
+	Reattached bool
+}
+
+func (e *AttachError) Error() string {
+	return fmt.Sprintf("attaching %s: %v", e.VolumeId, e.Err)
+}
+
+func (e *AttachError) Unwrap() error {
+	return e.Err
+}
+
+// Says what state the instance was left in, for the reply
+func (e *AttachError) State() string {
+	oldVolumeId := aws.ToString(e.Plan.OldVolume.VolumeId)
+	if e.Reattached {
+		return fmt.Sprintf("`%s` still has its old root volume `%s` at `%s`, nothing was restored. The restored volume `%s` was left unattached, delete it or try again.", e.Plan.Instance.Name(), oldVolumeId, e.Plan.RootDevice, e.VolumeId)
+	}
+
+	return fmt.Sprintf(":warning: `%s` has no root volume and won't start. Its old root volume `%s` and the restored volume `%s` are both detached, attach one of them at `%s` before starting it.", e.Plan.Instance.Name(), oldVolumeId, e.VolumeId, e.Plan.RootDevice)
+}
+
+// Registers an AMI whose root volume comes from the plan's snapshot and waits for it to become available
+func RegisterImage(api EC2RestoreAPI, plan Plan) (imageId string, err error) {
+	name := fmt.Sprintf("%s-restore-%s", plan.Instance.Name(), time.Now().UTC().Format("20060102-150405"))
+
+	result, err := api.RegisterImage(context.TODO(), &ec2.RegisterImageInput{
+		Name:               aws.String(name),
+		Description:        aws.String(fmt.Sprintf("discord-ec2-manager restore of %s from %s", plan.Instance.Name(), aws.ToString(plan.Snapshot.SnapshotId))),
+		Architecture:       plan.Architecture,
+		RootDeviceName:     aws.String(plan.RootDevice),
+		VirtualizationType: aws.String("hvm"),
+		EnaSupport:         aws.Bool(true),
+		BlockDeviceMappings: []types.BlockDeviceMapping{
+			{
+				DeviceName: aws.String(plan.RootDevice),
+				Ebs: &types.EbsBlockDevice{
+					SnapshotId:          plan.Snapshot.SnapshotId,
+					DeleteOnTermination: aws.Bool(true),
+					VolumeType:          types.VolumeTypeGp3,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	imageId = aws.ToString(result.ImageId)
+	log.Printf("Registered %s (%s) from %s", imageId, name, aws.ToString(plan.Snapshot.SnapshotId))
+
+	_, err = api.CreateTags(context.TODO(), &ec2.CreateTagsInput{
+		Resources: []string{imageId},
+		Tags: []types.Tag{
+			{Key: aws.String("Name"), Value: aws.String(name)},
+			{Key: aws.String(backup.AliasTag), Value: aws.String(plan.Instance.Name())},
+		},
+	})
+	if err != nil {
+		log.Printf("Error tagging %s: %v", imageId, err)
+	}
+
+	err = ec2.NewImageAvailableWaiter(api).Wait(context.TODO(), &ec2.DescribeImagesInput{ImageIds: []string{imageId}}, imageWait)
+	return imageId, err
+}
@@ -0,0 +1,233 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/smithy-go"
+
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/botconfig"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/inventory"
+)
+
+const (
+	defaultDocument       = "AWS-RunShellScript"
+	defaultTimeoutSeconds = 60
+)
+
+// How often a command is checked while waiting for it to finish
+var pollInterval = 3 * time.Second
+
+// Arguments are pasted into shell commands, so they're limited to characters that can't break out of them
+var argPattern = regexp.MustCompile(`^[A-Za-z0-9_.@:-]{1,64}$`)
+
+// SSMCommandAPI defines the interface for the SSM functions used to run commands on instances.
+type SSMCommandAPI interface {
+	SendCommand(ctx context.Context,
+		params *ssm.SendCommandInput,
+		optFns ...func(*ssm.Options)) (*ssm.SendCommandOutput, error)
+
+	GetCommandInvocation(ctx context.Context,
+		params *ssm.GetCommandInvocationInput,
+		optFns ...func(*ssm.Options)) (*ssm.GetCommandInvocationOutput, error)
+}
+
+// How a command went
+type Result struct {
+	CommandId    string
+	Status       types.CommandInvocationStatus
+	ResponseCode int32
+	Stdout       string
+	Stderr       string
+}
+
+// Reports whether the command ran and exited 0
+func (r Result) Succeeded() bool {
+	return r.Status == types.CommandInvocationStatusSuccess
+}
+
+// Returns the names of the configured actions in alphabetical order
+func ActionNames(settings botconfig.Run) []string {
+	var names []string
+	for name := range settings.Actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Lists the configured actions for !run, i.e. `whitelist <player>` -- Adds a player to the whitelist
+func Describe(settings botconfig.Run) string {
+	var lines []string
+	for _, name := range ActionNames(settings) {
+		action := settings.Actions[name]
+		usage := name
+		for _, param := range action.Params {
+			usage += " <" + param + ">"
+		}
+
+		line := fmt.Sprintf("`%s`", usage)
+		if action.Description != "" {
+			line += " -- " + action.Description
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Reports whether a Discord user (with the given role IDs) may use an action. Admins may use every action, and
+// everyone else needs one of the action's roles, or one of run.roles if the action doesn't list any.
+func Allowed(cfg *botconfig.Config, action botconfig.Action, userId string, roleIds []string) bool {
+	if cfg.IsAdmin(userId) {
+		return true
+	}
+
+	allowedRoles := action.Roles
+	if len(allowedRoles) < 1 {
+		allowedRoles = cfg.Run.Roles
+	}
+	for _, allowed := range allowedRoles {
+		for _, roleId := range roleIds {
+			if roleId == allowed {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Fills in an action's command template for an instance, checking there's one safe argument per param
+func Render(action botconfig.Action, instance inventory.Instance, args []string) (string, error) {
+	if len(args) != len(action.Params) {
+		return "", fmt.Errorf("expected %d argument(s) (%s), got %d", len(action.Params), strings.Join(action.Params, ", "), len(args))
+	}
+
+	data := map[string]string{
+		"alias":       instance.Name(),
+		"instance_id": instance.InstanceId,
+	}
+	for i, param := range action.Params {
+		if !argPattern.MatchString(args[i]) {
+			return "", fmt.Errorf("`%s` can only contain letters, numbers and `_.@:-`", param)
+		}
+		data[param] = args[i]
+	}
+
+	commandTemplate, err := template.New("command").Option("missingkey=error").Parse(action.Command)
+	if err != nil {
+		return "", err
+	}
+
+	var command strings.Builder
+	err = commandTemplate.Execute(&command, data)
+	if err != nil {
+		return "", err
+	}
+
+	return command.String(), nil
+}
+
+// Sends a rendered command to the instance and waits for it to finish, for at most the action's timeout (plus a little
+// for SSM to deliver it)
+func Send(api SSMCommandAPI, instance inventory.Instance, action botconfig.Action, command string, requestedBy string) (Result, error) {
+	document := action.Document
+	if document == "" {
+		document = defaultDocument
+	}
+	timeoutSeconds := action.TimeoutSeconds
+	if timeoutSeconds <= 0 {
+		timeoutSeconds = defaultTimeoutSeconds
+	}
+
+	parameters := map[string][]string{"commands": {command}}
+	if document == defaultDocument || document == "AWS-RunPowerShellScript" {
+		parameters["executionTimeout"] = []string{strconv.Itoa(timeoutSeconds)}
+	}
+
+	// SSM comments are limited to 100 characters
+	comment := "discord-ec2-manager !run for " + requestedBy
+	if len(comment) > 100 {
+		comment = comment[:100]
+	}
+
+	sent, err := api.SendCommand(context.TODO(), &ssm.SendCommandInput{
+		DocumentName: aws.String(document),
+		InstanceIds:  []string{instance.InstanceId},
+		Parameters:   parameters,
+		Comment:      aws.String(comment),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidInstanceId" {
+			return Result{}, fmt.Errorf("`%s` isn't running or isn't managed by SSM (it needs the SSM agent and an instance profile allowing it)", instance.Name())
+		}
+		return Result{}, err
+	}
+
+	if sent.Command == nil {
+		return Result{}, errors.New("SSM didn't return the command it sent")
+	}
+
+	result := Result{CommandId: aws.ToString(sent.Command.CommandId)}
+	log.Printf("Sent command %s to %s for %s: %s", result.CommandId, instance.Name(), requestedBy, command)
+
+	deadline := time.Now().Add(time.Duration(timeoutSeconds)*time.Second + 2*time.Minute)
+	for time.Now().Before(deadline) {
+		time.Sleep(pollInterval)
+
+		invocation, err := api.GetCommandInvocation(context.TODO(), &ssm.GetCommandInvocationInput{
+			CommandId:  aws.String(result.CommandId),
+			InstanceId: aws.String(instance.InstanceId),
+		})
+		if err != nil {
+			// The invocation can take a moment to show up after the command is sent
+			var notYet *types.InvocationDoesNotExist
+			if errors.As(err, &notYet) {
+				continue
+			}
+			return result, err
+		}
+
+		result.Status = invocation.Status
+		result.ResponseCode = invocation.ResponseCode
+		result.Stdout = aws.ToString(invocation.StandardOutputContent)
+		result.Stderr = aws.ToString(invocation.StandardErrorContent)
+
+		switch invocation.Status {
+		case types.CommandInvocationStatusPending, types.CommandInvocationStatusInProgress, types.CommandInvocationStatusDelayed, types.CommandInvocationStatusCancelling:
+			continue
+		}
+
+		log.Printf("Command %s on %s finished: %s (exit code %d)", result.CommandId, instance.Name(), result.Status, result.ResponseCode)
+		return result, nil
+	}
+
+	return result, fmt.Errorf("command %s on %s didn't finish in time", result.CommandId, instance.Name())
+}
+
+// Combines a result's stdout and stderr for posting
+func Output(result Result) string {
+	output := strings.TrimRight(result.Stdout, "\n")
+	if stderr := strings.TrimRight(result.Stderr, "\n"); stderr != "" {
+		if output != "" {
+			output += "\n"
+		}
+		output += "--- stderr ---\n" + stderr
+	}
+
+	return output
+}
@@ -7,13 +7,6 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 )
 
-var (
-	instanceSpecified bool
-	instancesToStart  []string
-
-	input *ec2.StartInstancesInput
-)
-
 type EC2InstanceAPI interface {
 	StartEc2Instance(ctx context.Context,
 		params *ec2.StartInstancesInput,
@@ -25,39 +18,21 @@ func StartInstances(c context.Context, api EC2InstanceAPI, input *ec2.StartInsta
 	return api.StartEc2Instance(c, input)
 }
 
-func StartEc2Instance(messageContentSlice []string, instanceIds []string, client *ec2.Client) (statusMessage string, UserInstanceId string) {
+// Starts the given instances, which must all live in the client's region
+func StartEc2Instance(instanceIds []string, client *ec2.Client) (statusMessage string, err error) {
 	if len(instanceIds) < 1 {
-		log.Println("There are no instances stored in the bot's memory. Use !create to add an instance to the instanceIds slice.")
+		log.Println("There are no instances stored in the bot's memory. Use !create to add an instance to the inventory.")
 		statusMessage = "There was an error fetching the status of your EC2 instance, please check the bot's error logs for more information."
 		return
 	}
 
-	log.Println("Instances in Memory for !start:", instanceIds)
+	log.Println("Instances to start:", instanceIds)
 
-	if len(messageContentSlice) > 1 {
-		for i := 1; i < len(messageContentSlice); i += 2 {
-			switch messageContentSlice[i] {
-			case "-i":
-				instanceSpecified = true
-				if messageContentSlice[i+1] != "-i" {
-					UserInstanceId = messageContentSlice[i+1]
-					instancesToStart = append(instancesToStart, UserInstanceId)
-				}
-			}
-		}
-	}
-
-	if instanceSpecified {
-		input = &ec2.StartInstancesInput{
-			InstanceIds: instancesToStart,
-		}
-	} else {
-		input = &ec2.StartInstancesInput{
-			InstanceIds: instanceIds,
-		}
+	input := &ec2.StartInstancesInput{
+		InstanceIds: instanceIds,
 	}
 
-	_, err := client.StartInstances(context.TODO(), input)
+	_, err = client.StartInstances(context.TODO(), input)
 	if err != nil {
 		log.Println("Error starting EC2 instance:", err)
 		statusMessage = "**ERROR**: There was an error trying to start your EC2 instance. Please see your bot's error logs for more information."
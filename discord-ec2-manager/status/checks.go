@@ -0,0 +1,132 @@
+package status
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+// EC2InstanceStatusAPI defines the interface for the DescribeInstanceStatus function.
+type EC2InstanceStatusAPI interface {
+	DescribeInstanceStatus(ctx context.Context,
+		params *ec2.DescribeInstanceStatusInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceStatusOutput, error)
+}
+
+// The most instance IDs DescribeInstanceStatus takes in one call
+const maxStatusIds = 100
+
+// Returns the status checks and scheduled events of the running instances among instanceIds, by instance ID. Stopped
+// instances have neither, and instances that no longer exist are skipped, so both are left out.
+func RunningStatuses(api EC2InstanceStatusAPI, instanceIds []string) (map[string]types.InstanceStatus, error) {
+	statuses := map[string]types.InstanceStatus{}
+	for start := 0; start < len(instanceIds); start += maxStatusIds {
+		end := start + maxStatusIds
+		if end > len(instanceIds) {
+			end = len(instanceIds)
+		}
+		batch := instanceIds[start:end]
+
+		err := describeStatuses(api, batch, statuses)
+		if notFound(err) {
+			// One instance that no longer exists fails the whole call, so they're asked about one at a time instead
+			for _, instanceId := range batch {
+				err = describeStatuses(api, []string{instanceId}, statuses)
+				if err != nil && !notFound(err) {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return statuses, nil
+}
+
+// Adds the statuses of the running instances among instanceIds to statuses
+func describeStatuses(api EC2InstanceStatusAPI, instanceIds []string, statuses map[string]types.InstanceStatus) error {
+	paginator := ec2.NewDescribeInstanceStatusPaginator(api, &ec2.DescribeInstanceStatusInput{InstanceIds: instanceIds})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return err
+		}
+
+		for _, status := range page.InstanceStatuses {
+			statuses[aws.ToString(status.InstanceId)] = status
+		}
+	}
+
+	return nil
+}
+
+// Reports whether EC2 said an instance doesn't exist
+func notFound(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidInstanceID.NotFound"
+}
+
+// Returns the status of a status check, i.e. ok or impaired
+func checkStatus(summary *types.InstanceStatusSummary) types.SummaryStatus {
+	if summary == nil {
+		return types.SummaryStatusNotApplicable
+	}
+
+	return summary.Status
+}
+
+// Returns the names of the instance's impaired status checks (system and/or instance), empty if it's healthy
+func Impaired(status types.InstanceStatus) []string {
+	var impaired []string
+	if checkStatus(status.SystemStatus) == types.SummaryStatusImpaired {
+		impaired = append(impaired, "system")
+	}
+	if checkStatus(status.InstanceStatus) == types.SummaryStatusImpaired {
+		impaired = append(impaired, "instance")
+	}
+
+	return impaired
+}
+
+// Describes the instance's reachability checks for !status, i.e. Status Checks: system `ok`, instance `impaired`
+func ChecksLine(status types.InstanceStatus) string {
+	return fmt.Sprintf("Status Checks: system `%s`, instance `%s`", checkStatus(status.SystemStatus), checkStatus(status.InstanceStatus))
+}
+
+// Returns the instance's scheduled events that haven't finished yet. Finished events stay listed for a while, marked
+// in their description.
+func ActiveEvents(status types.InstanceStatus) []types.InstanceStatusEvent {
+	var active []types.InstanceStatusEvent
+	for _, event := range status.Events {
+		description := aws.ToString(event.Description)
+		if strings.HasPrefix(description, "[Completed]") || strings.HasPrefix(description, "[Canceled]") {
+			continue
+		}
+		active = append(active, event)
+	}
+
+	return active
+}
+
+// Describes a scheduled event, i.e. `system-reboot` after 2023-01-12 21:00 UTC: The instance is scheduled for a reboot
+func DescribeEvent(code string, notBefore time.Time, description string) string {
+	line := fmt.Sprintf("`%s`", code)
+	if !notBefore.IsZero() {
+		line += " after " + notBefore.UTC().Format("2006-01-02 15:04 MST")
+	}
+	if description != "" {
+		line += ": " + description
+	}
+
+	return line
+}
@@ -0,0 +1,130 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/awsclient"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/inventory"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/metrics"
+)
+
+// The most of a service check's response that's read looking for a player count
+const maxCheckBody = 64 * 1024
+
+// Service checks give up after this long, so a hung service can't hold up !status or the poller
+var serviceClient = &http.Client{Timeout: 10 * time.Second}
+
+// The result of checking the service on an instance's -scp port
+type ServiceCheck struct {
+	Active bool
+
+	// The players online, if the check's response reports them (see parsePlayers)
+	Players    int
+	HasPlayers bool
+}
+
+// Checks the service on an instance, recording the result (and any player count) in the bot's metrics
+func CheckService(instanceId string, publicIp string, ServiceCheckPort string) (ServiceCheck, error) {
+	started := time.Now()
+	resp, err := serviceClient.Get(fmt.Sprint("http://", publicIp, ":", ServiceCheckPort))
+	metrics.HealthCheckDuration.Observe(time.Since(started).Seconds(), instanceId)
+	if err != nil {
+		metrics.HealthChecks.Inc(instanceId, "error")
+		metrics.Players.Delete(instanceId)
+		return ServiceCheck{}, err
+	}
+	defer resp.Body.Close()
+
+	if !strings.Contains(resp.Status, "200") {
+		metrics.HealthChecks.Inc(instanceId, "inactive")
+		metrics.Players.Delete(instanceId)
+		return ServiceCheck{}, nil
+	}
+
+	metrics.HealthChecks.Inc(instanceId, "active")
+	check := ServiceCheck{Active: true}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxCheckBody))
+	if err == nil {
+		check.Players, check.HasPlayers = parsePlayers(body)
+	}
+
+	if check.HasPlayers {
+		metrics.Players.Set(float64(check.Players), instanceId)
+	} else {
+		metrics.Players.Delete(instanceId)
+	}
+
+	return check, nil
+}
+
+// Reads a player count from a service check's response: a JSON body with a numeric "players", or with
+// "players": {"online": n} like Minecraft server status APIs. ok is false if the body has neither.
+func parsePlayers(body []byte) (count int, ok bool) {
+	var response struct {
+		Players json.RawMessage `json:"players"`
+	}
+	if json.Unmarshal(body, &response) != nil || response.Players == nil {
+		return 0, false
+	}
+
+	if json.Unmarshal(response.Players, &count) == nil {
+		return count, true
+	}
+
+	var players struct {
+		Online *int `json:"online"`
+	}
+	if json.Unmarshal(response.Players, &players) == nil && players.Online != nil {
+		return *players.Online, true
+	}
+
+	return 0, false
+}
+
+// Runs the service check on every running instance in the inventory each interval, so the health check and player
+// count metrics stay up to date between !status commands. Runs until the bot exits.
+func PollServices(inv *inventory.Inventory, clients *awsclient.Pool, ServiceCheckPort string, interval time.Duration) {
+	log.Println("Checking services for metrics every", interval)
+
+	for {
+		locations := inventory.ByLocation(inv.All())
+		for _, location := range inventory.SortedLocations(locations) {
+			output, err := GetInstances(context.TODO(), clients.EC2(location), &ec2.DescribeInstancesInput{
+				Filters: []types.Filter{{Name: aws.String("instance-id"), Values: locations[location]}},
+			})
+			if err != nil {
+				log.Printf("Error checking services in %s: %v", location, err)
+				continue
+			}
+
+			for _, r := range output.Reservations {
+				for _, i := range r.Instances {
+					instanceId := aws.ToString(i.InstanceId)
+					if i.State == nil || i.State.Name != types.InstanceStateNameRunning || i.PublicIpAddress == nil {
+						metrics.Players.Delete(instanceId)
+						continue
+					}
+
+					_, err := CheckService(instanceId, aws.ToString(i.PublicIpAddress), ServiceCheckPort)
+					if err != nil {
+						log.Printf("Error checking the service on %s: %v", instanceId, err)
+					}
+				}
+			}
+		}
+
+		time.Sleep(interval)
+	}
+}
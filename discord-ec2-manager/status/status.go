@@ -4,10 +4,11 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"net/http"
 	"strings"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 )
 
 var (
@@ -20,11 +21,6 @@ var (
 
 	UserServiceName string
 	UserServicePort string
-
-	instanceSpecified      bool
-	instancesToCheckStatus []string
-
-	input *ec2.DescribeInstancesInput
 )
 
 type EC2InstanceAPI interface {
@@ -38,40 +34,51 @@ func GetInstances(c context.Context, api EC2InstanceAPI, input *ec2.DescribeInst
 	return api.DescribeInstances(c, input)
 }
 
-func GetEc2InstanceStatus(messageContentSlice []string, instanceIds []string, UserTagKey string, UserTagValue string, ServiceCheckPort string, UserServiceName string, UserServicePort string, client *ec2.Client) (statusMessage string) {
-
-	if len(instanceIds) < 1 {
-		log.Println("There are no instances stored in the bot's memory. Use !create to add an instance to the instanceIds slice.")
-		statusMessage = "There was an error fetching the status of your EC2 instance, please check the bot's error logs for more information."
+// Builds the status message for a single instance, checking its service if it's running
+func instanceStatus(i types.Instance, location string, ServiceCheckPort string, UserServiceName string, UserServicePort string) (statusMessage string) {
+	if i.State.Name != types.InstanceStateNameRunning || i.PublicIpAddress == nil {
+		statusMessage = fmt.Sprintf("Instance ID: `%s`\nInstance Location: `%s`\nInstance State: `%v`", *i.InstanceId, location, *i.State)
 		return
 	}
 
-	log.Println("Instances in Memory for !status:", instanceIds)
+	if ServiceCheckPort == "" {
+		statusMessage = fmt.Sprintf("Instance ID: `%s`\nInstance Location: `%s`\nInstance IP: `%s`\nInstance State: `%v`", *i.InstanceId, location, *i.PublicIpAddress, *i.State)
+		return
+	}
 
-	if len(messageContentSlice) > 1 {
-		for i := 1; i < len(messageContentSlice); i += 2 {
-			switch messageContentSlice[i] {
-			case "-i":
-				instanceSpecified = true
-				if messageContentSlice[i+1] != "-i" {
-					UserInstanceId = messageContentSlice[i+1]
-					instancesToCheckStatus = append(instancesToCheckStatus, UserInstanceId)
-				}
-			}
-		}
+	check, err := CheckService(*i.InstanceId, *i.PublicIpAddress, ServiceCheckPort)
+	if err != nil {
+		log.Println("Error sending GET request to instance: ", err)
+		statusMessage = fmt.Sprintf("Instance ID: `%s`\nInstance Location: `%s`\nInstance IP: `%s`\nInstance State: `%v`\n`%s` status cannot be checked right now. See your bot's error logs for more information.", *i.InstanceId, location, *i.PublicIpAddress, *i.State, UserServiceName)
+		return
 	}
 
-	if instanceSpecified {
-		input = &ec2.DescribeInstancesInput{
-			InstanceIds: instancesToCheckStatus,
+	if check.Active {
+		statusMessage = fmt.Sprintf("Instance ID: `%s`\nInstance Location: `%s`\nInstance IP: `%s`\nInstance State: `%v`\n`%s` is currently `%s` on port `%s`", *i.InstanceId, location, *i.PublicIpAddress, *i.State, UserServiceName, "active", UserServicePort)
+		if check.HasPlayers {
+			statusMessage += fmt.Sprintf(" with `%d` players online", check.Players)
 		}
 	} else {
-		input = &ec2.DescribeInstancesInput{
-			InstanceIds: instanceIds,
-		}
+		statusMessage = fmt.Sprintf("Instance ID: `%s`\nInstance Location: `%s`\nInstance IP: `%s`\nInstance State: `%v`\n`%s` is currently `%s` on port `%s`", *i.InstanceId, location, *i.PublicIpAddress, *i.State, UserServiceName, "inactive", UserServicePort)
 	}
+	return
+}
+
+// Gets the status of the given instances, which must all live in location (the client's account and region, i.e. prod/us-east-1)
+// hostnames maps instance IDs to the hostnames published for them by dynamic DNS.
+func GetEc2InstanceStatus(instanceIds []string, location string, hostnames map[string]string, UserTagKey string, UserTagValue string, ServiceCheckPort string, UserServiceName string, UserServicePort string, client *ec2.Client) (statusMessage string) {
 
-	log.Printf("Getting status using %v as input", input.InstanceIds)
+	if len(instanceIds) < 1 {
+		log.Println("There are no instances stored in the bot's memory. Use !create to add an instance to the inventory.")
+		statusMessage = "There was an error fetching the status of your EC2 instance, please check the bot's error logs for more information."
+		return
+	}
+
+	input := &ec2.DescribeInstancesInput{
+		InstanceIds: instanceIds,
+	}
+
+	log.Printf("Getting status using %v as input in %s", input.InstanceIds, location)
 	status, err := GetInstances(context.TODO(), client, input)
 
 	if err != nil {
@@ -80,40 +87,48 @@ func GetEc2InstanceStatus(messageContentSlice []string, instanceIds []string, Us
 		return
 	}
 
+	// Elastic IPs keep the instance's address the same across !stop and !start, so they're worth pointing out
+	elasticIps := map[string]string{}
+	addresses, err := client.DescribeAddresses(context.TODO(), &ec2.DescribeAddressesInput{
+		Filters: []types.Filter{
+			{Name: aws.String("instance-id"), Values: instanceIds},
+		},
+	})
+	if err != nil {
+		log.Println("Error getting Elastic IPs:", err)
+	} else {
+		for _, address := range addresses.Addresses {
+			elasticIps[aws.ToString(address.InstanceId)] = aws.ToString(address.PublicIp)
+		}
+	}
+
+	// Reachability checks and scheduled maintenance only exist for running instances
+	runningStatuses, err := RunningStatuses(client, instanceIds)
+	if err != nil {
+		log.Println("Error getting status checks:", err)
+	}
+
+	var instanceMessages []string
 	for _, r := range status.Reservations {
 		for _, i := range r.Instances {
-			instanceState := fmt.Sprintf("%v", *i.State)
-			if strings.Contains(instanceState, "running") {
-				if ServiceCheckPort == "" {
-					statusMessage = fmt.Sprintf("Instance ID: `%s`\nInstance IP: `%s`\nInstance State: `%v`", *i.InstanceId, *i.PublicIpAddress, *i.State)
-					return
-				} else {
-					instanceIpAndPort := fmt.Sprint("http://", *i.PublicIpAddress, ":", ServiceCheckPort)
-
-					resp, err := http.Get(instanceIpAndPort)
-					if err != nil {
-						log.Println("Error sending GET request to instance: ", err)
-						statusMessage = fmt.Sprintf("Instance ID: `%s`\nInstance IP: `%s`\nInstance State: `%v`\n`%s` status cannot be checked right now. See your bot's error logs for more information.", *i.InstanceId, *i.PublicIpAddress, *i.State, UserServiceName, "inactive", UserServicePort)
-						return
-					}
-
-					respStatus := string(resp.Status)
-
-					if strings.Contains(respStatus, "200") {
-						statusMessage = fmt.Sprintf("Instance ID: `%s`\nInstance IP: `%s`\nInstance State: `%v`\n`%s` is currently `%s` on port `%s`", *i.InstanceId, *i.PublicIpAddress, *i.State, UserServiceName, "active", UserServicePort)
-						return
-					} else {
-						statusMessage = fmt.Sprintf("Instance ID: `%s`\nInstance IP: `%s`\nInstance State: `%v`\n`%s` is currently `%s` on port `%s`", *i.InstanceId, *i.PublicIpAddress, *i.State, UserServiceName, "inactive", UserServicePort)
-						return
-					}
+			instanceMessage := instanceStatus(i, location, ServiceCheckPort, UserServiceName, UserServicePort)
+			if runningStatus, ok := runningStatuses[aws.ToString(i.InstanceId)]; ok {
+				instanceMessage += "\n" + ChecksLine(runningStatus)
+				for _, event := range ActiveEvents(runningStatus) {
+					instanceMessage += "\nScheduled Event: " + DescribeEvent(string(event.Code), aws.ToTime(event.NotBefore), aws.ToString(event.Description))
 				}
-
-			} else {
-				statusMessage = fmt.Sprintf("Instance ID: `%s`\nInstance State: `%v`", *i.InstanceId, *i.State)
-				return
 			}
+			if elasticIp, ok := elasticIps[aws.ToString(i.InstanceId)]; ok {
+				instanceMessage += fmt.Sprintf("\nElastic IP: `%s` (stays the same when the instance is stopped and started)", elasticIp)
+			}
+			if hostname, ok := hostnames[aws.ToString(i.InstanceId)]; ok {
+				instanceMessage += fmt.Sprintf("\nHostname: `%s`", hostname)
+			}
+			instanceMessages = append(instanceMessages, instanceMessage)
 		}
 	}
+
+	statusMessage = strings.Join(instanceMessages, "\n\n")
 	log.Println(statusMessage)
 	return
 }
@@ -0,0 +1,72 @@
+package stop
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+// Hibernates the given instances, which must all live in the client's region. Instances that weren't launched with
+// hibernation enabled (or that EC2 refuses to hibernate) are stopped normally instead, with an explanation.
+func HibernateEc2Instance(instanceIds []string, client *ec2.Client) (statusMessage string, err error) {
+	if len(instanceIds) < 1 {
+		log.Println("There are no instances stored in the bot's memory. Use !create to add an instance to the inventory.")
+		statusMessage = "There was an error fetching the status of your EC2 instance, please check the bot's error logs for more information."
+		return
+	}
+
+	log.Println("Instances to hibernate:", instanceIds)
+
+	result, err := client.DescribeInstances(context.TODO(), &ec2.DescribeInstancesInput{
+		InstanceIds: instanceIds,
+	})
+	if err != nil {
+		log.Println("Error describing EC2 instances:", err)
+		statusMessage = "**ERROR**: There was an error trying to stop your EC2 instance. Please see your bot's error logs for more information."
+		return
+	}
+
+	var hibernate, notConfigured []string
+	for _, r := range result.Reservations {
+		for _, i := range r.Instances {
+			if i.HibernationOptions != nil && aws.ToBool(i.HibernationOptions.Configured) {
+				hibernate = append(hibernate, aws.ToString(i.InstanceId))
+			} else {
+				notConfigured = append(notConfigured, aws.ToString(i.InstanceId))
+			}
+		}
+	}
+
+	var messages []string
+	stop := notConfigured
+	if len(notConfigured) > 0 {
+		messages = append(messages, fmt.Sprintf("`%s` wasn't created with `--hibernate`, so it's being stopped normally instead.", strings.Join(notConfigured, "`, `")))
+	}
+
+	if len(hibernate) > 0 {
+		_, err = client.StopInstances(context.TODO(), &ec2.StopInstancesInput{
+			InstanceIds: hibernate,
+			Hibernate:   aws.Bool(true),
+		})
+		if err != nil {
+			// i.e. the instance was only just launched, and its OS isn't ready to hibernate yet
+			log.Println("Error hibernating EC2 instance, stopping it instead:", err)
+			messages = append(messages, fmt.Sprintf("EC2 couldn't hibernate `%s`, so it's being stopped normally instead. Please see your bot's error logs for more information.", strings.Join(hibernate, "`, `")))
+			stop = append(stop, hibernate...)
+		} else {
+			messages = append(messages, "Hibernating EC2 instance...")
+		}
+	}
+
+	if len(stop) > 0 {
+		statusMessage, err = StopEc2Instance(stop, client)
+		messages = append(messages, statusMessage)
+	}
+
+	statusMessage = strings.Join(messages, "\n")
+	return
+}
@@ -0,0 +1,130 @@
+package terminate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/inventory"
+)
+
+// Instances tagged with this key (with any value but "false") are never terminated by the bot
+const ProtectedTag = "protected"
+
+// EC2SafetyAPI defines the interface for the EC2 functions used to check instances before they're terminated.
+type EC2SafetyAPI interface {
+	DescribeInstances(ctx context.Context,
+		params *ec2.DescribeInstancesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error)
+
+	DescribeInstanceAttribute(ctx context.Context,
+		params *ec2.DescribeInstanceAttributeInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceAttributeOutput, error)
+
+	DescribeVolumes(ctx context.Context,
+		params *ec2.DescribeVolumesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeVolumesOutput, error)
+}
+
+// Whether the instance's tags mark it as protected
+func protectedByTag(tags []types.Tag) bool {
+	for _, tag := range tags {
+		if strings.EqualFold(aws.ToString(tag.Key), ProtectedTag) && !strings.EqualFold(aws.ToString(tag.Value), "false") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Describes how long a running instance has been up, i.e. "running for 3h25m"
+func uptime(i types.Instance, now time.Time) string {
+	if i.State.Name != types.InstanceStateNameRunning || i.LaunchTime == nil {
+		return string(i.State.Name)
+	}
+
+	return "running for " + now.Sub(*i.LaunchTime).Round(time.Minute).String()
+}
+
+// Lists the instances' volumes as `vol-1234` (`/dev/xvda`, 8 GiB)
+func describeVolumes(api EC2SafetyAPI, i types.Instance) (string, error) {
+	var volumeIds []string
+	devices := map[string]string{}
+	for _, mapping := range i.BlockDeviceMappings {
+		if mapping.Ebs != nil {
+			volumeIds = append(volumeIds, aws.ToString(mapping.Ebs.VolumeId))
+			devices[aws.ToString(mapping.Ebs.VolumeId)] = aws.ToString(mapping.DeviceName)
+		}
+	}
+	if len(volumeIds) < 1 {
+		return "no EBS volumes", nil
+	}
+
+	result, err := api.DescribeVolumes(context.TODO(), &ec2.DescribeVolumesInput{
+		VolumeIds: volumeIds,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var volumes []string
+	for _, volume := range result.Volumes {
+		volumes = append(volumes, fmt.Sprintf("`%s` (`%s`, %d GiB)", aws.ToString(volume.VolumeId), devices[aws.ToString(volume.VolumeId)], aws.ToInt32(volume.Size)))
+	}
+
+	return strings.Join(volumes, ", "), nil
+}
+
+// Checks the instances can be terminated, returning a summary of exactly what will be removed and a reason for each
+// instance that mustn't be (termination protection or a protected tag)
+func CheckEc2Instances(api EC2SafetyAPI, instances []inventory.Instance) (summary string, refusals []string, err error) {
+	var instanceIds []string
+	names := map[string]string{}
+	for _, instance := range instances {
+		instanceIds = append(instanceIds, instance.InstanceId)
+		names[instance.InstanceId] = instance.Name()
+	}
+
+	result, err := api.DescribeInstances(context.TODO(), &ec2.DescribeInstancesInput{
+		InstanceIds: instanceIds,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	var lines []string
+	now := time.Now()
+	for _, r := range result.Reservations {
+		for _, i := range r.Instances {
+			instanceId := aws.ToString(i.InstanceId)
+
+			attribute, err := api.DescribeInstanceAttribute(context.TODO(), &ec2.DescribeInstanceAttributeInput{
+				InstanceId: i.InstanceId,
+				Attribute:  types.InstanceAttributeNameDisableApiTermination,
+			})
+			if err != nil {
+				return "", nil, err
+			}
+			if attribute.DisableApiTermination != nil && aws.ToBool(attribute.DisableApiTermination.Value) {
+				refusals = append(refusals, fmt.Sprintf(":shield: `%s` (`%s`) has termination protection turned on", names[instanceId], instanceId))
+			}
+			if protectedByTag(i.Tags) {
+				refusals = append(refusals, fmt.Sprintf(":shield: `%s` (`%s`) is tagged `%s`", names[instanceId], instanceId, ProtectedTag))
+			}
+
+			volumes, err := describeVolumes(api, i)
+			if err != nil {
+				return "", nil, err
+			}
+
+			lines = append(lines, fmt.Sprintf("• `%s` (`%s`, `%s`, %s): %s", names[instanceId], instanceId, i.InstanceType, uptime(i, now), volumes))
+		}
+	}
+
+	return strings.Join(lines, "\n"), refusals, nil
+}
@@ -7,13 +7,6 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 )
 
-var (
-	instanceSpecified bool
-	instancesForTermination    []string
-	UserInstanceId string
-	input *ec2.TerminateInstancesInput
-)
-
 type EC2InstanceAPI interface {
 	TerminateInstances(ctx context.Context,
 		params *ec2.TerminateInstancesInput,
@@ -25,39 +18,20 @@ func TerminateInstance(c context.Context, api EC2InstanceAPI, input *ec2.Termina
 	return api.TerminateInstances(c, input)
 }
 
-func TerminateEc2Instance(messageContentSlice []string, instanceIds []string, client *ec2.Client) (statusMessage string, TerminatedInstanceId string) {
-	if len(messageContentSlice) > 1 {
-		for i := 1; i < len(messageContentSlice); i += 2 {
-			switch messageContentSlice[i] {
-			case "-i":
-				instanceSpecified = true
-				if messageContentSlice[i+1] != "-i" {
-					UserInstanceId = messageContentSlice[i+1]
-					instancesForTermination = append(instancesForTermination, UserInstanceId)
-					break
-				}
-			}
-		}
-	}
-
-	if instanceSpecified {
-		input = &ec2.TerminateInstancesInput{
-			InstanceIds: instancesForTermination,
-		} 
-	} else {
-		input = &ec2.TerminateInstancesInput{
-			InstanceIds: instanceIds,
-		}
+// Terminates the given instances, which must all live in the client's region
+func TerminateEc2Instance(instanceIds []string, client *ec2.Client) (statusMessage string, err error) {
+	input := &ec2.TerminateInstancesInput{
+		InstanceIds: instanceIds,
 	}
 
-	_, err := TerminateInstance(context.TODO(), client, input)
+	_, err = TerminateInstance(context.TODO(), client, input)
 	if err != nil {
 		log.Println("Error terminating instance:", err)
-		statusMessage = "There was an error terminating your EC2 instance, please see the console logs for more info."
+		statusMessage = "**ERROR**: There was an error terminating your EC2 instance. Please see your bot's error logs for more information."
 		return
 	}
 
-	log.Println("OTP entered correctly, terminating EC2 instance")
-	statusMessage = "One time password entered correctly, terminating EC2 instance."
+	log.Println("Terminating EC2 instance(s):", instanceIds)
+	statusMessage = "Terminating EC2 instance."
 	return
 }
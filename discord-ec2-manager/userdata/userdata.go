@@ -0,0 +1,173 @@
+package userdata
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// EC2 rejects user data over 16 KB (before base64 encoding)
+const MaxSize = 16 * 1024
+
+// Template names may only be plain file names, so a Discord message can't reach outside the template directory
+var validTemplateName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Variables available to user data templates, i.e. {{ .Alias }} or {{ .Vars.world }}
+type Variables struct {
+	Alias            string
+	InstanceType     string
+	ServiceName      string
+	ServicePort      string
+	ServiceCheckPort string
+	ChannelId        string
+	Region           string
+
+	// Set per !create with --var key=value
+	Vars map[string]string
+}
+
+// Finds a template by name in dir, with or without a .tmpl extension
+func findTemplate(dir string, name string) (string, error) {
+	if !validTemplateName.MatchString(name) {
+		return "", fmt.Errorf("%q is not a valid template name, use the file name of a template in the user data directory", name)
+	}
+
+	for _, candidate := range []string{name, name + ".tmpl"} {
+		path := filepath.Join(dir, candidate)
+		info, err := os.Stat(path)
+		if err == nil && info.Mode().IsRegular() {
+			return path, nil
+		}
+	}
+
+	return "", fmt.Errorf("no user data template named %q", name)
+}
+
+// Renders a single named template
+func renderTemplate(dir string, name string, variables Variables) ([]byte, error) {
+	path, err := findTemplate(dir, name)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing template %q: %w", name, err)
+	}
+
+	var rendered bytes.Buffer
+	err = tmpl.Execute(&rendered, variables)
+	if err != nil {
+		return nil, fmt.Errorf("error rendering template %q: %w", name, err)
+	}
+
+	return rendered.Bytes(), nil
+}
+
+// Works out the cloud-init content type of a part from its first line
+func contentType(part []byte) string {
+	firstLine := string(part)
+	if i := strings.IndexByte(firstLine, '\n'); i >= 0 {
+		firstLine = firstLine[:i]
+	}
+	firstLine = strings.TrimSpace(firstLine)
+
+	switch {
+	case strings.HasPrefix(firstLine, "#cloud-config"):
+		return "text/cloud-config"
+	case strings.HasPrefix(firstLine, "#cloud-boothook"):
+		return "text/cloud-boothook"
+	case strings.HasPrefix(firstLine, "#include"):
+		return "text/x-include-url"
+	case strings.HasPrefix(firstLine, "#part-handler"):
+		return "text/part-handler"
+	case strings.HasPrefix(firstLine, "## template: jinja"):
+		return "text/jinja2"
+	default:
+		return "text/x-shellscript"
+	}
+}
+
+// Combines several rendered templates into a cloud-init MIME multipart archive
+func combineParts(names []string, parts [][]byte) ([]byte, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	for i, part := range parts {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", fmt.Sprintf("%s; charset=\"utf-8\"", contentType(part)))
+		header.Set("MIME-Version", "1.0")
+		header.Set("Content-Transfer-Encoding", "7bit")
+		header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", names[i]))
+
+		partWriter, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = partWriter.Write(part)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var archive bytes.Buffer
+	fmt.Fprintf(&archive, "Content-Type: multipart/mixed; boundary=\"%s\"\nMIME-Version: 1.0\n\n", writer.Boundary())
+	archive.Write(body.Bytes())
+
+	return archive.Bytes(), nil
+}
+
+// Renders one or more named templates from dir into user data, combining several into a cloud-init multipart archive
+func Render(dir string, names []string, variables Variables) ([]byte, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("user data templates are disabled, start the bot with -ud to set a template directory")
+	}
+
+	var parts [][]byte
+	for _, name := range names {
+		part, err := renderTemplate(dir, name, variables)
+		if err != nil {
+			log.Println("Error rendering user data:", err)
+			return nil, err
+		}
+
+		parts = append(parts, part)
+	}
+
+	var userData []byte
+	if len(parts) == 1 {
+		userData = parts[0]
+	} else {
+		var err error
+		userData, err = combineParts(names, parts)
+		if err != nil {
+			log.Println("Error building multipart user data:", err)
+			return nil, err
+		}
+	}
+
+	if len(userData) > MaxSize {
+		return nil, fmt.Errorf("rendered user data is %d bytes, over EC2's %d byte limit", len(userData), MaxSize)
+	}
+
+	return userData, nil
+}
@@ -0,0 +1,156 @@
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/awsclient"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/botconfig"
+)
+
+// SQSQueueAPI defines the interface for the SQS functions used to read EventBridge events from a queue.
+type SQSQueueAPI interface {
+	ReceiveMessage(ctx context.Context,
+		params *sqs.ReceiveMessageInput,
+		optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+
+	DeleteMessage(ctx context.Context,
+		params *sqs.DeleteMessageInput,
+		optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
+}
+
+// The parts of an EventBridge event the watcher reads
+type event struct {
+	DetailType string          `json:"detail-type"`
+	Source     string          `json:"source"`
+	Time       time.Time       `json:"time"`
+	Detail     json.RawMessage `json:"detail"`
+}
+
+// The detail of an "EC2 Instance State-change Notification"
+type stateChangeDetail struct {
+	InstanceId string `json:"instance-id"`
+	State      string `json:"state"`
+}
+
+// The detail of an "AWS Health Event", i.e. scheduled maintenance
+type healthDetail struct {
+	EventArn         string `json:"eventArn"`
+	EventTypeCode    string `json:"eventTypeCode"`
+	StartTime        string `json:"startTime"`
+	EventDescription []struct {
+		LatestDescription string `json:"latestDescription"`
+	} `json:"eventDescription"`
+	AffectedEntities []struct {
+		EntityValue string `json:"entityValue"`
+	} `json:"affectedEntities"`
+}
+
+// Works out the region of a queue from its URL, i.e. https://sqs.eu-west-1.amazonaws.com/123456789012/ec2-events
+func queueRegion(queueUrl string) string {
+	parsed, err := url.Parse(queueUrl)
+	if err != nil {
+		return ""
+	}
+
+	parts := strings.Split(parsed.Hostname(), ".")
+	if len(parts) < 3 || parts[0] != "sqs" {
+		return ""
+	}
+
+	return parts[1]
+}
+
+// Reads events from the queue until the bot exits, deleting each once it's been handled
+func consume(settings botconfig.Watch, clients *awsclient.Pool, w *watcher) {
+	var api SQSQueueAPI = clients.SQS(awsclient.Location{Account: settings.QueueAccount, Region: queueRegion(settings.QueueUrl)})
+
+	log.Println("Watching instances via", settings.QueueUrl)
+	for {
+		result, err := api.ReceiveMessage(context.TODO(), &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(settings.QueueUrl),
+			MaxNumberOfMessages: 10,
+			WaitTimeSeconds:     20,
+		})
+		if err != nil {
+			log.Println("Error receiving events:", err)
+			time.Sleep(30 * time.Second)
+			continue
+		}
+
+		for _, message := range result.Messages {
+			w.handle(aws.ToString(message.Body))
+
+			_, err = api.DeleteMessage(context.TODO(), &sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(settings.QueueUrl),
+				ReceiptHandle: message.ReceiptHandle,
+			})
+			if err != nil {
+				log.Println("Error deleting event:", err)
+			}
+		}
+	}
+}
+
+// Posts an EventBridge event if it's about one of the bot's instances. Anything else on the queue is ignored.
+func (w *watcher) handle(body string) {
+	var e event
+	err := json.Unmarshal([]byte(body), &e)
+	if err != nil {
+		log.Println("Error parsing event:", err)
+		return
+	}
+
+	switch {
+	case e.Source == "aws.ec2" && e.DetailType == "EC2 Instance State-change Notification":
+		var detail stateChangeDetail
+		err = json.Unmarshal(e.Detail, &detail)
+		if err != nil {
+			log.Println("Error parsing state change:", err)
+			return
+		}
+
+		instance, found := w.inv.Find(detail.InstanceId)
+		if !found {
+			return
+		}
+
+		// Every event is a change, so the first one for each instance is posted too
+		w.stateSeen(instance, detail.State, true)
+
+		switch detail.State {
+		case "running", "stopped", "terminated":
+			err = w.inv.RecordTransition(instance.InstanceId, detail.State, e.Time)
+			if err != nil {
+				log.Println("Error saving inventory:", err)
+			}
+		}
+	case e.Source == "aws.health":
+		var detail healthDetail
+		err = json.Unmarshal(e.Detail, &detail)
+		if err != nil {
+			log.Println("Error parsing health event:", err)
+			return
+		}
+
+		description := ""
+		if len(detail.EventDescription) > 0 {
+			description = detail.EventDescription[0].LatestDescription
+		}
+		startTime, _ := time.Parse(time.RFC1123, detail.StartTime)
+
+		for _, entity := range detail.AffectedEntities {
+			instance, found := w.inv.Find(entity.EntityValue)
+			if found {
+				w.eventSeen(instance, detail.EventArn, detail.EventTypeCode, description, startTime)
+			}
+		}
+	}
+}
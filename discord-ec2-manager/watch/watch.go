@@ -0,0 +1,214 @@
+package watch
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/awsclient"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/botconfig"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/cost"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/inventory"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/metrics"
+	"github.com/jacob-howe/discord-ec2-manager/discord-ec2-manager/status"
+)
+
+// EC2WatchAPI defines the interface for the EC2 functions used to poll instances' states, status checks and
+// scheduled events.
+type EC2WatchAPI interface {
+	DescribeInstances(ctx context.Context,
+		params *ec2.DescribeInstancesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error)
+
+	DescribeInstanceStatus(ctx context.Context,
+		params *ec2.DescribeInstanceStatusInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceStatusOutput, error)
+}
+
+// What the watcher has already seen, so each change is only posted once
+type watcher struct {
+	inv  *inventory.Inventory
+	post func(message string, about ...inventory.Instance)
+
+	// The last state seen for each instance ID
+	states map[string]string
+
+	// The impaired status checks last seen for each instance ID, i.e. "system, instance"
+	impaired map[string]string
+
+	// Scheduled events already posted, by instance ID and event ID
+	events map[string]bool
+}
+
+// Posts a message whenever an instance in the bot's inventory changes state, fails a status check or has maintenance
+// scheduled, including changes made outside the bot (the AWS console, a scheduler, or the instance shutting itself
+// down). Runs until the bot exits.
+func Instances(settings botconfig.Watch, inv *inventory.Inventory, clients *awsclient.Pool, post func(message string, about ...inventory.Instance)) {
+	if settings.Disabled {
+		return
+	}
+
+	interval := time.Duration(settings.IntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	w := &watcher{inv: inv, post: post, states: map[string]string{}, impaired: map[string]string{}, events: map[string]bool{}}
+
+	// State changes and scheduled events come from the queue, but status checks still have to be polled
+	queued := settings.QueueUrl != ""
+	if queued {
+		go consume(settings, clients, w)
+	}
+
+	log.Println("Watching instances every", interval)
+	for {
+		locations := inventory.ByLocation(inv.All())
+		for _, location := range inventory.SortedLocations(locations) {
+			var err error
+			if !queued {
+				err = w.pollStates(clients.EC2(location), locations[location])
+			}
+			if err == nil {
+				err = w.pollStatuses(clients.EC2(location), locations[location], !queued)
+			}
+			if err != nil {
+				log.Printf("Error watching instances in %s: %v", location, err)
+			}
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// Describes the instances, posting any state changes
+func (w *watcher) pollStates(api EC2WatchAPI, instanceIds []string) error {
+	now := time.Now()
+
+	// A filter (rather than InstanceIds) means instances that no longer exist are left out instead of failing the call
+	paginator := ec2.NewDescribeInstancesPaginator(api, &ec2.DescribeInstancesInput{
+		Filters: []types.Filter{{Name: aws.String("instance-id"), Values: instanceIds}},
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return err
+		}
+
+		for _, r := range page.Reservations {
+			for _, ec2Instance := range r.Instances {
+				instance, found := w.inv.Find(aws.ToString(ec2Instance.InstanceId))
+				if !found {
+					continue
+				}
+
+				w.stateSeen(instance, string(ec2Instance.State.Name), false)
+				cost.Reconcile(w.inv, instance, ec2Instance, now)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Checks the running instances' status checks (and, with events, their scheduled events), posting anything new
+func (w *watcher) pollStatuses(api EC2WatchAPI, instanceIds []string, events bool) error {
+	statuses, err := status.RunningStatuses(api, instanceIds)
+	if err != nil {
+		return err
+	}
+
+	for instanceId, runningStatus := range statuses {
+		instance, found := w.inv.Find(instanceId)
+		if !found {
+			continue
+		}
+
+		w.checksSeen(instance, status.Impaired(runningStatus))
+		if !events {
+			continue
+		}
+
+		for _, event := range status.ActiveEvents(runningStatus) {
+			w.eventSeen(instance, aws.ToString(event.InstanceEventId), string(event.Code), aws.ToString(event.Description), aws.ToTime(event.NotBefore))
+		}
+	}
+
+	return nil
+}
+
+// Posts when an instance starts failing status checks, and when it recovers
+func (w *watcher) checksSeen(instance inventory.Instance, impaired []string) {
+	current := strings.Join(impaired, ", ")
+	previous := w.impaired[instance.InstanceId]
+	w.impaired[instance.InstanceId] = current
+	if current == previous {
+		return
+	}
+
+	log.Printf("%s impaired status checks changed from %q to %q", instance.Name(), previous, current)
+	if current == "" {
+		w.post(fmt.Sprintf(":white_check_mark: `%s` is passing its status checks again.", instance.Name()), instance)
+		return
+	}
+
+	w.post(fmt.Sprintf(":rotating_light: `%s` is failing its %s status check(s). AWS may be having trouble with its host (system) or the instance may be stuck (instance), try `!console` or `!reboot`.", instance.Name(), current), instance)
+}
+
+// Posts a state change. The first state seen for an instance is only posted with announceFirst, as when polling it's
+// just the state the instance was in when the bot started.
+func (w *watcher) stateSeen(instance inventory.Instance, state string, announceFirst bool) {
+	previous, seen := w.states[instance.InstanceId]
+	w.states[instance.InstanceId] = state
+
+	if seen && previous != state {
+		metrics.InstanceState.Delete(instance.InstanceId, previous)
+	}
+	metrics.InstanceState.Set(1, instance.InstanceId, state)
+
+	if previous == state || (!seen && !announceFirst) {
+		return
+	}
+
+	log.Printf("%s changed from %q to %s", instance.Name(), previous, state)
+	message := fmt.Sprintf("%s `%s` is now `%s`", stateEmoji(state), instance.Name(), state)
+	if seen {
+		message += fmt.Sprintf(" (was `%s`)", previous)
+	}
+	w.post(message, instance)
+}
+
+// Posts a scheduled event the first time it's seen
+func (w *watcher) eventSeen(instance inventory.Instance, eventId string, code string, description string, notBefore time.Time) {
+	key := instance.InstanceId + "/" + eventId
+	if w.events[key] {
+		return
+	}
+	w.events[key] = true
+
+	message := fmt.Sprintf(":wrench: AWS has scheduled maintenance for `%s`: %s", instance.Name(), status.DescribeEvent(code, notBefore, description))
+
+	log.Printf("Scheduled event %s (%s) for %s", eventId, code, instance.Name())
+	w.post(message, instance)
+}
+
+// Picks an emoji for an instance state
+func stateEmoji(state string) string {
+	switch state {
+	case "running":
+		return ":green_circle:"
+	case "stopped":
+		return ":red_circle:"
+	case "terminated":
+		return ":wastebasket:"
+	}
+
+	return ":yellow_circle:"
+}